@@ -3,46 +3,75 @@ package api
 import (
 	log "bedrock-claude-proxy/log"
 	"bedrock-claude-proxy/models"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
-var (
-	jwtKey = []byte("bedrock_claude_proxy_secret_key")
-)
-
 // 登录请求结构
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	TOTPCode string `json:"totp_code"` // 开启 TOTP 后必填
 }
 
-// 登录响应结构
+// 登录响应结构，token 为 access token
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // access token 的有效期，单位秒
+	// 为 true 时需要先调用 /admin/password 修改密码
+	MustChangePassword bool `json:"must_change_password"`
+}
+
+// 修改密码请求结构
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// 刷新 token 请求结构
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// 退出登录请求结构
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"` // 同时撤销的 refresh token
+	All          bool   `json:"all"`           // 撤销该管理员的所有会话
 }
 
 // Claims 结构包含JWT的标准声明和自定义声明
 type Claims struct {
-	Username string `json:"username"`
+	AdminID      uint   `json:"aid"` // 管理员记录的 ID，用户名被删除后重新创建时旧 token 不再有效
+	SessionEpoch int64  `json:"sep"` // 签发时管理员的会话纪元，退出所有会话后不再相等
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	TokenType    string `json:"typ"`
 	jwt.RegisteredClaims
 }
 
-// 哈希密码
-func hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+// loginFailed 记录一次登录失败并写入审计日志，达到限制时锁定用户名或 IP
+func loginFailed(db *gorm.DB, w http.ResponseWriter, r *http.Request, throttler *LoginThrottler, username, ip, message string) {
+	locked := throttler.Failure(username, ip)
+	if locked {
+		log.Logger.Warningf("Admin login locked for user %s or ip %s after too many failed attempts", username, ip)
+	}
+	recordAudit(db, r, models.AuditActionAdminLoginFailed, username, nil, map[string]interface{}{"reason": message, "locked": locked})
+	http.Error(w, message, http.StatusUnauthorized)
 }
 
 // 登录处理函数
-func AdminLogin(db *gorm.DB) http.HandlerFunc {
+func AdminLogin(db *gorm.DB, jwtManager *JWTManager, throttler *LoginThrottler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 只接受POST请求
 		if r.Method != http.MethodPost {
@@ -58,77 +87,146 @@ func AdminLogin(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		// 失败次数过多时在锁定期内直接拒绝，不再校验密码
+		ip := sourceIP(r)
+		if wait := throttler.Check(req.Username, ip); wait > 0 {
+			log.Logger.Warningf("Locked admin login attempt for user %s from %s", req.Username, ip)
+			recordAudit(db, r, models.AuditActionAdminLoginFailed, req.Username, nil, map[string]interface{}{"reason": "Too many failed login attempts", "locked": true})
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+			return
+		}
+
 		// 查询数据库验证用户名和密码
 		var admin models.Admin
 		result := db.Where("username = ?", req.Username).First(&admin)
 		if result.Error != nil {
 			log.Logger.Errorf("Failed to find admin: %v", result.Error)
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			loginFailed(db, w, r, throttler, req.Username, ip, "Invalid credentials")
 			return
 		}
 
 		// 验证密码
-		hashedPassword := hashPassword(req.Password)
-		if hashedPassword != admin.Password {
+		ok, needsUpgrade := models.VerifyAdminPassword(admin.Password, req.Password)
+		if !ok {
 			log.Logger.Warningf("Invalid password attempt for user: %s", req.Username)
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			loginFailed(db, w, r, throttler, req.Username, ip, "Invalid credentials")
 			return
 		}
 
+		// 开启 TOTP 后还需要验证码
+		if admin.TOTPEnabled {
+			if req.TOTPCode == "" {
+				http.Error(w, "TOTP code required", http.StatusUnauthorized)
+				return
+			}
+			if !verifyAdminTOTP(db, &admin, req.TOTPCode) {
+				log.Logger.Warningf("Invalid totp code for user: %s", req.Username)
+				loginFailed(db, w, r, throttler, req.Username, ip, "Invalid TOTP code")
+				return
+			}
+		}
+		throttler.Success(req.Username)
+
+		// 旧版本的 SHA-256 哈希在登录时升级为 bcrypt
+		if needsUpgrade {
+			if hash, err := models.HashAdminPassword(req.Password); err != nil {
+				log.Logger.Errorf("Failed to hash password: %v", err)
+			} else if err := models.UpdateAdminPassword(db, admin.Username, hash, admin.MustChangePassword); err != nil {
+				log.Logger.Errorf("Failed to upgrade password hash for %s: %v", admin.Username, err)
+			} else {
+				log.Logger.Infof("Upgraded password hash for admin: %s", admin.Username)
+			}
+		}
+
 		// 创建JWT Token
-		expirationTime := time.Now().Add(24 * time.Hour)
-		claims := &Claims{
-			Username: req.Username,
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(expirationTime),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-				NotBefore: jwt.NewNumericDate(time.Now()),
-				Issuer:    "bedrock-claude-proxy",
-				Subject:   req.Username,
-			},
-		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString(jwtKey)
+		response, err := jwtManager.IssueTokens(&admin)
 		if err != nil {
 			log.Logger.Errorf("Failed to generate token: %v", err)
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
+		response.MustChangePassword = admin.MustChangePassword
+		recordAudit(db, r.WithContext(SetUsername(r.Context(), admin.Username)), models.AuditActionAdminLogin, admin.Username, nil, nil)
+
 		// 返回token
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(LoginResponse{Token: tokenString})
+		json.NewEncoder(w).Encode(response)
 		log.Logger.Infof("Admin login successful: %s", req.Username)
 	}
 }
 
-// 验证JWT Token并获取用户名
-func GetUsernameFromToken(tokenString string) (string, error) {
-	// 解析token
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// 验证算法
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtKey, nil
-	})
-
+// validateSession 检查 token 是否已被撤销，以及管理员是否存在且未退出所有会话
+func validateSession(db *gorm.DB, claims *Claims) (*models.Admin, error) {
+	revoked, err := models.IsTokenRevoked(db, claims.ID)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
 	}
 
-	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
+	var admin models.Admin
+	if err := db.Where("username = ?", claims.Username).First(&admin).Error; err != nil {
+		return nil, fmt.Errorf("admin not found: %v", err)
+	}
+	if admin.ID != claims.AdminID {
+		return nil, fmt.Errorf("token was issued to a deleted admin")
+	}
+	if claims.SessionEpoch != admin.SessionEpoch {
+		return nil, fmt.Errorf("token was issued before all sessions were revoked")
 	}
+	// 角色变更后旧 token 中的角色不再有效
+	if claims.Role != admin.Role {
+		return nil, fmt.Errorf("role has changed from %s to %s", claims.Role, admin.Role)
+	}
+	return &admin, nil
+}
 
-	// 返回用户名
-	return claims.Username, nil
+// 需要修改密码时仍然可以访问的管理接口
+var passwordChangeRoutes = map[string]bool{
+	"/admin/password": true,
+	"/admin/logout":   true,
+}
+
+// 除 owner 外各角色可以访问的管理接口，未列出的接口只有 owner 可以访问
+var adminRouteRoles = map[string][]string{
+	"/admin/logout":             {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/password":           {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/totp/enroll":        {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/totp/confirm":       {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/totp/disable":       {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/apikey/create":      {models.RoleKeyManager},
+	"/admin/apikey/{id}/delete": {models.RoleKeyManager},
+	"/admin/apikey/list":        {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/apikey/quota":       {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/apikey/enable":      {models.RoleKeyManager},
+	"/admin/apikey/disable":     {models.RoleKeyManager},
+	"/admin/apikey/ratelimit":   {models.RoleKeyManager},
+	"/admin/apikey/budget":      {models.RoleKeyManager},
+	"/admin/apikey/permissions": {models.RoleKeyManager},
+	"/admin/apikey/rotate":      {models.RoleKeyManager},
+	"/admin/usage/list":         {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/usage/summary":      {models.RoleKeyManager, models.RoleBillingViewer},
+	"/admin/usage/export":       {models.RoleKeyManager, models.RoleBillingViewer},
+}
+
+// AdminRoleAllowed 检查角色是否可以访问管理接口，route 为路由模板
+func AdminRoleAllowed(role, route string) bool {
+	if role == models.RoleOwner {
+		return true
+	}
+	for _, allowed := range adminRouteRoles[route] {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
 }
 
 // 验证管理员权限的中间件
-func AdminMiddleware(db *gorm.DB) func(http.Handler) http.Handler {
+func AdminMiddleware(db *gorm.DB, jwtManager *JWTManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// 从Authorization头获取token
@@ -146,27 +244,223 @@ func AdminMiddleware(db *gorm.DB) func(http.Handler) http.Handler {
 
 			// 提取token
 			tokenString := authHeader[7:]
-			username, err := GetUsernameFromToken(tokenString)
+			claims, err := jwtManager.Parse(tokenString, TokenTypeAccess)
 			if err != nil {
 				log.Logger.Errorf("Invalid token: %v", err)
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
-			// 验证该管理员是否存在
-			var admin models.Admin
-			result := db.Where("username = ?", username).First(&admin)
-			if result.Error != nil {
-				log.Logger.Errorf("Admin not found: %v", result.Error)
+			// 验证 token 未被撤销且该管理员存在
+			admin, err := validateSession(db, claims)
+			if err != nil {
+				log.Logger.Errorf("Invalid session for %s: %v", claims.Username, err)
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
 
-			// 请求中存储用户名，以便后续处理函数使用
-			r = r.WithContext(SetUsername(r.Context(), username))
+			// 使用初始密码或默认密码时必须先修改密码
+			if admin.MustChangePassword && !passwordChangeRoutes[r.URL.Path] {
+				http.Error(w, "Password change required", http.StatusForbidden)
+				return
+			}
+
+			// 按角色检查接口权限
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			template, err := route.GetPathTemplate()
+			if err != nil || !AdminRoleAllowed(claims.Role, template) {
+				log.Logger.Warningf("Admin access denied for %s (%s): %s %s", claims.Username, claims.Role, r.Method, r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			// 请求中存储用户名和 token，以便后续处理函数使用
+			ctx := SetUsername(r.Context(), claims.Username)
+			ctx = SetTokenClaims(ctx, claims)
 
 			// 继续执行下一个处理函数
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// AdminRefreshToken 使用 refresh token 换取新的 token，旧的 refresh token 随即失效
+func AdminRefreshToken(db *gorm.DB, jwtManager *JWTManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析请求体
+		var req RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		claims, err := jwtManager.Parse(req.RefreshToken, TokenTypeRefresh)
+		if err != nil {
+			log.Logger.Errorf("Invalid refresh token: %v", err)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		admin, err := validateSession(db, claims)
+		if err != nil {
+			log.Logger.Errorf("Invalid session for %s: %v", claims.Username, err)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+
+		// refresh token 只能使用一次，并发重放同一个 token 时只有一个请求成功
+		if err := models.ConsumeRefreshToken(db, claims.ID, claims.Username, claims.ExpiresAt.Time); err != nil {
+			if errors.Is(err, models.ErrTokenRevoked) {
+				log.Logger.Warningf("Refresh token reused for %s: %s", claims.Username, claims.ID)
+				http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+				return
+			}
+			log.Logger.Errorf("Failed to revoke refresh token: %v", err)
+			http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+			return
+		}
+
+		response, err := jwtManager.IssueTokens(admin)
+		if err != nil {
+			log.Logger.Errorf("Failed to generate token: %v", err)
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r.WithContext(SetUsername(r.Context(), admin.Username)), models.AuditActionAdminRefresh, admin.Username, nil, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		log.Logger.Infof("Admin token refreshed: %s", claims.Username)
+	}
+}
+
+// AdminLogout 撤销当前的 access token，以及请求中的 refresh token 或该管理员的所有会话
+func AdminLogout(db *gorm.DB, jwtManager *JWTManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims, ok := GetTokenClaims(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 请求体可以为空
+		var req LogoutRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if err := models.RevokeToken(db, claims.ID, claims.Username, claims.ExpiresAt.Time); err != nil {
+			log.Logger.Errorf("Failed to revoke token: %v", err)
+			http.Error(w, "Failed to logout", http.StatusInternalServerError)
+			return
+		}
+
+		if req.RefreshToken != "" {
+			refreshClaims, err := jwtManager.Parse(req.RefreshToken, TokenTypeRefresh)
+			if err == nil && refreshClaims.Username == claims.Username {
+				if err := models.RevokeToken(db, refreshClaims.ID, refreshClaims.Username, refreshClaims.ExpiresAt.Time); err != nil {
+					log.Logger.Errorf("Failed to revoke refresh token: %v", err)
+					http.Error(w, "Failed to logout", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		if req.All {
+			if err := models.RevokeAdminTokens(db, claims.Username); err != nil {
+				log.Logger.Errorf("Failed to revoke admin sessions: %v", err)
+				http.Error(w, "Failed to logout", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		recordAudit(db, r, models.AuditActionAdminLogout, claims.Username, nil, map[string]bool{"all": req.All})
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "Logged out successfully"}`))
+		log.Logger.Infof("Admin logout: %s, all sessions: %v", claims.Username, req.All)
+	}
+}
+
+// ChangeAdminPassword 修改当前管理员的密码，修改后该管理员的所有会话失效，需要重新登录
+func ChangeAdminPassword(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		username, ok := GetUsername(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 解析请求体
+		var req ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if len(req.NewPassword) < models.MinAdminPasswordLength {
+			http.Error(w, fmt.Sprintf("New password must be at least %d characters", models.MinAdminPasswordLength), http.StatusBadRequest)
+			return
+		}
+		if req.NewPassword == req.OldPassword || req.NewPassword == models.LegacyDefaultAdminPassword {
+			http.Error(w, "New password must be different from the old password", http.StatusBadRequest)
+			return
+		}
+
+		var admin models.Admin
+		if err := db.Where("username = ?", username).First(&admin).Error; err != nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		if ok, _ := models.VerifyAdminPassword(admin.Password, req.OldPassword); !ok {
+			log.Logger.Warningf("Invalid old password for user: %s", username)
+			http.Error(w, "Invalid old password", http.StatusUnauthorized)
+			return
+		}
+
+		hash, err := models.HashAdminPassword(req.NewPassword)
+		if err != nil {
+			log.Logger.Errorf("Failed to hash password: %v", err)
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+			return
+		}
+		if err := models.UpdateAdminPassword(db, username, hash, false); err != nil {
+			log.Logger.Errorf("Failed to change password: %v", err)
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+			return
+		}
+		if err := models.RevokeAdminTokens(db, username); err != nil {
+			log.Logger.Errorf("Failed to revoke admin sessions: %v", err)
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAdminPassword, username, nil, nil)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "Password changed successfully, please login again"}`))
+		log.Logger.Infof("Admin password changed: %s", username)
+	}
+}
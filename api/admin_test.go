@@ -0,0 +1,35 @@
+package api
+
+import (
+	"testing"
+
+	"bedrock-claude-proxy/models"
+)
+
+func TestAdminRoleAllowed(t *testing.T) {
+	cases := []struct {
+		role     string
+		route    string
+		expected bool
+	}{
+		{models.RoleOwner, "/admin/users/create", true},
+		{models.RoleOwner, "/admin/apikey/create", true},
+		{models.RoleKeyManager, "/admin/apikey/create", true},
+		{models.RoleKeyManager, "/admin/apikey/{id}/delete", true},
+		{models.RoleKeyManager, "/admin/users/list", false},
+		{models.RoleBillingViewer, "/admin/usage/list", true},
+		{models.RoleBillingViewer, "/admin/apikey/quota", true},
+		{models.RoleBillingViewer, "/admin/apikey/create", false},
+		{models.RoleBillingViewer, "/admin/apikey/rotate", false},
+		{models.RoleBillingViewer, "/admin/password", true},
+		// 未知角色和未列出的接口一律拒绝
+		{"", "/admin/usage/list", false},
+		{models.RoleKeyManager, "/admin/unknown", false},
+	}
+	for _, c := range cases {
+		if got := AdminRoleAllowed(c.role, c.route); got != c.expected {
+			t.Errorf("%s %s: got %v, want %v", c.role, c.route, got, c.expected)
+		}
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	log "bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// 创建管理员请求
+type CreateAdminUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"` // 初始密码，首次登录后必须修改
+	Role     string `json:"role"`
+}
+
+// 修改管理员请求，字段为空表示不修改
+type UpdateAdminUserRequest struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"` // 重置密码，下次登录后必须修改
+	Role      string `json:"role"`
+	ResetTOTP bool   `json:"reset_totp"` // 关闭 TOTP，用于丢失验证器的管理员
+}
+
+// 管理员响应
+type AdminUserResponse struct {
+	ID                 uint      `json:"id"`
+	Username           string    `json:"username"`
+	Role               string    `json:"role"`
+	MustChangePassword bool      `json:"must_change_password"`
+	TOTPEnabled        bool      `json:"totp_enabled"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// 管理员列表响应
+type ListAdminUsersResponse struct {
+	Users []AdminUserResponse `json:"users"`
+}
+
+// ensureOwnerRemains 在修改或删除 owner 前检查是否至少还剩一个 owner
+func ensureOwnerRemains(db *gorm.DB, admin *models.Admin) error {
+	if admin.Role != models.RoleOwner {
+		return nil
+	}
+	count, err := models.CountAdminsByRole(db, models.RoleOwner)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return fmt.Errorf("at least one owner is required")
+	}
+	return nil
+}
+
+// 列出所有管理员
+func ListAdminUsers(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受GET请求
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		admins, err := models.ListAdmins(db)
+		if err != nil {
+			log.Logger.Errorf("Failed to fetch admins: %v", err)
+			http.Error(w, "Failed to fetch admins", http.StatusInternalServerError)
+			return
+		}
+
+		response := ListAdminUsersResponse{
+			Users: make([]AdminUserResponse, len(admins)),
+		}
+		for i, admin := range admins {
+			response.Users[i] = AdminUserResponse{
+				ID:                 admin.ID,
+				Username:           admin.Username,
+				Role:               admin.Role,
+				MustChangePassword: admin.MustChangePassword,
+				TOTPEnabled:        admin.TOTPEnabled,
+				CreatedAt:          admin.CreatedAt,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// 创建管理员
+func CreateAdminUser(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析请求体
+		var req CreateAdminUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Logger.Errorf("Failed to decode request: %v", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.Username == "" {
+			http.Error(w, "Username is required", http.StatusBadRequest)
+			return
+		}
+		if err := models.ValidateAdminRole(req.Role); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Password) < models.MinAdminPasswordLength {
+			http.Error(w, fmt.Sprintf("Password must be at least %d characters", models.MinAdminPasswordLength), http.StatusBadRequest)
+			return
+		}
+
+		hash, err := models.HashAdminPassword(req.Password)
+		if err != nil {
+			log.Logger.Errorf("Failed to hash password: %v", err)
+			http.Error(w, "Failed to create admin", http.StatusInternalServerError)
+			return
+		}
+
+		// 初始密码由其他管理员设置，首次登录后必须修改
+		admin := models.Admin{
+			Username:           req.Username,
+			Password:           hash,
+			Role:               req.Role,
+			MustChangePassword: true,
+		}
+		// 用户名有唯一索引，并发创建同名管理员时只有一个成功
+		if err := db.Create(&admin).Error; err != nil {
+			if models.IsDuplicateKeyError(err) {
+				http.Error(w, "Admin with this username already exists", http.StatusConflict)
+				return
+			}
+			log.Logger.Errorf("Failed to save admin: %v", err)
+			http.Error(w, "Failed to create admin", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAdminCreate, admin.Username, nil, &admin)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AdminUserResponse{
+			ID:                 admin.ID,
+			Username:           admin.Username,
+			Role:               admin.Role,
+			MustChangePassword: admin.MustChangePassword,
+			CreatedAt:          admin.CreatedAt,
+		})
+
+		log.Logger.Infof("Admin created: %s (%s)", admin.Username, admin.Role)
+	}
+}
+
+// 修改管理员的角色或重置密码，修改后该管理员需要重新登录
+func UpdateAdminUser(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析请求体
+		var req UpdateAdminUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			log.Logger.Errorf("Failed to decode request: %v", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		if req.Username == "" {
+			http.Error(w, "Username is required", http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" && req.Password == "" && !req.ResetTOTP {
+			http.Error(w, "Role, password or reset_totp is required", http.StatusBadRequest)
+			return
+		}
+		if req.Role != "" {
+			if err := models.ValidateAdminRole(req.Role); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Password != "" && len(req.Password) < models.MinAdminPasswordLength {
+			http.Error(w, fmt.Sprintf("Password must be at least %d characters", models.MinAdminPasswordLength), http.StatusBadRequest)
+			return
+		}
+
+		admin, err := models.GetAdminByUsername(db, req.Username)
+		if err != nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+
+		if req.Role != "" && req.Role != admin.Role {
+			if err := ensureOwnerRemains(db, admin); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err := models.UpdateAdminRole(db, admin.Username, req.Role); err != nil {
+				log.Logger.Errorf("Failed to update admin role: %v", err)
+				http.Error(w, "Failed to update admin", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if req.Password != "" {
+			hash, err := models.HashAdminPassword(req.Password)
+			if err != nil {
+				log.Logger.Errorf("Failed to hash password: %v", err)
+				http.Error(w, "Failed to update admin", http.StatusInternalServerError)
+				return
+			}
+			if err := models.UpdateAdminPassword(db, admin.Username, hash, true); err != nil {
+				log.Logger.Errorf("Failed to reset admin password: %v", err)
+				http.Error(w, "Failed to update admin", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if req.ResetTOTP {
+			if err := models.UpdateAdminTOTP(db, admin.Username, "", false); err != nil {
+				log.Logger.Errorf("Failed to reset admin totp: %v", err)
+				http.Error(w, "Failed to update admin", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// 角色或密码变更后使该管理员的所有会话失效
+		if err := models.RevokeAdminTokens(db, admin.Username); err != nil {
+			log.Logger.Errorf("Failed to revoke admin sessions: %v", err)
+			http.Error(w, "Failed to update admin", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAdminUpdate, admin.Username, admin, adminSnapshot(db, admin.Username))
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "Admin updated successfully"}`))
+		log.Logger.Infof("Admin updated: %s, role: %s, password reset: %v, totp reset: %v", admin.Username, req.Role, req.Password != "", req.ResetTOTP)
+	}
+}
+
+// 删除管理员
+func DeleteAdminUser(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受DELETE请求
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 从URL获取用户名
+		username := mux.Vars(r)["username"]
+		if username == "" {
+			http.Error(w, "Username is required", http.StatusBadRequest)
+			return
+		}
+		if current, _ := GetUsername(r.Context()); current == username {
+			http.Error(w, "Cannot delete the current admin", http.StatusBadRequest)
+			return
+		}
+
+		admin, err := models.GetAdminByUsername(db, username)
+		if err != nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		if err := ensureOwnerRemains(db, admin); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		// 直接删除记录，使用户名可以重新创建
+		if err := db.Unscoped().Delete(admin).Error; err != nil {
+			log.Logger.Errorf("Failed to delete admin: %v", err)
+			http.Error(w, "Failed to delete admin", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAdminDelete, username, admin, nil)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "Admin deleted successfully"}`))
+		log.Logger.Infof("Admin deleted: %s", username)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"time"
 
@@ -16,15 +15,31 @@ import (
 
 // API密钥创建请求
 type CreateAPIKeyRequest struct {
-	Name string `json:"name"`
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at"` // 过期时间，为空表示永不过期
 }
 
 // API密钥响应
 type APIKeyResponse struct {
-	ID        uint      `json:"id"`
-	Name      string    `json:"name"`
-	Value     string    `json:"value"`
-	CreatedAt time.Time `json:"created_at"`
+	ID     uint   `json:"id"`
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"` // 密钥明文，只在创建时返回
+	Prefix string `json:"prefix"`
+	// 过期时间与轮换前的旧密钥
+	ExpiresAt         *time.Time `json:"expires_at"`
+	PreviousPrefix    string     `json:"previous_prefix,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+	RPM               int        `json:"rpm"`
+	InputTPM          int        `json:"input_tpm"`
+	OutputTPM         int        `json:"output_tpm"`
+	// 预算
+	BudgetHardLimit int64  `json:"budget_hard_limit"`
+	BudgetSoftLimit int64  `json:"budget_soft_limit"`
+	BudgetPeriod    string `json:"budget_period"`
+	// 权限
+	AllowedModels []string  `json:"allowed_models"`
+	Scopes        []string  `json:"scopes"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 // 列表响应
@@ -55,6 +70,10 @@ func CreateAPIKey(db *gorm.DB) http.HandlerFunc {
 			http.Error(w, "Name is required", http.StatusBadRequest)
 			return
 		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
 
 		// 检查名称是否已存在
 		var existingKey models.APIKey
@@ -72,10 +91,15 @@ func CreateAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
-		// 创建新的API密钥
+		// 创建新的API密钥，只保存哈希
 		apiKey := models.APIKey{
-			Name:  req.Name,
-			Value: apiKeyValue,
+			Name:      req.Name,
+			ExpiresAt: req.ExpiresAt,
+		}
+		if err := apiKey.SetSecret(apiKeyValue); err != nil {
+			log.Logger.Errorf("Failed to hash API key: %v", err)
+			http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+			return
 		}
 
 		// 保存到数据库
@@ -86,12 +110,16 @@ func CreateAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		recordAudit(db, r, models.AuditActionAPIKeyCreate, apiKey.Name, nil, &apiKey)
+
 		// 返回创建的API密钥
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(APIKeyResponse{
 			ID:        apiKey.ID,
 			Name:      apiKey.Name,
-			Value:     apiKey.Value,
+			Value:     apiKeyValue,
+			Prefix:    apiKey.Prefix,
+			ExpiresAt: apiKey.ExpiresAt,
 			CreatedAt: apiKey.CreatedAt,
 		})
 
@@ -123,10 +151,21 @@ func ListAPIKeys(db *gorm.DB) http.HandlerFunc {
 		}
 		for i, key := range apiKeys {
 			response.APIKeys[i] = APIKeyResponse{
-				ID:        key.ID,
-				Name:      key.Name,
-				Value:     key.Value,
-				CreatedAt: key.CreatedAt,
+				ID:                key.ID,
+				Name:              key.Name,
+				Prefix:            key.Prefix,
+				ExpiresAt:         key.ExpiresAt,
+				PreviousPrefix:    key.PreviousPrefix,
+				PreviousExpiresAt: key.PreviousExpiresAt,
+				RPM:               key.RPM,
+				InputTPM:          key.InputTPM,
+				OutputTPM:         key.OutputTPM,
+				BudgetHardLimit:   key.BudgetHardLimit,
+				BudgetSoftLimit:   key.BudgetSoftLimit,
+				BudgetPeriod:      key.BudgetPeriod,
+				AllowedModels:     key.GetAllowedModels(),
+				Scopes:            key.GetScopes(),
+				CreatedAt:         key.CreatedAt,
 			}
 		}
 
@@ -153,6 +192,13 @@ func DeleteAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		// 审计日志中记录删除前的状态
+		var before models.APIKey
+		if err := db.First(&before, id).Error; err != nil {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
 		// 删除API密钥
 		result := db.Delete(&models.APIKey{}, id)
 		if result.Error != nil {
@@ -166,6 +212,8 @@ func DeleteAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		recordAudit(db, r, models.AuditActionAPIKeyDelete, before.Name, &before, nil)
+
 		// 返回成功
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"message": "API key deleted successfully"}`))
@@ -180,7 +228,7 @@ func generateAPIKey() (string, error) {
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("bk-%s", hex.EncodeToString(bytes)), nil
+	return models.APIKeyValuePrefix + hex.EncodeToString(bytes), nil
 }
 
 // EnableAPIKey 启用API密钥
@@ -209,6 +257,12 @@ func EnableAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		before := apiKeySnapshot(db, req.Name)
+		if before == nil {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
 		// 更新API密钥状态为启用
 		if err := models.UpdateAPIKeyStatusByName(db, req.Name, true); err != nil {
 			log.Logger.Errorf("Failed to enable API key: %v", err)
@@ -216,6 +270,8 @@ func EnableAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		recordAudit(db, r, models.AuditActionAPIKeyEnable, req.Name, before, apiKeySnapshot(db, req.Name))
+
 		// 返回成功
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"message": "API key enabled successfully"}`))
@@ -250,6 +306,12 @@ func DisableAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		before := apiKeySnapshot(db, req.Name)
+		if before == nil {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
 		// 更新API密钥状态为禁用
 		if err := models.UpdateAPIKeyStatusByName(db, req.Name, false); err != nil {
 			log.Logger.Errorf("Failed to disable API key: %v", err)
@@ -257,6 +319,8 @@ func DisableAPIKey(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		recordAudit(db, r, models.AuditActionAPIKeyDisable, req.Name, before, apiKeySnapshot(db, req.Name))
+
 		// 返回成功
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"message": "API key disabled successfully"}`))
@@ -264,3 +328,283 @@ func DisableAPIKey(db *gorm.DB) http.HandlerFunc {
 		log.Logger.Infof("API key disabled: %s", req.Name)
 	}
 }
+
+// API密钥速率限制请求，0 表示不限制
+type UpdateAPIKeyRateLimitRequest struct {
+	Name      string `json:"name"`
+	RPM       int    `json:"rpm"`
+	InputTPM  int    `json:"input_tpm"`
+	OutputTPM int    `json:"output_tpm"`
+}
+
+// UpdateAPIKeyRateLimit 设置API密钥的速率限制
+func UpdateAPIKeyRateLimit(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析请求体
+		var req UpdateAPIKeyRateLimitRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Logger.Errorf("Failed to decode request: %v", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		// 验证参数
+		if req.Name == "" {
+			http.Error(w, "API key name is required", http.StatusBadRequest)
+			return
+		}
+		if req.RPM < 0 || req.InputTPM < 0 || req.OutputTPM < 0 {
+			http.Error(w, "Rate limits must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		// 更新速率限制
+		before := apiKeySnapshot(db, req.Name)
+		rows, err := models.UpdateAPIKeyRateLimitByName(db, req.Name, req.RPM, req.InputTPM, req.OutputTPM)
+		if err != nil {
+			log.Logger.Errorf("Failed to update API key rate limit: %v", err)
+			http.Error(w, "Failed to update API key rate limit", http.StatusInternalServerError)
+			return
+		}
+		if rows == 0 {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAPIKeyRateLimit, req.Name, before, apiKeySnapshot(db, req.Name))
+
+		// 返回成功
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "API key rate limit updated successfully"}`))
+
+		log.Logger.Infof("API key rate limit updated: %s, rpm=%d, input_tpm=%d, output_tpm=%d",
+			req.Name, req.RPM, req.InputTPM, req.OutputTPM)
+	}
+}
+
+// API密钥预算请求，额度单位与使用记录的 quota 相同，0 表示不限制
+type UpdateAPIKeyBudgetRequest struct {
+	Name      string `json:"name"`
+	HardLimit int64  `json:"hard_limit"`
+	SoftLimit int64  `json:"soft_limit"`
+	Period    string `json:"period"`
+}
+
+// UpdateAPIKeyBudget 设置API密钥的预算
+func UpdateAPIKeyBudget(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析请求体
+		var req UpdateAPIKeyBudgetRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Logger.Errorf("Failed to decode request: %v", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		// 验证参数
+		if req.Name == "" {
+			http.Error(w, "API key name is required", http.StatusBadRequest)
+			return
+		}
+		if req.HardLimit < 0 || req.SoftLimit < 0 {
+			http.Error(w, "Budget limits must not be negative", http.StatusBadRequest)
+			return
+		}
+		if req.Period == "" {
+			req.Period = models.BudgetPeriodMonthly
+		}
+		if err := models.ValidateBudgetPeriod(req.Period); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// 更新预算
+		before := apiKeySnapshot(db, req.Name)
+		rows, err := models.UpdateAPIKeyBudgetByName(db, req.Name, req.HardLimit, req.SoftLimit, req.Period)
+		if err != nil {
+			log.Logger.Errorf("Failed to update API key budget: %v", err)
+			http.Error(w, "Failed to update API key budget", http.StatusInternalServerError)
+			return
+		}
+		if rows == 0 {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAPIKeyBudget, req.Name, before, apiKeySnapshot(db, req.Name))
+
+		// 返回成功
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "API key budget updated successfully"}`))
+
+		log.Logger.Infof("API key budget updated: %s, hard_limit=%d, soft_limit=%d, period=%s",
+			req.Name, req.HardLimit, req.SoftLimit, req.Period)
+	}
+}
+
+// API密钥权限请求，allowed_models 为空表示不限制模型，scopes 为空表示默认的非管理接口
+type UpdateAPIKeyPermissionsRequest struct {
+	Name          string   `json:"name"`
+	AllowedModels []string `json:"allowed_models"`
+	Scopes        []string `json:"scopes"`
+}
+
+// UpdateAPIKeyPermissions 设置API密钥允许使用的模型和接口范围
+func UpdateAPIKeyPermissions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析请求体
+		var req UpdateAPIKeyPermissionsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Logger.Errorf("Failed to decode request: %v", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		// 验证参数
+		if req.Name == "" {
+			http.Error(w, "API key name is required", http.StatusBadRequest)
+			return
+		}
+		if err := models.ValidateModelPatterns(req.AllowedModels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := models.ValidateScopes(req.Scopes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// 更新权限
+		before := apiKeySnapshot(db, req.Name)
+		rows, err := models.UpdateAPIKeyPermissionsByName(db, req.Name, req.AllowedModels, req.Scopes)
+		if err != nil {
+			log.Logger.Errorf("Failed to update API key permissions: %v", err)
+			http.Error(w, "Failed to update API key permissions", http.StatusInternalServerError)
+			return
+		}
+		if rows == 0 {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAPIKeyPermissions, req.Name, before, apiKeySnapshot(db, req.Name))
+
+		// 返回成功
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "API key permissions updated successfully"}`))
+
+		log.Logger.Infof("API key permissions updated: %s, allowed_models=%v, scopes=%v",
+			req.Name, req.AllowedModels, req.Scopes)
+	}
+}
+
+// API密钥轮换请求
+type RotateAPIKeyRequest struct {
+	Name        string     `json:"name"`
+	GracePeriod string     `json:"grace_period"` // 旧密钥的有效期，如 "24h"，默认 24 小时
+	ExpiresAt   *time.Time `json:"expires_at"`   // 新密钥的过期时间，为空表示永不过期
+}
+
+// RotateAPIKey 为API密钥生成新的密钥，旧密钥在宽限期内仍然有效
+func RotateAPIKey(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析请求体
+		var req RotateAPIKeyRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			log.Logger.Errorf("Failed to decode request: %v", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		// 验证参数
+		if req.Name == "" {
+			http.Error(w, "API key name is required", http.StatusBadRequest)
+			return
+		}
+		gracePeriod := models.DefaultRotationGracePeriod
+		if req.GracePeriod != "" {
+			gracePeriod, err = time.ParseDuration(req.GracePeriod)
+			if err != nil || gracePeriod < 0 {
+				http.Error(w, "Invalid grace period", http.StatusBadRequest)
+				return
+			}
+		}
+		now := time.Now()
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		apiKey, err := models.GetAPIKeyByName(db, req.Name)
+		if err != nil {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		before := apiKey
+
+		// 生成新的密钥
+		apiKeyValue, err := generateAPIKey()
+		if err != nil {
+			log.Logger.Errorf("Failed to generate API key: %v", err)
+			http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+			return
+		}
+		if err := apiKey.Rotate(apiKeyValue, gracePeriod, req.ExpiresAt, now); err != nil {
+			log.Logger.Errorf("Failed to hash API key: %v", err)
+			http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+			return
+		}
+		if err := models.SaveAPIKeySecret(db, &apiKey); err != nil {
+			log.Logger.Errorf("Failed to rotate API key: %v", err)
+			http.Error(w, "Failed to rotate API key", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAPIKeyRotate, apiKey.Name, &before, &apiKey)
+
+		// 返回新的密钥，明文只返回一次
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(APIKeyResponse{
+			ID:                apiKey.ID,
+			Name:              apiKey.Name,
+			Value:             apiKeyValue,
+			Prefix:            apiKey.Prefix,
+			ExpiresAt:         apiKey.ExpiresAt,
+			PreviousPrefix:    apiKey.PreviousPrefix,
+			PreviousExpiresAt: apiKey.PreviousExpiresAt,
+			CreatedAt:         apiKey.CreatedAt,
+		})
+
+		log.Logger.Infof("API key rotated: %s, previous key %s valid until %s",
+			req.Name, apiKey.PreviousPrefix, apiKey.PreviousExpiresAt.Format(time.RFC3339))
+	}
+}
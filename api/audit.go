@@ -0,0 +1,168 @@
+package api
+
+import (
+	log "bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审计日志列表响应
+type ListAuditLogsResponse struct {
+	Total int64          `json:"total"`
+	Items []AuditLogItem `json:"items"`
+}
+
+// 审计日志项目，before 和 after 为操作前后的状态
+type AuditLogItem struct {
+	ID        uint            `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	SourceIP  string          `json:"source_ip"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// sourceIP 返回请求的来源地址。优先使用 ClientIPMiddleware 解析的地址，
+// 否则不信任 X-Forwarded-For，部署在负载均衡之后时记录的是负载均衡的地址
+func sourceIP(r *http.Request) string {
+	if ip, ok := GetClientIP(r.Context()); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NewAuditLog 根据请求创建审计记录，操作人取自上下文中的管理员用户名，before 和 after 为 nil 时表示不存在
+func NewAuditLog(r *http.Request, action, target string, before, after interface{}) *models.AuditLog {
+	actor, _ := GetUsername(r.Context())
+	auditLog := &models.AuditLog{
+		Actor:    actor,
+		Action:   action,
+		Target:   target,
+		SourceIP: sourceIP(r),
+	}
+	if before != nil {
+		if data, err := json.Marshal(before); err == nil {
+			auditLog.Before = string(data)
+		}
+	}
+	if after != nil {
+		if data, err := json.Marshal(after); err == nil {
+			auditLog.After = string(data)
+		}
+	}
+	return auditLog
+}
+
+// recordAudit 保存审计记录，失败时只记录日志，不影响已经完成的操作
+func recordAudit(db *gorm.DB, r *http.Request, action, target string, before, after interface{}) {
+	auditLog := NewAuditLog(r, action, target, before, after)
+	if err := models.CreateAuditLog(db, auditLog); err != nil {
+		log.Logger.Errorf("Failed to save audit log %s %s by %s: %v", action, target, auditLog.Actor, err)
+	}
+}
+
+// apiKeySnapshot 获取 API Key 的当前状态用于审计，不包含密钥哈希，不存在时返回 nil
+func apiKeySnapshot(db *gorm.DB, name string) *models.APIKey {
+	apiKey, err := models.GetAPIKeyByName(db, name)
+	if err != nil {
+		return nil
+	}
+	return &apiKey
+}
+
+// adminSnapshot 获取管理员的当前状态用于审计，不包含密码哈希，不存在时返回 nil
+func adminSnapshot(db *gorm.DB, username string) *models.Admin {
+	admin, err := models.GetAdminByUsername(db, username)
+	if err != nil {
+		return nil
+	}
+	return admin
+}
+
+// rawJSON 将保存的 JSON 文本转换为响应中的 JSON 值，为空时返回 null
+func rawJSON(data string) json.RawMessage {
+	if data == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(data)
+}
+
+// 列出审计日志
+func ListAuditLogs(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受GET请求
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析分页参数
+		page := 1
+		pageSize := 20
+
+		if pageParam := r.URL.Query().Get("page"); pageParam != "" {
+			if p, err := strconv.Atoi(pageParam); err == nil && p > 0 {
+				page = p
+			}
+		}
+
+		if pageSizeParam := r.URL.Query().Get("page_size"); pageSizeParam != "" {
+			if ps, err := strconv.Atoi(pageSizeParam); err == nil && ps > 0 && ps <= 100 {
+				pageSize = ps
+			}
+		}
+
+		// 解析过滤参数
+		filter := models.AuditLogFilter{
+			Actor:  r.URL.Query().Get("actor"),
+			Action: r.URL.Query().Get("action"),
+			Target: r.URL.Query().Get("target"),
+		}
+		start, end, err := ParseDateRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Start, filter.End = start, end
+
+		auditLogs, total, err := models.ListAuditLogs(db, filter, page, pageSize)
+		if err != nil {
+			log.Logger.Errorf("Failed to fetch audit logs: %v", err)
+			http.Error(w, "Failed to fetch audit logs", http.StatusInternalServerError)
+			return
+		}
+
+		// 转换为响应格式
+		response := ListAuditLogsResponse{
+			Total: total,
+			Items: make([]AuditLogItem, len(auditLogs)),
+		}
+		for i, auditLog := range auditLogs {
+			response.Items[i] = AuditLogItem{
+				ID:        auditLog.ID,
+				Actor:     auditLog.Actor,
+				Action:    auditLog.Action,
+				Target:    auditLog.Target,
+				Before:    rawJSON(auditLog.Before),
+				After:     rawJSON(auditLog.After),
+				SourceIP:  auditLog.SourceIP,
+				CreatedAt: auditLog.CreatedAt,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
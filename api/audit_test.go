@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bedrock-claude-proxy/models"
+)
+
+func TestNewAuditLog(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/admin/apikey/disable", nil)
+	request.RemoteAddr = "10.0.0.8:52311"
+	request.Header.Set("X-Forwarded-For", "1.2.3.4")
+	request = request.WithContext(SetUsername(request.Context(), "alice"))
+
+	before := &models.APIKey{Name: "ci_key", Enable: true, Prefix: "bk-1a2b3c4d", Salt: "salt", Hash: "hash"}
+	after := &models.APIKey{Name: "ci_key", Enable: false, Prefix: "bk-1a2b3c4d", Salt: "salt", Hash: "hash"}
+	auditLog := NewAuditLog(request, models.AuditActionAPIKeyDisable, "ci_key", before, after)
+
+	if auditLog.Actor != "alice" || auditLog.Action != models.AuditActionAPIKeyDisable || auditLog.Target != "ci_key" {
+		t.Fatalf("unexpected audit log: %+v", auditLog)
+	}
+	// 不信任 X-Forwarded-For
+	if auditLog.SourceIP != "10.0.0.8" {
+		t.Fatalf("unexpected source ip: %s", auditLog.SourceIP)
+	}
+	if !strings.Contains(auditLog.Before, `"enable":true`) || !strings.Contains(auditLog.After, `"enable":false`) {
+		t.Fatalf("unexpected snapshots: %s -> %s", auditLog.Before, auditLog.After)
+	}
+	// 快照中不包含密钥哈希
+	if strings.Contains(auditLog.Before, "hash") || strings.Contains(auditLog.After, "salt") {
+		t.Fatalf("snapshot must not contain secrets: %s", auditLog.Before)
+	}
+
+	// 创建时没有操作前的状态
+	auditLog = NewAuditLog(request, models.AuditActionAPIKeyCreate, "ci_key", nil, after)
+	if auditLog.Before != "" || auditLog.After == "" {
+		t.Fatalf("unexpected snapshots for create: %q -> %q", auditLog.Before, auditLog.After)
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies 可信的反向代理或负载均衡地址。
+// 只有请求直接来自这些地址时才读取 X-Forwarded-For / X-Real-IP，否则这些请求头可以被客户端伪造
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies 解析逗号分隔的 IP 或 CIDR，如 10.0.0.0/8,192.168.1.10
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %v", item, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Contains 判断地址是否属于可信代理
+func (this TrustedProxies) Contains(address string) bool {
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if ip == nil {
+		return false
+	}
+	for _, network := range this {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP 返回请求的客户端地址。请求来自可信代理时，从右向左跳过 X-Forwarded-For 中的可信代理，
+// 取第一个不可信的地址；没有 X-Forwarded-For 时使用 X-Real-IP
+func (this TrustedProxies) ClientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !this.Contains(peer) {
+		return peer
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(address) == nil {
+			break
+		}
+		if !this.Contains(address) || i == 0 {
+			return address
+		}
+	}
+	if address := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(address) != nil {
+		return address
+	}
+	return peer
+}
+
+// ClientIPMiddleware 解析客户端地址并存储在上下文中，供登录限制和审计日志使用
+func ClientIPMiddleware(proxies TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(SetClientIP(r.Context(), proxies.ClientIP(r))))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bedrock-claude-proxy/models"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Fatal("expected error for invalid cidr")
+	}
+
+	cases := []struct {
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		// 来自负载均衡的请求使用 X-Forwarded-For 中的客户端地址
+		{"10.0.0.8:52311", "1.2.3.4", "", "1.2.3.4"},
+		// 跳过链路上的可信代理，客户端伪造的前缀被忽略
+		{"10.0.0.8:52311", "6.6.6.6, 1.2.3.4, 192.168.1.10", "", "1.2.3.4"},
+		{"192.168.1.10:52311", "", "5.6.7.8", "5.6.7.8"},
+		// 来自不可信地址的请求不读取转发请求头
+		{"8.8.8.8:52311", "1.2.3.4", "5.6.7.8", "8.8.8.8"},
+		{"10.0.0.8:52311", "", "", "10.0.0.8"},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodPost, "/login/admin", nil)
+		request.RemoteAddr = c.remoteAddr
+		if c.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			request.Header.Set("X-Real-IP", c.realIP)
+		}
+		if ip := proxies.ClientIP(request); ip != c.expected {
+			t.Fatalf("%s %q: got %s, want %s", c.remoteAddr, c.forwarded, ip, c.expected)
+		}
+	}
+
+	// 审计日志使用中间件解析的客户端地址
+	request := httptest.NewRequest(http.MethodPost, "/admin/apikey/disable", nil)
+	request.RemoteAddr = "10.0.0.8:52311"
+	request.Header.Set("X-Forwarded-For", "1.2.3.4")
+	var auditLog *models.AuditLog
+	ClientIPMiddleware(proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auditLog = NewAuditLog(r, models.AuditActionAPIKeyDisable, "ci_key", nil, nil)
+	})).ServeHTTP(httptest.NewRecorder(), request)
+	if auditLog == nil || auditLog.SourceIP != "1.2.3.4" {
+		t.Fatalf("unexpected audit log: %+v", auditLog)
+	}
+	t.Log("PASS")
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bedrock-claude-proxy/models"
 	"context"
 )
 
@@ -10,6 +11,9 @@ type contextKey string
 // 上下文键常量
 const (
 	usernameKey contextKey = "username"
+	apiKeyKey   contextKey = "apikey"
+	claimsKey   contextKey = "claims"
+	clientIPKey contextKey = "client_ip"
 )
 
 // SetUsername 将用户名存储在上下文中
@@ -22,3 +26,36 @@ func GetUsername(ctx context.Context) (string, bool) {
 	username, ok := ctx.Value(usernameKey).(string)
 	return username, ok
 }
+
+// SetAPIKey 将通过验证的 API Key 存储在上下文中
+func SetAPIKey(ctx context.Context, apiKey *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey, apiKey)
+}
+
+// GetAPIKey 从上下文中获取通过验证的 API Key
+func GetAPIKey(ctx context.Context) (*models.APIKey, bool) {
+	apiKey, ok := ctx.Value(apiKeyKey).(*models.APIKey)
+	return apiKey, ok
+}
+
+// SetTokenClaims 将管理员 token 的声明存储在上下文中
+func SetTokenClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// GetTokenClaims 从上下文中获取管理员 token 的声明
+func GetTokenClaims(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// SetClientIP 将解析后的客户端地址存储在上下文中
+func SetClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// GetClientIP 从上下文中获取客户端地址
+func GetClientIP(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey).(string)
+	return ip, ok
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ParseDateRange 解析查询参数中的 start_time 和 end_time，日期格式为 2006-01-02，
+// 按服务器本地时区解释，end_time 包含当天。使用记录和审计日志接口共用，保证同一日期对应同一时间范围
+func ParseDateRange(query url.Values) (start, end *time.Time, err error) {
+	if startTimeStr := query.Get("start_time"); startTimeStr != "" {
+		startTime, err := time.ParseInLocation("2006-01-02", startTimeStr, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid start_time %s", startTimeStr)
+		}
+		start = &startTime
+	}
+	if endTimeStr := query.Get("end_time"); endTimeStr != "" {
+		endTime, err := time.ParseInLocation("2006-01-02", endTimeStr, time.Local)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid end_time %s", endTimeStr)
+		}
+		// 将结束日期设置为当天的最后一刻
+		endTime = endTime.Add(24*time.Hour - time.Second)
+		end = &endTime
+	}
+	return start, end, nil
+}
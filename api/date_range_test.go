@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseDateRange(t *testing.T) {
+	start, end, err := ParseDateRange(url.Values{"start_time": {"2024-01-02"}, "end_time": {"2024-01-03"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 审计日志与使用记录按同一时区解释日期
+	if !start.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("unexpected start: %v", start)
+	}
+	if !end.Equal(time.Date(2024, 1, 3, 23, 59, 59, 0, time.Local)) {
+		t.Fatalf("unexpected end: %v", end)
+	}
+
+	start, end, err = ParseDateRange(url.Values{})
+	if err != nil || start != nil || end != nil {
+		t.Fatalf("expected empty range, got %v %v %v", start, end, err)
+	}
+
+	if _, _, err := ParseDateRange(url.Values{"end_time": {"2024/01/03"}}); err == nil {
+		t.Fatal("expected error for invalid end_time")
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	log "bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 管理员 token 类型
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// 管理员 token 的默认有效期
+const (
+	DefaultAccessTokenTTL  = time.Hour
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// JWTConfig 管理员 token 的签名密钥与有效期
+type JWTConfig struct {
+	Keys            map[string]string // kid -> 签名密钥，轮换时同时配置新旧密钥
+	Kid             string            // 签发新 token 使用的 kid，只配置一个密钥时可以为空
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// JWTManager 签发和校验管理员 token。
+// 新 token 使用当前 kid 的密钥签名，校验时按 token 头部的 kid 选择密钥，因此轮换密钥时旧 token 在过期前仍然有效。
+type JWTManager struct {
+	keys            map[string][]byte
+	kid             string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	now             func() time.Time
+}
+
+func NewJWTManager(conf JWTConfig) (*JWTManager, error) {
+	manager := &JWTManager{
+		keys:            make(map[string][]byte),
+		kid:             conf.Kid,
+		accessTokenTTL:  conf.AccessTokenTTL,
+		refreshTokenTTL: conf.RefreshTokenTTL,
+		now:             time.Now,
+	}
+	if manager.accessTokenTTL <= 0 {
+		manager.accessTokenTTL = DefaultAccessTokenTTL
+	}
+	if manager.refreshTokenTTL <= 0 {
+		manager.refreshTokenTTL = DefaultRefreshTokenTTL
+	}
+
+	for kid, key := range conf.Keys {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("jwt key %s is empty", kid)
+		}
+		manager.keys[kid] = []byte(key)
+	}
+
+	if len(manager.keys) == 0 {
+		// 未配置密钥时使用随机密钥，重启后或多实例之间 token 不通用
+		log.Logger.Warning("No admin JWT key configured, using a random key; admin tokens will not survive restarts or work across replicas")
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		manager.kid = "ephemeral"
+		manager.keys[manager.kid] = key
+		return manager, nil
+	}
+
+	if manager.kid == "" {
+		if len(manager.keys) > 1 {
+			return nil, fmt.Errorf("jwt kid is required when multiple keys are configured")
+		}
+		for kid := range manager.keys {
+			manager.kid = kid
+		}
+	}
+	if _, ok := manager.keys[manager.kid]; !ok {
+		return nil, fmt.Errorf("jwt kid %s has no key", manager.kid)
+	}
+	return manager, nil
+}
+
+// newTokenId 生成 token 的唯一 ID，用于撤销
+func newTokenId() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func (this *JWTManager) issue(admin *models.Admin, tokenType string, ttl time.Duration) (string, error) {
+	jti, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
+	now := this.now()
+	claims := &Claims{
+		AdminID:      admin.ID,
+		SessionEpoch: admin.SessionEpoch,
+		Username:     admin.Username,
+		Role:         admin.Role,
+		TokenType:    tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "bedrock-claude-proxy",
+			Subject:   admin.Username,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = this.kid
+	return token.SignedString(this.keys[this.kid])
+}
+
+// IssueTokens 为管理员签发 access token 和 refresh token，token 中携带管理员的 ID、会话纪元和角色
+func (this *JWTManager) IssueTokens(admin *models.Admin) (*LoginResponse, error) {
+	accessToken, err := this.issue(admin, TokenTypeAccess, this.accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := this.issue(admin, TokenTypeRefresh, this.refreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(this.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Parse 校验 token 的签名、有效期和类型
+func (this *JWTManager) Parse(tokenString string, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// 验证算法
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := this.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid: %s", kid)
+		}
+		return key, nil
+	}, jwt.WithTimeFunc(this.now), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("unexpected token type: %s", claims.TokenType)
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return nil, fmt.Errorf("token is missing required claims")
+	}
+	return claims, nil
+}
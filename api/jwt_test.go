@@ -0,0 +1,86 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"bedrock-claude-proxy/models"
+
+	"gorm.io/gorm"
+)
+
+func TestJWTManager_Rotation(t *testing.T) {
+	oldManager, err := NewJWTManager(JWTConfig{
+		Keys: map[string]string{"2024": "old-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := oldManager.IssueTokens(&models.Admin{Model: gorm.Model{ID: 1}, Username: "proxy", Role: models.RoleOwner, SessionEpoch: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.ExpiresIn != int(DefaultAccessTokenTTL.Seconds()) {
+		t.Fatalf("unexpected expires in: %d", tokens.ExpiresIn)
+	}
+
+	// 轮换后使用新密钥签发，旧 token 在过期前仍然有效
+	newManager, err := NewJWTManager(JWTConfig{
+		Keys: map[string]string{"2024": "old-secret", "2025": "new-secret"},
+		Kid:  "2025",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := newManager.Parse(tokens.Token, TokenTypeAccess)
+	if err != nil || claims.AdminID != 1 || claims.SessionEpoch != 3 || claims.Username != "proxy" || claims.Role != models.RoleOwner || claims.ID == "" {
+		t.Fatalf("old token should be valid after rotation: %v", err)
+	}
+
+	// 移除旧密钥后旧 token 失效
+	retiredManager, _ := NewJWTManager(JWTConfig{Keys: map[string]string{"2025": "new-secret"}})
+	if _, err := retiredManager.Parse(tokens.Token, TokenTypeAccess); err == nil {
+		t.Fatal("token signed with retired key should be rejected")
+	}
+
+	// refresh token 不能当作 access token 使用
+	if _, err := newManager.Parse(tokens.RefreshToken, TokenTypeAccess); err == nil {
+		t.Fatal("refresh token should not be accepted as access token")
+	}
+	if _, err := newManager.Parse(tokens.RefreshToken, TokenTypeRefresh); err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置多个密钥时必须指定 kid
+	if _, err := NewJWTManager(JWTConfig{Keys: map[string]string{"a": "1", "b": "2"}}); err == nil {
+		t.Fatal("expected error without kid")
+	}
+	t.Log("PASS")
+}
+
+func TestJWTManager_TTL(t *testing.T) {
+	manager, err := NewJWTManager(JWTConfig{
+		Keys:           map[string]string{"default": "secret"},
+		AccessTokenTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, _ := manager.IssueTokens(&models.Admin{Model: gorm.Model{ID: 1}, Username: "proxy", Role: models.RoleOwner, SessionEpoch: 3})
+	if tokens.ExpiresIn != 60 {
+		t.Fatalf("unexpected expires in: %d", tokens.ExpiresIn)
+	}
+	if _, err := manager.Parse(tokens.Token, TokenTypeAccess); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未配置密钥时使用随机密钥，不同实例之间 token 不通用
+	ephemeral, err := NewJWTManager(JWTConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ephemeral.Parse(tokens.Token, TokenTypeAccess); err == nil {
+		t.Fatal("token from another key should be rejected")
+	}
+	t.Log("PASS")
+}
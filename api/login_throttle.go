@@ -0,0 +1,140 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// 管理员登录失败的默认限制
+const (
+	DefaultLoginMaxFailures   = 5                // 同一用户名连续失败次数
+	DefaultLoginMaxIPFailures = 20               // 同一 IP 失败次数，包括不存在的用户名
+	DefaultLoginLockout       = 15 * time.Minute // 统计窗口和锁定时长
+)
+
+// LoginThrottleConfig 登录失败的限制，0 表示使用默认值
+type LoginThrottleConfig struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Lockout       time.Duration
+}
+
+type loginFailures struct {
+	count       int
+	first       time.Time // 本轮统计窗口的开始时间
+	lockedUntil time.Time
+}
+
+// LoginThrottler 按用户名和来源 IP 统计登录失败次数，超过限制后在 lockout 内拒绝登录。
+// 计数保存在本实例内存中，多实例部署时每个实例分别计数。
+type LoginThrottler struct {
+	mutex         sync.Mutex
+	failures      map[string]*loginFailures
+	maxFailures   int
+	maxIPFailures int
+	lockout       time.Duration
+	lastCleanup   time.Time
+	now           func() time.Time
+}
+
+func NewLoginThrottler(conf LoginThrottleConfig) *LoginThrottler {
+	throttler := &LoginThrottler{
+		failures:      make(map[string]*loginFailures),
+		maxFailures:   conf.MaxFailures,
+		maxIPFailures: conf.MaxIPFailures,
+		lockout:       conf.Lockout,
+		now:           time.Now,
+	}
+	if throttler.maxFailures <= 0 {
+		throttler.maxFailures = DefaultLoginMaxFailures
+	}
+	if throttler.maxIPFailures <= 0 {
+		throttler.maxIPFailures = DefaultLoginMaxIPFailures
+	}
+	if throttler.lockout <= 0 {
+		throttler.lockout = DefaultLoginLockout
+	}
+	return throttler
+}
+
+func loginUserKey(username string) string {
+	return "user:" + username
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// lockedFor 返回 key 剩余的锁定时间，调用方需持有锁
+func (this *LoginThrottler) lockedFor(key string, now time.Time) time.Duration {
+	entry, ok := this.failures[key]
+	if !ok || !now.Before(entry.lockedUntil) {
+		return 0
+	}
+	return entry.lockedUntil.Sub(now)
+}
+
+// Check 返回用户名或 IP 剩余的锁定时间，0 表示允许尝试登录
+func (this *LoginThrottler) Check(username, ip string) time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.now()
+	userWait := this.lockedFor(loginUserKey(username), now)
+	ipWait := this.lockedFor(loginIPKey(ip), now)
+	if ipWait > userWait {
+		return ipWait
+	}
+	return userWait
+}
+
+// record 记录一次失败，达到 max 次时开始锁定，返回是否因此被锁定。调用方需持有锁
+func (this *LoginThrottler) record(key string, max int, now time.Time) bool {
+	entry, ok := this.failures[key]
+	if !ok || now.Sub(entry.first) >= this.lockout {
+		entry = &loginFailures{first: now}
+		this.failures[key] = entry
+	}
+	entry.count++
+	if entry.count >= max {
+		entry.lockedUntil = now.Add(this.lockout)
+		// 锁定结束后重新计数
+		entry.count = 0
+		entry.first = now.Add(this.lockout)
+		return true
+	}
+	return false
+}
+
+// Failure 记录一次登录失败，返回用户名或 IP 是否因此被锁定
+func (this *LoginThrottler) Failure(username, ip string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.now()
+	this.cleanup(now)
+	userLocked := this.record(loginUserKey(username), this.maxFailures, now)
+	ipLocked := this.record(loginIPKey(ip), this.maxIPFailures, now)
+	return userLocked || ipLocked
+}
+
+// Success 登录成功后清除该用户名的失败次数，IP 的计数保留，避免用一个有效账户重置其他账户的尝试次数
+func (this *LoginThrottler) Success(username string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(this.failures, loginUserKey(username))
+}
+
+// cleanup 删除窗口和锁定都已过期的记录，避免内存随尝试的用户名和 IP 无限增长。调用方需持有锁
+func (this *LoginThrottler) cleanup(now time.Time) {
+	if now.Sub(this.lastCleanup) < time.Minute {
+		return
+	}
+	this.lastCleanup = now
+	for key, entry := range this.failures {
+		if now.Sub(entry.first) >= this.lockout && !now.Before(entry.lockedUntil) {
+			delete(this.failures, key)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottler(t *testing.T) {
+	throttler := NewLoginThrottler(LoginThrottleConfig{MaxFailures: 3, MaxIPFailures: 5, Lockout: 200 * time.Millisecond})
+
+	// 同一用户名连续失败 3 次后锁定
+	for i := 0; i < 3; i++ {
+		if wait := throttler.Check("proxy", "10.0.0.1"); wait > 0 {
+			t.Fatalf("attempt %d should not be locked", i)
+		}
+		throttler.Failure("proxy", "10.0.0.1")
+	}
+	if wait := throttler.Check("proxy", "10.0.0.2"); wait <= 0 {
+		t.Fatal("username should be locked from any ip")
+	}
+	if wait := throttler.Check("finance", "10.0.0.1"); wait > 0 {
+		t.Fatal("other usernames should not be locked yet")
+	}
+
+	// 同一 IP 尝试不同用户名也会被锁定
+	throttler.Failure("a", "10.0.0.1")
+	throttler.Failure("b", "10.0.0.1")
+	if wait := throttler.Check("finance", "10.0.0.1"); wait <= 0 {
+		t.Fatal("ip should be locked")
+	}
+
+	// 锁定到期后可以重新尝试
+	time.Sleep(250 * time.Millisecond)
+	if wait := throttler.Check("proxy", "10.0.0.1"); wait > 0 {
+		t.Fatal("lock should expire")
+	}
+
+	// 登录成功后清除用户名的失败次数
+	throttler.Failure("finance", "10.0.0.3")
+	throttler.Failure("finance", "10.0.0.3")
+	throttler.Success("finance")
+	throttler.Failure("finance", "10.0.0.3")
+	if wait := throttler.Check("finance", "10.0.0.3"); wait > 0 {
+		t.Fatal("success should reset username failures")
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	log "bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RFC 6238 TOTP 参数，与常见的身份验证器应用一致
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+	// 允许前后各一个时间步长的时钟偏差
+	totpSkew   = 1
+	totpIssuer = "bedrock-claude-proxy"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP 开启请求
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`      // base32 编码的密钥
+	URL    string `json:"otpauth_url"` // 用于生成二维码
+}
+
+// TOTP 确认请求
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTP 关闭请求，需要同时提供密码和当前验证码
+type TOTPDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// GenerateTOTPSecret 生成 160 位的随机密钥，base32 编码
+func GenerateTOTPSecret() (string, error) {
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(bytes), nil
+}
+
+// TOTPCode 按 RFC 6238 (HMAC-SHA1) 计算 t 所在时间步长的验证码
+func TOTPCode(key []byte, t time.Time, digits int) string {
+	counter := uint64(t.Unix()) / uint64(TOTPPeriod/time.Second)
+	return hotpCode(key, counter, digits)
+}
+
+// hotpCode 按 RFC 4226 计算验证码
+func hotpCode(key []byte, counter uint64, digits int) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+// VerifyTOTP 校验验证码，返回匹配的时间步长。lastCounter 为上次成功使用的时间步长，不接受重复使用
+func VerifyTOTP(secret, code string, now time.Time, lastCounter int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(TOTPPeriod/time.Second)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotpCode(key, uint64(counter), TOTPDigits)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURL 生成身份验证器应用使用的 otpauth:// 地址
+func TOTPURL(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// verifyAdminTOTP 校验管理员的验证码并记录已使用的时间步长，防止同一个验证码被重复使用
+func verifyAdminTOTP(db *gorm.DB, admin *models.Admin, code string) bool {
+	counter, ok := VerifyTOTP(admin.TOTPSecret, code, time.Now(), admin.TOTPLastCounter)
+	if !ok {
+		return false
+	}
+	if err := models.UpdateAdminTOTPCounter(db, admin.Username, counter); err != nil {
+		log.Logger.Errorf("Failed to save totp counter for %s: %v", admin.Username, err)
+		return false
+	}
+	admin.TOTPLastCounter = counter
+	return true
+}
+
+// EnrollAdminTOTP 为当前管理员生成新的 TOTP 密钥，调用 /admin/totp/confirm 验证后才会启用
+func EnrollAdminTOTP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		username, ok := GetUsername(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		admin, err := models.GetAdminByUsername(db, username)
+		if err != nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		if admin.TOTPEnabled {
+			http.Error(w, "TOTP is already enabled", http.StatusConflict)
+			return
+		}
+
+		secret, err := GenerateTOTPSecret()
+		if err != nil {
+			log.Logger.Errorf("Failed to generate totp secret: %v", err)
+			http.Error(w, "Failed to enroll TOTP", http.StatusInternalServerError)
+			return
+		}
+		if err := models.UpdateAdminTOTP(db, username, secret, false); err != nil {
+			log.Logger.Errorf("Failed to save totp secret: %v", err)
+			http.Error(w, "Failed to enroll TOTP", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TOTPEnrollResponse{
+			Secret: secret,
+			URL:    TOTPURL(username, secret),
+		})
+		log.Logger.Infof("Admin TOTP enrollment started: %s", username)
+	}
+}
+
+// ConfirmAdminTOTP 使用验证器应用生成的验证码确认并启用 TOTP
+func ConfirmAdminTOTP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		username, ok := GetUsername(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 解析请求体
+		var req TOTPConfirmRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		admin, err := models.GetAdminByUsername(db, username)
+		if err != nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		if admin.TOTPEnabled {
+			http.Error(w, "TOTP is already enabled", http.StatusConflict)
+			return
+		}
+		if admin.TOTPSecret == "" {
+			http.Error(w, "TOTP enrollment not started", http.StatusBadRequest)
+			return
+		}
+		if !verifyAdminTOTP(db, admin, req.Code) {
+			http.Error(w, "Invalid TOTP code", http.StatusUnauthorized)
+			return
+		}
+		if err := models.UpdateAdminTOTP(db, username, admin.TOTPSecret, true); err != nil {
+			log.Logger.Errorf("Failed to enable totp: %v", err)
+			http.Error(w, "Failed to enable TOTP", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAdminTOTPEnable, username, nil, nil)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "TOTP enabled successfully"}`))
+		log.Logger.Infof("Admin TOTP enabled: %s", username)
+	}
+}
+
+// DisableAdminTOTP 关闭当前管理员的 TOTP
+func DisableAdminTOTP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受POST请求
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		username, ok := GetUsername(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// 解析请求体
+		var req TOTPDisableRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		admin, err := models.GetAdminByUsername(db, username)
+		if err != nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		if !admin.TOTPEnabled {
+			http.Error(w, "TOTP is not enabled", http.StatusBadRequest)
+			return
+		}
+		if ok, _ := models.VerifyAdminPassword(admin.Password, req.Password); !ok {
+			http.Error(w, "Invalid password", http.StatusUnauthorized)
+			return
+		}
+		if !verifyAdminTOTP(db, admin, req.Code) {
+			http.Error(w, "Invalid TOTP code", http.StatusUnauthorized)
+			return
+		}
+		if err := models.UpdateAdminTOTP(db, username, "", false); err != nil {
+			log.Logger.Errorf("Failed to disable totp: %v", err)
+			http.Error(w, "Failed to disable TOTP", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, models.AuditActionAdminTOTPDisable, username, nil, nil)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message": "TOTP disabled successfully"}`))
+		log.Logger.Infof("Admin TOTP disabled: %s", username)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 中 SHA1 的测试向量
+func TestTOTPCode_RFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range cases {
+		if got := TOTPCode(key, time.Unix(unix, 0), 8); got != expected {
+			t.Errorf("%d: got %s, want %s", unix, got, expected)
+		}
+	}
+	t.Log("PASS")
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	now := time.Unix(1111111111, 0)
+	code := TOTPCode(key, now, TOTPDigits)
+
+	counter, ok := VerifyTOTP(secret, code, now, 0)
+	if !ok || counter != 1111111111/30 {
+		t.Fatalf("valid code rejected: %v %d", ok, counter)
+	}
+	// 允许一个时间步长的时钟偏差
+	if _, ok := VerifyTOTP(secret, code, now.Add(30*time.Second), 0); !ok {
+		t.Fatal("code from previous step should be accepted")
+	}
+	if _, ok := VerifyTOTP(secret, code, now.Add(2*time.Minute), 0); ok {
+		t.Fatal("expired code should be rejected")
+	}
+	// 同一个验证码不能重复使用
+	if _, ok := VerifyTOTP(secret, code, now, counter); ok {
+		t.Fatal("replayed code should be rejected")
+	}
+	wrong := code[:5] + string('0'+(code[5]-'0'+1)%10)
+	if _, ok := VerifyTOTP(secret, wrong, now, 0); ok {
+		t.Fatal("wrong code should be rejected")
+	}
+
+	generated, err := GenerateTOTPSecret()
+	if err != nil || len(generated) != 32 {
+		t.Fatalf("unexpected secret %q: %v", generated, err)
+	}
+	t.Log("PASS")
+}
@@ -4,6 +4,7 @@ import (
 	"bedrock-claude-proxy/log"
 	"bedrock-claude-proxy/models"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -19,8 +20,8 @@ type PaginationQuery struct {
 
 // Usage列表响应
 type ListUsageResponse struct {
-	Total int64         `json:"total"`
-	Items []UsageItem   `json:"items"`
+	Total int64       `json:"total"`
+	Items []UsageItem `json:"items"`
 }
 
 // Usage项目
@@ -30,10 +31,27 @@ type UsageItem struct {
 	ModelName    string    `json:"model_name"`
 	InputTokens  int       `json:"input_tokens"`
 	OutputTokens int       `json:"output_tokens"`
+	SearchUnits  int       `json:"search_units"`
 	Quota        int       `json:"quota"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// ParseUsageFilter 解析使用记录接口共用的过滤参数 apikey_name、model_name、start_time 和 end_time，
+// 日期范围的解析见 ParseDateRange
+func ParseUsageFilter(r *http.Request) (models.UsageFilter, error) {
+	query := r.URL.Query()
+	filter := models.UsageFilter{
+		APIKeyName: query.Get("apikey_name"),
+		ModelName:  query.Get("model_name"),
+	}
+	start, end, err := ParseDateRange(query)
+	if err != nil {
+		return filter, err
+	}
+	filter.Start, filter.End = start, end
+	return filter, nil
+}
+
 // 列出使用记录
 func ListUsage(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -60,38 +78,14 @@ func ListUsage(db *gorm.DB) http.HandlerFunc {
 		}
 
 		// 解析过滤参数
-		apiKeyName := r.URL.Query().Get("apikey_name")
-		modelName := r.URL.Query().Get("model_name")
-		startTimeStr := r.URL.Query().Get("start_time") // 格式: 2006-01-02
-		endTimeStr := r.URL.Query().Get("end_time")     // 格式: 2006-01-02
-
-		// 构建查询
-		query := db.Model(&models.Usage{})
-
-		// 应用过滤条件
-		if apiKeyName != "" {
-			query = query.Where("apikey_name = ?", apiKeyName)
-		}
-
-		if modelName != "" {
-			query = query.Where("model_name = ?", modelName)
-		}
-
-		if startTimeStr != "" {
-			startTime, err := time.Parse("2006-01-02", startTimeStr)
-			if err == nil {
-				query = query.Where("created_at >= ?", startTime)
-			}
+		filter, err := ParseUsageFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		if endTimeStr != "" {
-			endTime, err := time.Parse("2006-01-02", endTimeStr)
-			if err == nil {
-				// 将结束日期设置为当天的最后一刻
-				endTime = endTime.Add(24*time.Hour - time.Second)
-				query = query.Where("created_at <= ?", endTime)
-			}
-		}
+		// 构建查询
+		query := filter.Apply(db.Model(&models.Usage{}))
 
 		// 计算总记录数
 		var total int64
@@ -121,6 +115,7 @@ func ListUsage(db *gorm.DB) http.HandlerFunc {
 				ModelName:    usage.ModelName,
 				InputTokens:  usage.InputTokens,
 				OutputTokens: usage.OutputTokens,
+				SearchUnits:  usage.SearchUnits,
 				Quota:        usage.Quota,
 				CreatedAt:    usage.CreatedAt,
 			}
@@ -171,12 +166,13 @@ func GetAPIKeyQuota(db *gorm.DB) http.HandlerFunc {
 		type Result struct {
 			TotalInput  int64 `json:"total_input"`
 			TotalOutput int64 `json:"total_output"`
+			TotalSearch int64 `json:"total_search"`
 			TotalQuota  int64 `json:"total_quota"`
 		}
 
 		var result Result
 		if err := db.Model(&models.Usage{}).
-			Select("SUM(input_tokens) as total_input, SUM(output_tokens) as total_output, SUM(quota) as total_quota").
+			Select("SUM(input_tokens) as total_input, SUM(output_tokens) as total_output, SUM(search_units) as total_search, SUM(quota) as total_quota").
 			Where("apikey_name = ?", apiKeyName).
 			Scan(&result).Error; err != nil {
 			log.Logger.Errorf("Failed to sum usage records: %v", err)
@@ -184,14 +180,24 @@ func GetAPIKeyQuota(db *gorm.DB) http.HandlerFunc {
 			return
 		}
 
+		// 当前预算周期的消耗情况
+		budget, err := getAPIKeyBudget(db, apiKeyName)
+		if err != nil {
+			log.Logger.Errorf("Failed to query budget: %v", err)
+			http.Error(w, "Failed to query database", http.StatusInternalServerError)
+			return
+		}
+
 		// 构建响应数据
 		response := map[string]interface{}{
-			"apikey_name":        apiKeyName,
-			"total_requests":     count,
-			"total_input_tokens": result.TotalInput,
+			"budget":              budget,
+			"apikey_name":         apiKeyName,
+			"total_requests":      count,
+			"total_input_tokens":  result.TotalInput,
 			"total_output_tokens": result.TotalOutput,
-			"total_tokens":       result.TotalInput + result.TotalOutput,
-			"total_quota":        result.TotalQuota,
+			"total_tokens":        result.TotalInput + result.TotalOutput,
+			"total_search_units":  result.TotalSearch,
+			"total_quota":         result.TotalQuota,
 		}
 
 		// 返回JSON响应
@@ -203,3 +209,52 @@ func GetAPIKeyQuota(db *gorm.DB) http.HandlerFunc {
 		}
 	}
 }
+
+// API密钥预算信息，额度单位与使用记录的 quota 相同
+type APIKeyBudget struct {
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"period_start"`
+	HardLimit   int64     `json:"hard_limit"`
+	SoftLimit   int64     `json:"soft_limit"`
+	Spent       int64     `json:"spent"`
+	SpentUSD    float64   `json:"spent_usd"`
+	// 未设置硬性预算时为 nil
+	Remaining         *int64 `json:"remaining"`
+	SoftLimitExceeded bool   `json:"soft_limit_exceeded"`
+	HardLimitExceeded bool   `json:"hard_limit_exceeded"`
+}
+
+func getAPIKeyBudget(db *gorm.DB, apiKeyName string) (*APIKeyBudget, error) {
+	apiKey, err := models.GetAPIKeyByName(db, apiKeyName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	periodStart := models.BudgetPeriodStart(apiKey.BudgetPeriod, time.Now())
+	spent, err := models.SumQuotaSince(db, apiKeyName, periodStart)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := &APIKeyBudget{
+		Period:            apiKey.BudgetPeriod,
+		PeriodStart:       periodStart,
+		HardLimit:         apiKey.BudgetHardLimit,
+		SoftLimit:         apiKey.BudgetSoftLimit,
+		Spent:             spent,
+		SpentUSD:          float64(spent) / models.QuotaPerUSD,
+		SoftLimitExceeded: apiKey.BudgetSoftLimit > 0 && spent >= apiKey.BudgetSoftLimit,
+		HardLimitExceeded: apiKey.BudgetHardLimit > 0 && spent >= apiKey.BudgetHardLimit,
+	}
+	if apiKey.BudgetHardLimit > 0 {
+		remaining := apiKey.BudgetHardLimit - spent
+		if remaining < 0 {
+			remaining = 0
+		}
+		budget.Remaining = &remaining
+	}
+	return budget, nil
+}
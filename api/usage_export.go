@@ -0,0 +1,269 @@
+package api
+
+import (
+	"bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 使用记录的导出格式
+const (
+	UsageExportCSV   = "csv"
+	UsageExportJSONL = "jsonl"
+)
+
+// 每批从数据库读取的使用记录数
+const usageExportBatchSize = 1000
+
+// UsageExporter 逐行输出 CSV 或 JSONL，CSV 的第一行为列名
+type UsageExporter struct {
+	columns []string
+	csv     *csv.Writer
+	jsonl   *bufio.Writer
+}
+
+func NewUsageExporter(w io.Writer, format string, columns []string) (*UsageExporter, error) {
+	exporter := &UsageExporter{columns: columns}
+	switch format {
+	case UsageExportCSV:
+		exporter.csv = csv.NewWriter(w)
+		if err := exporter.csv.Write(columns); err != nil {
+			return nil, err
+		}
+	case UsageExportJSONL:
+		exporter.jsonl = bufio.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("invalid format %s, must be %s or %s", format, UsageExportCSV, UsageExportJSONL)
+	}
+	return exporter, nil
+}
+
+// formatCSVValue 将值转换为 CSV 单元格。
+// 以 = + - @ 或制表符、回车开头的文本在电子表格中会被当作公式执行，加上 ' 前缀作为纯文本
+func formatCSVValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Write 输出一行，values 与 columns 一一对应
+func (this *UsageExporter) Write(values []interface{}) error {
+	if this.csv != nil {
+		record := make([]string, len(values))
+		for i, value := range values {
+			record[i] = formatCSVValue(value)
+		}
+		return this.csv.Write(record)
+	}
+
+	// 按列的顺序输出 JSON 对象
+	this.jsonl.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			this.jsonl.WriteByte(',')
+		}
+		key, _ := json.Marshal(this.columns[i])
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		this.jsonl.Write(key)
+		this.jsonl.WriteByte(':')
+		this.jsonl.Write(data)
+	}
+	this.jsonl.WriteString("}\n")
+	return nil
+}
+
+// Flush 将缓冲的数据写出
+func (this *UsageExporter) Flush() error {
+	if this.csv != nil {
+		this.csv.Flush()
+		return this.csv.Error()
+	}
+	return this.jsonl.Flush()
+}
+
+// usageSummaryColumns 汇总导出的列，只包含参与分组的维度
+func usageSummaryColumns(groupBy []string, period string, cost bool) []string {
+	var columns []string
+	for _, group := range groupBy {
+		switch group {
+		case models.UsageGroupAPIKey:
+			columns = append(columns, "apikey_name")
+		case models.UsageGroupModel:
+			columns = append(columns, "model_name")
+		}
+	}
+	if period != "" {
+		columns = append(columns, "period")
+	}
+	columns = append(columns, "requests", "input_tokens", "output_tokens", "search_units", "quota")
+	if cost {
+		columns = append(columns, "cost_usd")
+	}
+	return columns
+}
+
+// usageSummaryValues 汇总导出的一行，与 usageSummaryColumns 对应
+func usageSummaryValues(summary models.UsageSummary, groupBy []string, period string, cost bool) []interface{} {
+	var values []interface{}
+	for _, group := range groupBy {
+		switch group {
+		case models.UsageGroupAPIKey:
+			values = append(values, summary.APIKeyName)
+		case models.UsageGroupModel:
+			values = append(values, summary.ModelName)
+		}
+	}
+	if period != "" {
+		values = append(values, summary.Period)
+	}
+	values = append(values, summary.Requests, summary.InputTokens, summary.OutputTokens, summary.SearchUnits, summary.Quota)
+	if cost {
+		values = append(values, float64(summary.Quota)/models.QuotaPerUSD)
+	}
+	return values
+}
+
+// usageRecordColumns 明细导出的列
+func usageRecordColumns(cost bool) []string {
+	columns := []string{"id", "created_at", "apikey_name", "apikey_prefix", "model_name", "input_tokens", "output_tokens", "search_units", "quota"}
+	if cost {
+		columns = append(columns, "cost_usd")
+	}
+	return columns
+}
+
+// usageRecordValues 明细导出的一行，与 usageRecordColumns 对应
+func usageRecordValues(usage *models.Usage, cost bool) []interface{} {
+	values := []interface{}{usage.ID, usage.CreatedAt, usage.APIKeyName, usage.APIKeyPrefix, usage.ModelName,
+		usage.InputTokens, usage.OutputTokens, usage.SearchUnits, usage.Quota}
+	if cost {
+		values = append(values, float64(usage.Quota)/models.QuotaPerUSD)
+	}
+	return values
+}
+
+// ExportUsage 以 CSV 或 JSONL 流式导出使用记录，过滤参数与 /admin/usage/list 相同。
+// 指定 group_by 或 period 时导出与 /admin/usage/summary 相同的汇总，cost=true 时增加 cost_usd 列。
+// 明细按批读取并边读边写，不会把全部记录加载到内存。
+func ExportUsage(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受GET请求
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 解析参数
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = UsageExportCSV
+		}
+		if format != UsageExportCSV && format != UsageExportJSONL {
+			http.Error(w, fmt.Sprintf("invalid format %s, must be %s or %s", format, UsageExportCSV, UsageExportJSONL), http.StatusBadRequest)
+			return
+		}
+		filter, err := ParseUsageFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		groupBy, err := ParseUsageGroupBy(r.URL.Query().Get("group_by"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		period := r.URL.Query().Get("period")
+		if err := models.ValidateUsagePeriod(period); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cost := r.URL.Query().Get("cost") == "true"
+		aggregate := len(groupBy) > 0 || period != ""
+
+		// 汇总结果较小，先查询完成，出错时仍然可以返回错误状态码
+		var summaries []models.UsageSummary
+		columns := usageRecordColumns(cost)
+		if aggregate {
+			summaries, err = models.SummarizeUsage(db, filter, groupBy, period)
+			if err != nil {
+				log.Logger.Errorf("Failed to summarize usage: %v", err)
+				http.Error(w, "Failed to export usage", http.StatusInternalServerError)
+				return
+			}
+			columns = usageSummaryColumns(groupBy, period, cost)
+		}
+
+		if format == UsageExportCSV {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s.%s"`, time.Now().Format("20060102150405"), format))
+
+		exporter, _ := NewUsageExporter(w, format, columns)
+		flusher, _ := w.(http.Flusher)
+		flush := func() error {
+			if err := exporter.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		}
+
+		rows := 0
+		if aggregate {
+			for _, summary := range summaries {
+				if err = exporter.Write(usageSummaryValues(summary, groupBy, period, cost)); err != nil {
+					break
+				}
+				rows++
+			}
+		} else {
+			err = models.EachUsage(db, filter, usageExportBatchSize, func(usage *models.Usage) error {
+				if err := exporter.Write(usageRecordValues(usage, cost)); err != nil {
+					return err
+				}
+				rows++
+				// 每批写出一次，避免缓冲整个结果
+				if rows%usageExportBatchSize == 0 {
+					return flush()
+				}
+				return nil
+			})
+		}
+		if err == nil {
+			err = flush()
+		}
+		// 响应已经开始输出，无法再返回错误状态码
+		if err != nil {
+			log.Logger.Errorf("Failed to export usage after %d rows: %v", rows, err)
+			return
+		}
+		log.Logger.Infof("Usage exported: %d rows, format=%s, aggregate=%v", rows, format, aggregate)
+	}
+}
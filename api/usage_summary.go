@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 使用统计项目，cost_usd 由额度换算
+type UsageSummaryItem struct {
+	models.UsageSummary
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// 使用统计响应，total 为所有项目的合计
+type UsageSummaryResponse struct {
+	GroupBy []string           `json:"group_by"`
+	Period  string             `json:"period,omitempty"`
+	Items   []UsageSummaryItem `json:"items"`
+	Total   UsageSummaryItem   `json:"total"`
+}
+
+// ParseUsageGroupBy 解析逗号分隔的 group_by 参数，如 apikey,model
+func ParseUsageGroupBy(value string) ([]string, error) {
+	groupBy := []string{}
+	for _, group := range strings.Split(value, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groupBy = append(groupBy, group)
+		}
+	}
+	return groupBy, models.ValidateUsageGroupBy(groupBy)
+}
+
+// NewUsageSummaryItem 计算汇总项的美元费用
+func NewUsageSummaryItem(summary models.UsageSummary) UsageSummaryItem {
+	return UsageSummaryItem{
+		UsageSummary: summary,
+		CostUSD:      float64(summary.Quota) / models.QuotaPerUSD,
+	}
+}
+
+// SummarizeUsage 按 API Key、模型和时间段（hour / day / month）汇总使用记录，
+// 支持与 /admin/usage/list 相同的过滤参数
+func SummarizeUsage(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// 只接受GET请求
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter, err := ParseUsageFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		groupBy, err := ParseUsageGroupBy(r.URL.Query().Get("group_by"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		period := r.URL.Query().Get("period")
+		if err := models.ValidateUsagePeriod(period); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		summaries, err := models.SummarizeUsage(db, filter, groupBy, period)
+		if err != nil {
+			log.Logger.Errorf("Failed to summarize usage: %v", err)
+			http.Error(w, "Failed to summarize usage", http.StatusInternalServerError)
+			return
+		}
+
+		// 转换为响应格式并计算合计
+		response := UsageSummaryResponse{
+			GroupBy: groupBy,
+			Period:  period,
+			Items:   make([]UsageSummaryItem, len(summaries)),
+		}
+		var total models.UsageSummary
+		for i, summary := range summaries {
+			response.Items[i] = NewUsageSummaryItem(summary)
+			total.Requests += summary.Requests
+			total.InputTokens += summary.InputTokens
+			total.OutputTokens += summary.OutputTokens
+			total.SearchUnits += summary.SearchUnits
+			total.Quota += summary.Quota
+		}
+		response.Total = NewUsageSummaryItem(total)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
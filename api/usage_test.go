@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bedrock-claude-proxy/models"
+)
+
+func TestParseUsageFilter(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/admin/usage/summary?apikey_name=ci_key&start_time=2024-05-01&end_time=2024-05-31", nil)
+	filter, err := ParseUsageFilter(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.APIKeyName != "ci_key" || filter.ModelName != "" {
+		t.Fatalf("unexpected filter: %+v", filter)
+	}
+	// end_time 包含当天
+	if filter.Start.Format("2006-01-02 15:04:05") != "2024-05-01 00:00:00" || filter.End.Format("2006-01-02 15:04:05") != "2024-05-31 23:59:59" {
+		t.Fatalf("unexpected range: %s - %s", filter.Start, filter.End)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/admin/usage/summary?start_time=2024/05/01", nil)
+	if _, err := ParseUsageFilter(request); err == nil {
+		t.Fatal("expected error for invalid start_time")
+	}
+	t.Log("PASS")
+}
+
+func TestUsageSummaryParams(t *testing.T) {
+	groupBy, err := ParseUsageGroupBy("apikey, model")
+	if err != nil || len(groupBy) != 2 || groupBy[0] != models.UsageGroupAPIKey || groupBy[1] != models.UsageGroupModel {
+		t.Fatalf("unexpected group by %v: %v", groupBy, err)
+	}
+	if groupBy, err := ParseUsageGroupBy(""); err != nil || len(groupBy) != 0 {
+		t.Fatalf("empty group by: %v %v", groupBy, err)
+	}
+	if _, err := ParseUsageGroupBy("apikey,region"); err == nil {
+		t.Fatal("expected error for invalid group by")
+	}
+
+	for _, period := range []string{"", models.UsagePeriodHour, models.UsagePeriodDay, models.UsagePeriodMonth} {
+		if err := models.ValidateUsagePeriod(period); err != nil {
+			t.Errorf("%q: %v", period, err)
+		}
+	}
+	if err := models.ValidateUsagePeriod("week"); err == nil {
+		t.Fatal("expected error for invalid period")
+	}
+
+	// 额度按 QuotaPerUSD 换算为美元，未分组的维度不输出
+	item := NewUsageSummaryItem(models.UsageSummary{ModelName: "claude-3-5-haiku", Period: "2024-05", Requests: 3, Quota: 750000})
+	if item.CostUSD != 1.5 {
+		t.Fatalf("unexpected cost: %v", item.CostUSD)
+	}
+	data, _ := json.Marshal(item)
+	if !strings.Contains(string(data), `"cost_usd":1.5`) || !strings.Contains(string(data), `"model_name":"claude-3-5-haiku"`) || strings.Contains(string(data), "apikey_name") {
+		t.Fatalf("unexpected json: %s", data)
+	}
+	t.Log("PASS")
+}
+
+func TestUsageExporter(t *testing.T) {
+	columns := []string{"apikey_name", "quota", "cost_usd"}
+
+	var buffer bytes.Buffer
+	exporter, err := NewUsageExporter(&buffer, UsageExportCSV, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.Write([]interface{}{"team,a", int64(750000), 1.5})
+	if err := exporter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "apikey_name,quota,cost_usd\n\"team,a\",750000,1.5\n"; buffer.String() != expected {
+		t.Fatalf("unexpected csv: %q", buffer.String())
+	}
+
+	// CSV 中可能被电子表格当作公式的文本加上 ' 前缀，数字不受影响
+	buffer.Reset()
+	exporter, _ = NewUsageExporter(&buffer, UsageExportCSV, columns)
+	exporter.Write([]interface{}{"=HYPERLINK(\"http://evil\")", int64(-1), -0.5})
+	exporter.Write([]interface{}{"@SUM(A1)", int64(0), 0.0})
+	exporter.Write([]interface{}{"\tteam", int64(0), 0.0})
+	exporter.Flush()
+	if expected := "apikey_name,quota,cost_usd\n\"'=HYPERLINK(\"\"http://evil\"\")\",-1,-0.5\n'@SUM(A1),0,0\n'\tteam,0,0\n"; buffer.String() != expected {
+		t.Fatalf("unexpected escaped csv: %q", buffer.String())
+	}
+
+	// JSONL 每行一个对象，按列的顺序输出
+	buffer.Reset()
+	exporter, _ = NewUsageExporter(&buffer, UsageExportJSONL, columns)
+	exporter.Write([]interface{}{"team-a", int64(750000), 1.5})
+	exporter.Write([]interface{}{"team-b", int64(0), 0.0})
+	exporter.Flush()
+	if expected := "{\"apikey_name\":\"team-a\",\"quota\":750000,\"cost_usd\":1.5}\n{\"apikey_name\":\"team-b\",\"quota\":0,\"cost_usd\":0}\n"; buffer.String() != expected {
+		t.Fatalf("unexpected jsonl: %q", buffer.String())
+	}
+
+	if _, err := NewUsageExporter(&buffer, "xlsx", columns); err == nil {
+		t.Fatal("expected error for invalid format")
+	}
+	t.Log("PASS")
+}
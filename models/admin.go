@@ -1,15 +1,162 @@
 package models
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// LegacyDefaultAdminPassword 旧版本内置的默认管理员密码，仍在使用时强制修改
+const LegacyDefaultAdminPassword = "hello@autel.com"
+
+// MinAdminPasswordLength 管理员密码的最小长度
+const MinAdminPasswordLength = 12
+
+// 管理员角色
+const (
+	RoleOwner         = "owner"          // 全部权限，包括管理管理员账户
+	RoleKeyManager    = "key-manager"    // 管理 API Key 和查看使用记录
+	RoleBillingViewer = "billing-viewer" // 只能查看 API Key 额度和使用记录
+)
+
+// AdminRoles 所有管理员角色
+var AdminRoles = []string{RoleOwner, RoleKeyManager, RoleBillingViewer}
+
 type Admin struct {
 	gorm.Model
-	Username string `column:username;gorm:"not null;varchar(255)" json:"username"`
-	Password string `column:password;gorm:"not null;varchar(255)" json:"password"`
+	Username string `gorm:"column:username;not null;type:varchar(255);uniqueIndex" json:"username"`
+	Password string `gorm:"column:password;not null;type:varchar(255)" json:"-"` // bcrypt 哈希
+	// 旧版本只有一个管理员，迁移后默认为 owner
+	Role string `gorm:"column:role;not null;type:varchar(32);default:owner" json:"role"`
+	// 使用初始密码或默认密码时需要先修改密码才能访问其他管理接口
+	MustChangePassword bool `gorm:"column:must_change_password;not null;default:false" json:"must_change_password"`
+	// TOTP 第二因素，密钥在确认前保存但不启用
+	TOTPSecret      string `gorm:"column:totp_secret;type:varchar(64);not null;default:''" json:"-"`
+	TOTPEnabled     bool   `gorm:"column:totp_enabled;not null;default:false" json:"totp_enabled"`
+	TOTPLastCounter int64  `gorm:"column:totp_last_counter;not null;default:0" json:"-"` // 最近一次使用的时间步长，防止验证码重放
+	// 会话纪元，token 中记录签发时的值，递增后之前签发的 token 全部失效，用于退出所有会话
+	SessionEpoch int64 `gorm:"column:session_epoch;not null;default:0" json:"-"`
 }
 
 func (Admin) TableName() string {
 	return "admin"
 }
+
+// MySQL 唯一键冲突的错误码
+const mysqlErrDuplicateEntry = 1062
+
+// IsDuplicateKeyError 判断是否为唯一键冲突
+func IsDuplicateKeyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDuplicateEntry
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// PurgeDeletedAdmins 清除旧版本软删除的管理员，否则与同名的管理员冲突，无法创建 username 唯一索引
+func PurgeDeletedAdmins(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Admin{}) {
+		return nil
+	}
+	return db.Unscoped().Where("deleted_at IS NOT NULL").Delete(&Admin{}).Error
+}
+
+// RevokeAdminTokens 递增管理员的会话纪元，使之前签发的所有 token 失效
+func RevokeAdminTokens(db *gorm.DB, username string) error {
+	return db.Model(&Admin{}).Where("username = ?", username).
+		Update("session_epoch", gorm.Expr("session_epoch + 1")).Error
+}
+
+// HashAdminPassword 使用 bcrypt 哈希管理员密码
+func HashAdminPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyAdminPassword 校验管理员密码。旧版本使用不加盐的 SHA-256，校验通过时 needsUpgrade 为 true，
+// 调用方应使用 bcrypt 重新哈希保存。
+func VerifyAdminPassword(hash, password string) (ok bool, needsUpgrade bool) {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil, false
+	}
+	legacy := sha256.Sum256([]byte(password))
+	ok = subtle.ConstantTimeCompare([]byte(hash), []byte(hex.EncodeToString(legacy[:]))) == 1
+	return ok, ok
+}
+
+// UpdateAdminPassword 保存新的密码哈希
+func UpdateAdminPassword(db *gorm.DB, username, hash string, mustChangePassword bool) error {
+	return db.Model(&Admin{}).Where("username = ?", username).Updates(map[string]interface{}{
+		"password":             hash,
+		"must_change_password": mustChangePassword,
+	}).Error
+}
+
+// ValidateAdminRole 检查角色是否有效
+func ValidateAdminRole(role string) error {
+	for _, r := range AdminRoles {
+		if r == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid role %s, must be one of %s", role, strings.Join(AdminRoles, ", "))
+}
+
+// GetAdminByUsername 根据用户名获取管理员
+func GetAdminByUsername(db *gorm.DB, username string) (*Admin, error) {
+	var admin Admin
+	if err := db.Where("username = ?", username).First(&admin).Error; err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// ListAdmins 列出所有管理员
+func ListAdmins(db *gorm.DB) ([]Admin, error) {
+	var admins []Admin
+	err := db.Order("id").Find(&admins).Error
+	return admins, err
+}
+
+// CountAdminsByRole 统计某个角色的管理员数量，用于防止移除最后一个 owner
+func CountAdminsByRole(db *gorm.DB, role string) (int64, error) {
+	var count int64
+	err := db.Model(&Admin{}).Where("role = ?", role).Count(&count).Error
+	return count, err
+}
+
+// UpdateAdminRole 修改管理员角色
+func UpdateAdminRole(db *gorm.DB, username, role string) error {
+	return db.Model(&Admin{}).Where("username = ?", username).Update("role", role).Error
+}
+
+// UpdateAdminTOTP 保存管理员的 TOTP 密钥和启用状态，secret 为空表示关闭
+func UpdateAdminTOTP(db *gorm.DB, username, secret string, enabled bool) error {
+	return db.Model(&Admin{}).Where("username = ?", username).Updates(map[string]interface{}{
+		"totp_secret":  secret,
+		"totp_enabled": enabled,
+	}).Error
+}
+
+// UpdateAdminTOTPCounter 记录最近一次使用的 TOTP 时间步长，只允许递增，多实例同时使用同一个验证码时只有一个成功
+func UpdateAdminTOTPCounter(db *gorm.DB, username string, counter int64) error {
+	result := db.Model(&Admin{}).Where("username = ? AND totp_last_counter < ?", username, counter).Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("totp code has already been used")
+	}
+	return nil
+}
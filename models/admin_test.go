@@ -0,0 +1,63 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'alice' for key 'idx_admin_username'"}
+	if !IsDuplicateKeyError(fmt.Errorf("create admin: %w", duplicate)) {
+		t.Fatal("expected duplicate key error")
+	}
+	if !IsDuplicateKeyError(gorm.ErrDuplicatedKey) {
+		t.Fatal("expected translated duplicate key error")
+	}
+	if IsDuplicateKeyError(&mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}) {
+		t.Fatal("unexpected duplicate key error")
+	}
+	t.Log("PASS")
+}
+
+func TestVerifyAdminPassword(t *testing.T) {
+	hash, err := HashAdminPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$2") {
+		t.Fatalf("expected bcrypt hash: %s", hash)
+	}
+	if ok, needsUpgrade := VerifyAdminPassword(hash, "correct horse battery"); !ok || needsUpgrade {
+		t.Fatalf("bcrypt: got ok=%v needsUpgrade=%v", ok, needsUpgrade)
+	}
+	if ok, _ := VerifyAdminPassword(hash, "wrong password"); ok {
+		t.Fatal("wrong password should not verify")
+	}
+
+	// 旧版本的无盐 SHA-256 哈希仍然可以登录，并提示升级
+	legacy := sha256.Sum256([]byte(LegacyDefaultAdminPassword))
+	legacyHash := hex.EncodeToString(legacy[:])
+	if ok, needsUpgrade := VerifyAdminPassword(legacyHash, LegacyDefaultAdminPassword); !ok || !needsUpgrade {
+		t.Fatalf("legacy: got ok=%v needsUpgrade=%v", ok, needsUpgrade)
+	}
+	if ok, _ := VerifyAdminPassword(legacyHash, "wrong password"); ok {
+		t.Fatal("wrong password should not verify against legacy hash")
+	}
+	t.Log("PASS")
+}
+
+func TestValidateAdminRole(t *testing.T) {
+	if err := ValidateAdminRole("billing-viewer"); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateAdminRole("admin"); err == nil {
+		t.Error("expected error for invalid role")
+	}
+	t.Log("PASS")
+}
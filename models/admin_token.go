@@ -0,0 +1,64 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevokedToken 已撤销的管理员 token，记录保留到 token 过期
+type RevokedToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	JTI       string    `gorm:"column:jti;type:varchar(64);not null;uniqueIndex" json:"jti"`
+	Username  string    `gorm:"column:username;type:varchar(255);not null" json:"username"`
+	ExpiresAt time.Time `gorm:"column:expires_at;not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (RevokedToken) TableName() string {
+	return "admin_token_revocation"
+}
+
+// ErrTokenRevoked token 已被撤销，或 refresh token 已被使用
+var ErrTokenRevoked = errors.New("token has already been revoked")
+
+// insertRevokedToken 写入撤销记录并清理已经过期的记录，返回本次是否新写入。
+// jti 唯一，已存在时不插入，MySQL 在未设置 CLIENT_FOUND_ROWS 时 RowsAffected 为 0
+func insertRevokedToken(db *gorm.DB, jti, username string, expiresAt time.Time) (bool, error) {
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+		return false, err
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedToken{
+		JTI:       jti,
+		Username:  username,
+		ExpiresAt: expiresAt,
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// RevokeToken 撤销 token，已撤销时不报错
+func RevokeToken(db *gorm.DB, jti, username string, expiresAt time.Time) error {
+	_, err := insertRevokedToken(db, jti, username, expiresAt)
+	return err
+}
+
+// ConsumeRefreshToken 原子地使用 refresh token，并发请求同一个 token 时只有一个成功，其余返回 ErrTokenRevoked
+func ConsumeRefreshToken(db *gorm.DB, jti, username string, expiresAt time.Time) error {
+	inserted, err := insertRevokedToken(db, jti, username, expiresAt)
+	if err != nil {
+		return err
+	}
+	if !inserted {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// IsTokenRevoked 检查 token 是否已被撤销
+func IsTokenRevoked(db *gorm.DB, jti string) (bool, error) {
+	var count int64
+	err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
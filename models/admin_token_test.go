@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// revocationDriver 模拟 admin_token_revocation 表的 jti 唯一索引，重复插入时 RowsAffected 为 0，与 MySQL 一致
+type revocationDriver struct {
+	mu   sync.Mutex
+	jtis map[string]bool
+}
+
+type revocationResult struct {
+	id       int64
+	affected int64
+}
+
+func (this revocationResult) LastInsertId() (int64, error) { return this.id, nil }
+
+func (this revocationResult) RowsAffected() (int64, error) { return this.affected, nil }
+
+func (this *revocationDriver) Open(name string) (driver.Conn, error) {
+	return &revocationConn{driver: this}, nil
+}
+
+type revocationConn struct {
+	driver *revocationDriver
+}
+
+func (this *revocationConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare not supported")
+}
+
+func (this *revocationConn) Close() error { return nil }
+
+func (this *revocationConn) Begin() (driver.Tx, error) { return this, nil }
+
+func (this *revocationConn) Commit() error { return nil }
+
+func (this *revocationConn) Rollback() error { return nil }
+
+func (this *revocationConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if !strings.HasPrefix(query, "INSERT") {
+		return driver.RowsAffected(0), nil
+	}
+	jti := args[0].Value.(string)
+	this.driver.mu.Lock()
+	defer this.driver.mu.Unlock()
+	if this.driver.jtis[jti] {
+		return revocationResult{}, nil
+	}
+	this.driver.jtis[jti] = true
+	return revocationResult{id: int64(len(this.driver.jtis)), affected: 1}, nil
+}
+
+func TestConsumeRefreshToken_Replay(t *testing.T) {
+	sql.Register("revocation", &revocationDriver{jtis: map[string]bool{}})
+	conn, err := sql.Open("revocation", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jti, expiresAt := "refresh-jti", time.Now().Add(time.Hour)
+
+	// 同一个 refresh token 并发使用两次，只有一次成功
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- ConsumeRefreshToken(db, jti, "proxy", expiresAt)
+		}()
+	}
+	succeeded, replayed := 0, 0
+	for i := 0; i < 2; i++ {
+		switch err := <-results; {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrTokenRevoked):
+			replayed++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if succeeded != 1 || replayed != 1 {
+		t.Fatalf("refresh token consumed %d times, rejected %d times", succeeded, replayed)
+	}
+
+	// 之后再次使用仍然被拒绝
+	if err := ConsumeRefreshToken(db, jti, "proxy", expiresAt); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected replayed refresh token to be rejected, got %v", err)
+	}
+
+	t.Log("PASS")
+}
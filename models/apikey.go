@@ -1,39 +1,386 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// 预算重置周期
+const (
+	BudgetPeriodDaily    = "daily"
+	BudgetPeriodMonthly  = "monthly"
+	BudgetPeriodLifetime = "lifetime"
+)
+
+// API Key 可访问的接口范围
+const (
+	ScopeMessages   = "messages"   // /v1/messages、/v1/messages/count_tokens、/v1/chat/completions
+	ScopeComplete   = "complete"   // /v1/complete
+	ScopeEmbeddings = "embeddings" // /v1/embeddings
+	ScopeRerank     = "rerank"     // /v1/rerank
+	ScopeAdminRead  = "admin:read" // 只读访问管理接口
+)
+
+// DefaultScopes 未配置 scopes 时可访问的接口，不包含管理接口
+var DefaultScopes = []string{ScopeMessages, ScopeComplete, ScopeEmbeddings, ScopeRerank}
+
 type APIKey struct {
 	gorm.Model
-	Name    string `gorm:"column:name;not null;unique;varchar(255)" json:"name"`
-	Value   string `gorm:"column:value;not null;unique;varchar(255)" json:"value"`
-	Enable  bool   `gorm:"column:enable;not null;default:true;bool" json:"enable"`
+	Name   string `gorm:"column:name;not null;unique;varchar(255)" json:"name"`
+	Enable bool   `gorm:"column:enable;not null;default:true;bool" json:"enable"`
+	// 密钥只保存加盐哈希，明文仅在创建时返回一次
+	Prefix string `gorm:"column:prefix;type:varchar(32);not null;default:'';index" json:"prefix"` // 用于展示和查找的密钥前缀，如 bk-1a2b3c4d
+	Salt   string `gorm:"column:salt;type:varchar(64);not null;default:''" json:"-"`
+	Hash   string `gorm:"column:hash;type:varchar(64);not null;default:''" json:"-"` // sha256(salt + 密钥)
+	// 过期时间，为空表示永不过期
+	ExpiresAt *time.Time `gorm:"column:expires_at" json:"expires_at"`
+	// 轮换前的旧密钥，在宽限期内仍然有效
+	PreviousPrefix    string     `gorm:"column:previous_prefix;type:varchar(32);not null;default:'';index" json:"previous_prefix"`
+	PreviousSalt      string     `gorm:"column:previous_salt;type:varchar(64);not null;default:''" json:"-"`
+	PreviousHash      string     `gorm:"column:previous_hash;type:varchar(64);not null;default:''" json:"-"`
+	PreviousExpiresAt *time.Time `gorm:"column:previous_expires_at" json:"previous_expires_at"`
+	// 速率限制，0 表示不限制
+	RPM       int `gorm:"column:rpm;not null;default:0" json:"rpm"`               // 每分钟请求数
+	InputTPM  int `gorm:"column:input_tpm;not null;default:0" json:"input_tpm"`   // 每分钟输入 token 数
+	OutputTPM int `gorm:"column:output_tpm;not null;default:0" json:"output_tpm"` // 每分钟输出 token 数
+	// 预算，单位与 Usage.Quota 相同，0 表示不限制
+	BudgetHardLimit int64  `gorm:"column:budget_hard_limit;not null;default:0" json:"budget_hard_limit"` // 超出后拒绝请求
+	BudgetSoftLimit int64  `gorm:"column:budget_soft_limit;not null;default:0" json:"budget_soft_limit"` // 超出后仅告警
+	BudgetPeriod    string `gorm:"column:budget_period;not null;default:'monthly'" json:"budget_period"` // daily / monthly / lifetime
+	// 权限，逗号分隔
+	AllowedModels string `gorm:"column:allowed_models;type:varchar(1024);not null;default:''" json:"allowed_models"` // 允许使用的模型，支持 * 通配符，为空表示不限制
+	Scopes        string `gorm:"column:scopes;type:varchar(255);not null;default:''" json:"scopes"`                  // 允许访问的接口，为空表示 DefaultScopes
 }
 
 func (APIKey) TableName() string {
 	return "apikey"
 }
 
+// APIKeyValuePrefix 密钥明文的固定前缀，用于区分 API Key 和管理员 token
+const APIKeyValuePrefix = "bk-"
+
+// APIKeyPrefixLength 密钥前缀的长度，"bk-" 加 8 个十六进制字符
+const APIKeyPrefixLength = 11
+
 func CreateAPIKey(db *gorm.DB, name, value string) error {
 	apiKey := APIKey{
-		Name:    name,
-		Value:   value,
-		Enable:  true,
+		Name:   name,
+		Enable: true,
+	}
+	if err := apiKey.SetSecret(value); err != nil {
+		return err
 	}
 
 	return db.Create(&apiKey).Error
 }
 
+// DefaultRotationGracePeriod 轮换密钥时旧密钥默认的有效期
+const DefaultRotationGracePeriod = 24 * time.Hour
+
+// GetAPIKey 根据密钥明文查找已启用的 API Key，先按前缀筛选，再校验当前或轮换前的密钥哈希。
+// 不检查是否过期，由调用方通过 IsExpired 判断。
 func GetAPIKey(db *gorm.DB, value string) (APIKey, error) {
-	var apiKey APIKey
-	result := db.Where("value = ? and enable = ?", value, true).First(&apiKey)
+	prefix := APIKeyDisplayPrefix(value)
+	var apiKeys []APIKey
+	result := db.Where("(prefix = ? or previous_prefix = ?) and enable = ?", prefix, prefix, true).Find(&apiKeys)
 	if result.Error != nil {
 		return APIKey{}, result.Error
 	}
-	return apiKey, nil
+	for _, apiKey := range apiKeys {
+		if apiKey.VerifySecret(value) || apiKey.VerifyPreviousSecret(value) {
+			return apiKey, nil
+		}
+	}
+	return APIKey{}, gorm.ErrRecordNotFound
+}
+
+// APIKeyDisplayPrefix 返回密钥用于展示和查找的前缀
+func APIKeyDisplayPrefix(value string) string {
+	if len(value) > APIKeyPrefixLength {
+		return value[:APIKeyPrefixLength]
+	}
+	return value
+}
+
+// HashAPIKey 计算加盐后的密钥哈希
+func HashAPIKey(salt, value string) string {
+	hash := sha256.Sum256([]byte(salt + value))
+	return hex.EncodeToString(hash[:])
+}
+
+// SetSecret 生成随机盐并保存密钥的前缀和哈希
+func (this *APIKey) SetSecret(value string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	this.Prefix = APIKeyDisplayPrefix(value)
+	this.Salt = hex.EncodeToString(salt)
+	this.Hash = HashAPIKey(this.Salt, value)
+	return nil
+}
+
+// VerifySecret 校验密钥明文是否与保存的哈希一致
+func (this *APIKey) VerifySecret(value string) bool {
+	if len(this.Hash) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(this.Hash), []byte(HashAPIKey(this.Salt, value))) == 1
+}
+
+// VerifyPreviousSecret 校验密钥明文是否为轮换前的旧密钥
+func (this *APIKey) VerifyPreviousSecret(value string) bool {
+	if len(this.PreviousHash) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(this.PreviousHash), []byte(HashAPIKey(this.PreviousSalt, value))) == 1
+}
+
+// IsExpired 检查使用的密钥是否已过期，旧密钥同时受宽限期和 API Key 过期时间的限制
+func (this *APIKey) IsExpired(value string, now time.Time) bool {
+	if this.ExpiresAt != nil && !now.Before(*this.ExpiresAt) {
+		return true
+	}
+	if !this.VerifySecret(value) && this.VerifyPreviousSecret(value) {
+		return this.PreviousExpiresAt == nil || !now.Before(*this.PreviousExpiresAt)
+	}
+	return false
+}
+
+// Rotate 设置新的密钥，当前密钥转为旧密钥并在 gracePeriod 后失效，之前的旧密钥立即失效
+func (this *APIKey) Rotate(value string, gracePeriod time.Duration, expiresAt *time.Time, now time.Time) error {
+	previousExpiresAt := now.Add(gracePeriod)
+	if this.ExpiresAt != nil && this.ExpiresAt.Before(previousExpiresAt) {
+		previousExpiresAt = *this.ExpiresAt
+	}
+	this.PreviousPrefix = this.Prefix
+	this.PreviousSalt = this.Salt
+	this.PreviousHash = this.Hash
+	this.PreviousExpiresAt = &previousExpiresAt
+	this.ExpiresAt = expiresAt
+	return this.SetSecret(value)
+}
+
+// SaveAPIKeySecret 保存轮换后的密钥哈希和过期时间
+func SaveAPIKeySecret(db *gorm.DB, apiKey *APIKey) error {
+	return db.Model(apiKey).Select(
+		"prefix", "salt", "hash", "expires_at",
+		"previous_prefix", "previous_salt", "previous_hash", "previous_expires_at",
+	).Updates(apiKey).Error
+}
+
+// MigrateAPIKeyHashes 将旧版明文保存的密钥迁移为加盐哈希，并删除明文列。
+// 迁移后原有密钥仍然可以使用。
+func MigrateAPIKeyHashes(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&APIKey{}, "value") {
+		return nil
+	}
+
+	var legacyKeys []struct {
+		ID    uint
+		Value string
+	}
+	if err := db.Model(&APIKey{}).Unscoped().Select("id, value").Where("hash = ?", "").Scan(&legacyKeys).Error; err != nil {
+		return err
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, legacyKey := range legacyKeys {
+			if len(legacyKey.Value) == 0 {
+				return fmt.Errorf("api key %d has no value to migrate", legacyKey.ID)
+			}
+			var apiKey APIKey
+			if err := apiKey.SetSecret(legacyKey.Value); err != nil {
+				return err
+			}
+			err := tx.Model(&APIKey{}).Unscoped().Where("id = ?", legacyKey.ID).Updates(map[string]interface{}{
+				"prefix": apiKey.Prefix,
+				"salt":   apiKey.Salt,
+				"hash":   apiKey.Hash,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// MySQL 的 DDL 会隐式提交事务，所以在全部密钥迁移完成后再删除明文列
+	return db.Migrator().DropColumn(&APIKey{}, "value")
 }
 
 func UpdateAPIKeyStatusByName(db *gorm.DB, name string, enable bool) error {
 	return db.Model(&APIKey{}).Where("name = ?", name).Update("enable", enable).Error
 }
+
+func UpdateAPIKeyRateLimitByName(db *gorm.DB, name string, rpm, inputTPM, outputTPM int) (int64, error) {
+	result := db.Model(&APIKey{}).Where("name = ?", name).Updates(map[string]interface{}{
+		"rpm":        rpm,
+		"input_tpm":  inputTPM,
+		"output_tpm": outputTPM,
+	})
+	return result.RowsAffected, result.Error
+}
+
+func GetAPIKeyByName(db *gorm.DB, name string) (APIKey, error) {
+	var apiKey APIKey
+	result := db.Where("name = ?", name).First(&apiKey)
+	if result.Error != nil {
+		return APIKey{}, result.Error
+	}
+	return apiKey, nil
+}
+
+func UpdateAPIKeyBudgetByName(db *gorm.DB, name string, hardLimit, softLimit int64, period string) (int64, error) {
+	result := db.Model(&APIKey{}).Where("name = ?", name).Updates(map[string]interface{}{
+		"budget_hard_limit": hardLimit,
+		"budget_soft_limit": softLimit,
+		"budget_period":     period,
+	})
+	return result.RowsAffected, result.Error
+}
+
+// ValidateBudgetPeriod 检查预算周期是否合法
+func ValidateBudgetPeriod(period string) error {
+	switch period {
+	case BudgetPeriodDaily, BudgetPeriodMonthly, BudgetPeriodLifetime:
+		return nil
+	}
+	return fmt.Errorf("invalid budget period: %s", period)
+}
+
+// BudgetPeriodStart 返回 now 所在预算周期的开始时间，lifetime 返回零值
+func BudgetPeriodStart(period string, now time.Time) time.Time {
+	year, month, day := now.Date()
+	switch period {
+	case BudgetPeriodDaily:
+		return time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	case BudgetPeriodMonthly, "":
+		return time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+	}
+	return time.Time{}
+}
+
+func UpdateAPIKeyPermissionsByName(db *gorm.DB, name string, allowedModels, scopes []string) (int64, error) {
+	result := db.Model(&APIKey{}).Where("name = ?", name).Updates(map[string]interface{}{
+		"allowed_models": strings.Join(allowedModels, ","),
+		"scopes":         strings.Join(scopes, ","),
+	})
+	return result.RowsAffected, result.Error
+}
+
+// splitList 拆分逗号分隔的列表，忽略空白项
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// GetAllowedModels 返回允许使用的模型规则，为空表示不限制
+func (this *APIKey) GetAllowedModels() []string {
+	return splitList(this.AllowedModels)
+}
+
+// IsModelAllowed 检查模型是否匹配任一允许的规则，规则语法见 compileModelPattern
+func (this *APIKey) IsModelAllowed(model string) bool {
+	patterns := this.GetAllowedModels()
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if re, err := compileModelPattern(pattern); err == nil && re.MatchString(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileModelPattern 将模型规则转换为正则表达式。规则与 path.Match 类似，支持 *、?、[...] 和 \ 转义，
+// 但 * 和 ? 也匹配 /，使 arn:aws:bedrock:* 之类的规则能够匹配推理配置文件的 ARN
+func compileModelPattern(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i++; i >= len(pattern) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end <= 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+1+end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// GetScopes 返回允许访问的接口范围，未配置时返回 DefaultScopes
+func (this *APIKey) GetScopes() []string {
+	scopes := splitList(this.Scopes)
+	if len(scopes) == 0 {
+		return DefaultScopes
+	}
+	return scopes
+}
+
+// HasScope 检查是否允许访问指定的接口范围
+func (this *APIKey) HasScope(scope string) bool {
+	for _, item := range this.GetScopes() {
+		if item == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateModelPatterns 检查模型规则的语法是否合法
+func ValidateModelPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := compileModelPattern(pattern); err != nil {
+			return fmt.Errorf("invalid model pattern: %s", pattern)
+		}
+	}
+	return nil
+}
+
+// ValidateScopes 检查接口范围是否合法
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		switch scope {
+		case ScopeMessages, ScopeComplete, ScopeEmbeddings, ScopeRerank, ScopeAdminRead:
+			continue
+		}
+		return fmt.Errorf("invalid scope: %s", scope)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyChange 记录 API Key 的变更，多个代理实例通过轮询该表使本地缓存失效
+type APIKeyChange struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	APIKeyID  uint      `gorm:"column:apikey_id;not null" json:"apikey_id"`
+	CreatedAt time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+func (APIKeyChange) TableName() string {
+	return "apikey_change"
+}
+
+func CreateAPIKeyChange(db *gorm.DB, apiKeyId uint) error {
+	return db.Create(&APIKeyChange{APIKeyID: apiKeyId}).Error
+}
+
+// ListAPIKeyChangesSince 返回 since 及之后创建的变更记录。
+// 自增 ID 的分配顺序与事务提交顺序不一致，轮询时不能以 ID 为游标，调用方需要保留重叠窗口并按 ID 去重
+func ListAPIKeyChangesSince(db *gorm.DB, since time.Time) ([]APIKeyChange, error) {
+	var changes []APIKeyChange
+	err := db.Where("created_at >= ?", since).Order("created_at, id").Find(&changes).Error
+	return changes, err
+}
+
+// DeleteAPIKeyChangesBefore 清理 before 之前的变更记录
+func DeleteAPIKeyChangesBefore(db *gorm.DB, before time.Time) error {
+	return db.Where("created_at < ?", before).Delete(&APIKeyChange{}).Error
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKey_IsModelAllowed(t *testing.T) {
+	apiKey := &APIKey{AllowedModels: "claude-3-5-haiku-*, arn:aws:bedrock:*, amazon.titan-embed-text-v?:0"}
+
+	cases := map[string]bool{
+		"claude-3-5-haiku-latest": true,
+		"claude-opus-4-1":         false,
+		// * 也匹配 /，推理配置文件的 ARN 可以被 arn:aws:bedrock:* 匹配
+		"arn:aws:bedrock:us-east-1:123456789012:application-inference-profile/abc123": true,
+		"arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-3-haiku":        true,
+		"arn:aws:sagemaker:us-east-1:123456789012:endpoint/abc":                       false,
+		"amazon.titan-embed-text-v2:0":                                                true,
+		"amazon.titan-embed-text-v10:0":                                               false,
+	}
+	for model, expected := range cases {
+		if got := apiKey.IsModelAllowed(model); got != expected {
+			t.Fatalf("%s: got %v, want %v", model, got, expected)
+		}
+	}
+
+	// 未配置规则时不限制
+	if !(&APIKey{}).IsModelAllowed("anything") {
+		t.Fatal("expected all models allowed without rules")
+	}
+	t.Log("PASS")
+}
+
+func TestValidateModelPatterns(t *testing.T) {
+	if err := ValidateModelPatterns([]string{"claude-*", "us.anthropic.claude-[!a]*", `model\*`}); err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"claude-[", `claude\`} {
+		if err := ValidateModelPatterns([]string{pattern}); err == nil {
+			t.Fatalf("expected error for %s", pattern)
+		}
+	}
+	t.Log("PASS")
+}
+
+func TestAPIKey_SetSecret(t *testing.T) {
+	value := "bk-1a2b3c4d" + strings.Repeat("0", 56)
+
+	var apiKey APIKey
+	if err := apiKey.SetSecret(value); err != nil {
+		t.Fatal(err)
+	}
+	if apiKey.Prefix != "bk-1a2b3c4d" {
+		t.Fatalf("unexpected prefix: %s", apiKey.Prefix)
+	}
+	if strings.Contains(apiKey.Hash, value) || len(apiKey.Salt) == 0 {
+		t.Fatal("secret should be stored as salted hash")
+	}
+	if !apiKey.VerifySecret(value) || apiKey.VerifySecret(value+"0") {
+		t.Fatal("unexpected verify result")
+	}
+
+	// 同一个密钥每次使用不同的盐
+	var other APIKey
+	other.SetSecret(value)
+	if other.Hash == apiKey.Hash {
+		t.Fatal("hash should differ with different salt")
+	}
+
+	// 未设置哈希的密钥不能通过校验
+	if (&APIKey{}).VerifySecret("") {
+		t.Fatal("empty hash should not verify")
+	}
+
+	t.Log("PASS")
+}
+
+func TestAPIKey_Rotate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	oldValue := "bk-00000001" + strings.Repeat("a", 56)
+	newValue := "bk-00000002" + strings.Repeat("b", 56)
+
+	var apiKey APIKey
+	apiKey.SetSecret(oldValue)
+	if err := apiKey.Rotate(newValue, time.Hour, nil, now); err != nil {
+		t.Fatal(err)
+	}
+	if apiKey.Prefix != "bk-00000002" || apiKey.PreviousPrefix != "bk-00000001" {
+		t.Fatalf("unexpected prefixes: %s %s", apiKey.Prefix, apiKey.PreviousPrefix)
+	}
+
+	// 宽限期内新旧密钥都有效
+	if apiKey.IsExpired(newValue, now) || apiKey.IsExpired(oldValue, now.Add(59*time.Minute)) {
+		t.Fatal("keys should be valid during grace period")
+	}
+	// 宽限期后旧密钥失效
+	if !apiKey.IsExpired(oldValue, now.Add(time.Hour)) || apiKey.IsExpired(newValue, now.Add(time.Hour)) {
+		t.Fatal("previous key should expire after grace period")
+	}
+
+	// 宽限期不超过 API Key 原有的过期时间
+	expiresAt := now.Add(10 * time.Minute)
+	apiKey.ExpiresAt = &expiresAt
+	newExpiresAt := now.Add(30 * 24 * time.Hour)
+	apiKey.Rotate(oldValue, time.Hour, &newExpiresAt, now)
+	if !apiKey.PreviousExpiresAt.Equal(expiresAt) {
+		t.Fatalf("unexpected previous expires at: %s", apiKey.PreviousExpiresAt)
+	}
+	if !apiKey.IsExpired(oldValue, newExpiresAt) {
+		t.Fatal("key should expire at expires_at")
+	}
+	t.Log("PASS")
+}
+
+func TestBudgetPeriodStart(t *testing.T) {
+	now := time.Date(2025, 3, 15, 12, 30, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		BudgetPeriodDaily:    time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC),
+		BudgetPeriodMonthly:  time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		BudgetPeriodLifetime: {},
+	}
+	for period, expected := range cases {
+		if start := BudgetPeriodStart(period, now); !start.Equal(expected) {
+			t.Errorf("%s: got %s, want %s", period, start, expected)
+		}
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审计日志记录的管理操作
+const (
+	AuditActionAPIKeyCreate      = "apikey.create"
+	AuditActionAPIKeyDelete      = "apikey.delete"
+	AuditActionAPIKeyEnable      = "apikey.enable"
+	AuditActionAPIKeyDisable     = "apikey.disable"
+	AuditActionAPIKeyRateLimit   = "apikey.ratelimit"
+	AuditActionAPIKeyBudget      = "apikey.budget"
+	AuditActionAPIKeyPermissions = "apikey.permissions"
+	AuditActionAPIKeyRotate      = "apikey.rotate"
+	AuditActionAdminLogin        = "admin.login"
+	AuditActionAdminLoginFailed  = "admin.login_failed"
+	AuditActionAdminRefresh      = "admin.refresh"
+	AuditActionAdminLogout       = "admin.logout"
+	AuditActionAdminPassword     = "admin.password"
+	AuditActionAdminTOTPEnable   = "admin.totp_enable"
+	AuditActionAdminTOTPDisable  = "admin.totp_disable"
+	AuditActionAdminCreate       = "admin.create"
+	AuditActionAdminUpdate       = "admin.update"
+	AuditActionAdminDelete       = "admin.delete"
+)
+
+// AuditLog 管理操作的审计记录，只追加不修改
+type AuditLog struct {
+	ID       uint   `gorm:"primarykey" json:"id"`
+	Actor    string `gorm:"column:actor;type:varchar(255);not null;index" json:"actor"`   // 执行操作的管理员
+	Action   string `gorm:"column:action;type:varchar(64);not null;index" json:"action"`  // 操作类型，如 apikey.disable
+	Target   string `gorm:"column:target;type:varchar(255);not null;index" json:"target"` // 操作对象，API Key 名称或管理员用户名
+	Before   string `gorm:"column:before;type:text" json:"before"`                        // 操作前的状态，JSON
+	After    string `gorm:"column:after;type:text" json:"after"`                          // 操作后的状态，JSON
+	SourceIP string `gorm:"column:source_ip;type:varchar(64);not null;default:''" json:"source_ip"`
+	// 操作时间
+	CreatedAt time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
+
+// AuditLogFilter 审计日志的查询条件，为空表示不过滤
+type AuditLogFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Start  *time.Time
+	End    *time.Time
+}
+
+func CreateAuditLog(db *gorm.DB, auditLog *AuditLog) error {
+	return db.Create(auditLog).Error
+}
+
+// ListAuditLogs 按时间倒序分页查询审计日志，返回当前页的记录和总数
+func ListAuditLogs(db *gorm.DB, filter AuditLogFilter, page, pageSize int) ([]AuditLog, int64, error) {
+	query := db.Model(&AuditLog{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if filter.Start != nil {
+		query = query.Where("created_at >= ?", *filter.Start)
+	}
+	if filter.End != nil {
+		query = query.Where("created_at <= ?", *filter.End)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var auditLogs []AuditLog
+	err := query.Order("created_at DESC, id DESC").
+		Limit(pageSize).
+		Offset((page - 1) * pageSize).
+		Find(&auditLogs).Error
+	return auditLogs, total, err
+}
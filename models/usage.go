@@ -1,27 +1,35 @@
 package models
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// QuotaPerUSD 额度与美元的换算，500000 额度为 1 美元（模型倍率 1 对应 0.002 美元 / 1K tokens）
+const QuotaPerUSD = 500000
+
 type Usage struct {
 	gorm.Model
-	APIKeyName  string `gorm:"column:apikey_name;not null;varchar(255)" json:"apikey_name"`
-	APIKeyValue string `gorm:"column:apikey_value;not null;varchar(255)" json:"apikey_value"`
-	ModelName    string  `gorm:"column:model_name;not null;varchar(255)" json:"model_name"`
-	InputTokens  int     `gorm:"column:input_tokens;not null;int" json:"input_tokens"`     // 输入token数量
-	OutputTokens int     `gorm:"column:output_tokens;not null;int" json:"output_tokens"`    // 输出token数量
-	Quota        int     `gorm:"column:quota;not null;int;default:0" json:"quota"`  // 额度，乘以0.002就是美元
+	APIKeyName   string `gorm:"column:apikey_name;type:varchar(255);not null" json:"apikey_name"`
+	APIKeyPrefix string `gorm:"column:apikey_prefix;type:varchar(32);not null;default:''" json:"apikey_prefix"` // 密钥前缀，不保存明文
+	ModelName    string `gorm:"column:model_name;not null;varchar(255)" json:"model_name"`
+	InputTokens  int    `gorm:"column:input_tokens;not null;int" json:"input_tokens"`           // 输入token数量
+	OutputTokens int    `gorm:"column:output_tokens;not null;int" json:"output_tokens"`         // 输出token数量
+	SearchUnits  int    `gorm:"column:search_units;not null;int;default:0" json:"search_units"` // rerank 的搜索单元数量
+	Quota        int    `gorm:"column:quota;not null;int;default:0" json:"quota"`               // 额度，除以 QuotaPerUSD 就是美元
 }
 
 func (Usage) TableName() string {
 	return "usage"
 }
 
-func CreateUsage(db *gorm.DB, apiKeyName, apiKeyValue, modelName string, inputTokens, outputTokens int, quota int) error {
+func CreateUsage(db *gorm.DB, apiKeyName, apiKeyPrefix, modelName string, inputTokens, outputTokens int, quota int) error {
 	usage := Usage{
-		APIKeyName:  apiKeyName,
-		APIKeyValue:  apiKeyValue,
+		APIKeyName:   apiKeyName,
+		APIKeyPrefix: apiKeyPrefix,
 		ModelName:    modelName,
 		InputTokens:  inputTokens,
 		OutputTokens: outputTokens,
@@ -29,3 +37,171 @@ func CreateUsage(db *gorm.DB, apiKeyName, apiKeyValue, modelName string, inputTo
 	}
 	return db.Create(&usage).Error
 }
+
+// CreateSearchUsage 记录按搜索单元计费的调用（rerank）
+func CreateSearchUsage(db *gorm.DB, apiKeyName, apiKeyPrefix, modelName string, searchUnits int, quota int) error {
+	usage := Usage{
+		APIKeyName:   apiKeyName,
+		APIKeyPrefix: apiKeyPrefix,
+		ModelName:    modelName,
+		SearchUnits:  searchUnits,
+		Quota:        quota,
+	}
+	return db.Create(&usage).Error
+}
+
+// SumQuotaSince 统计 API 密钥自 since 以来消耗的额度
+func SumQuotaSince(db *gorm.DB, apiKeyName string, since time.Time) (int64, error) {
+	var total int64
+	err := db.Model(&Usage{}).
+		Select("COALESCE(SUM(quota), 0)").
+		Where("apikey_name = ? AND created_at >= ?", apiKeyName, since).
+		Scan(&total).Error
+	return total, err
+}
+
+// usageAPIKeyIndex 预算按 API Key 和时间汇总额度使用的联合索引，gorm.Model 的 created_at 无法在结构体标签中声明
+const usageAPIKeyIndex = "idx_usage_apikey_created"
+
+// MigrateUsageIndexes 创建 usage(apikey_name, created_at) 联合索引，SumQuotaSince 每个 API Key 定期执行
+func MigrateUsageIndexes(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&Usage{}, usageAPIKeyIndex) {
+		return nil
+	}
+	return db.Exec("CREATE INDEX " + usageAPIKeyIndex + " ON `usage` (apikey_name, created_at)").Error
+}
+
+// MigrateUsageAPIKeyPrefix 将旧版使用记录中的密钥明文替换为前缀，并删除明文列
+func MigrateUsageAPIKeyPrefix(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Usage{}, "apikey_value") {
+		return nil
+	}
+	err := db.Model(&Usage{}).Unscoped().Where("apikey_prefix = ?", "").
+		Update("apikey_prefix", gorm.Expr("SUBSTR(apikey_value, 1, ?)", APIKeyPrefixLength)).Error
+	if err != nil {
+		return err
+	}
+	return db.Migrator().DropColumn(&Usage{}, "apikey_value")
+}
+
+// 使用统计的分组维度
+const (
+	UsageGroupAPIKey = "apikey"
+	UsageGroupModel  = "model"
+)
+
+// 使用统计的时间粒度
+const (
+	UsagePeriodHour  = "hour"
+	UsagePeriodDay   = "day"
+	UsagePeriodMonth = "month"
+)
+
+// 各时间粒度对应的 MySQL DATE_FORMAT 格式
+var usagePeriodFormats = map[string]string{
+	UsagePeriodHour:  "%Y-%m-%d %H:00",
+	UsagePeriodDay:   "%Y-%m-%d",
+	UsagePeriodMonth: "%Y-%m",
+}
+
+// UsageFilter 使用记录的查询条件，为空表示不过滤
+type UsageFilter struct {
+	APIKeyName string
+	ModelName  string
+	Start      *time.Time
+	End        *time.Time
+}
+
+// Apply 将查询条件应用到使用记录的查询上
+func (this UsageFilter) Apply(query *gorm.DB) *gorm.DB {
+	if this.APIKeyName != "" {
+		query = query.Where("apikey_name = ?", this.APIKeyName)
+	}
+	if this.ModelName != "" {
+		query = query.Where("model_name = ?", this.ModelName)
+	}
+	if this.Start != nil {
+		query = query.Where("created_at >= ?", *this.Start)
+	}
+	if this.End != nil {
+		query = query.Where("created_at <= ?", *this.End)
+	}
+	return query
+}
+
+// UsageSummary 一组使用记录的汇总，未参与分组的维度为空
+type UsageSummary struct {
+	APIKeyName   string `gorm:"column:apikey_name" json:"apikey_name,omitempty"`
+	ModelName    string `gorm:"column:model_name" json:"model_name,omitempty"`
+	Period       string `gorm:"column:period" json:"period,omitempty"` // 时间段的开始，如 2024-05、2024-05-01、2024-05-01 13:00
+	Requests     int64  `gorm:"column:requests" json:"requests"`
+	InputTokens  int64  `gorm:"column:input_tokens" json:"input_tokens"`
+	OutputTokens int64  `gorm:"column:output_tokens" json:"output_tokens"`
+	SearchUnits  int64  `gorm:"column:search_units" json:"search_units"`
+	Quota        int64  `gorm:"column:quota" json:"quota"`
+}
+
+// ValidateUsageGroupBy 检查分组维度是否有效
+func ValidateUsageGroupBy(groupBy []string) error {
+	for _, group := range groupBy {
+		if group != UsageGroupAPIKey && group != UsageGroupModel {
+			return fmt.Errorf("invalid group_by %s, must be %s or %s", group, UsageGroupAPIKey, UsageGroupModel)
+		}
+	}
+	return nil
+}
+
+// ValidateUsagePeriod 检查时间粒度是否有效，为空表示不按时间分组
+func ValidateUsagePeriod(period string) error {
+	if _, ok := usagePeriodFormats[period]; period != "" && !ok {
+		return fmt.Errorf("invalid period %s, must be one of %s, %s, %s", period, UsagePeriodHour, UsagePeriodDay, UsagePeriodMonth)
+	}
+	return nil
+}
+
+// SummarizeUsage 在数据库中按 API Key、模型和时间段汇总使用记录，按分组维度排序
+func SummarizeUsage(db *gorm.DB, filter UsageFilter, groupBy []string, period string) ([]UsageSummary, error) {
+	var groups []string
+	for _, group := range groupBy {
+		switch group {
+		case UsageGroupAPIKey:
+			groups = append(groups, "apikey_name")
+		case UsageGroupModel:
+			groups = append(groups, "model_name")
+		}
+	}
+	columns := append([]string{}, groups...)
+	if format, ok := usagePeriodFormats[period]; ok {
+		columns = append(columns, fmt.Sprintf("DATE_FORMAT(created_at, '%s') AS period", format))
+		groups = append(groups, "period")
+	}
+	columns = append(columns,
+		"COUNT(*) AS requests",
+		"COALESCE(SUM(input_tokens), 0) AS input_tokens",
+		"COALESCE(SUM(output_tokens), 0) AS output_tokens",
+		"COALESCE(SUM(search_units), 0) AS search_units",
+		"COALESCE(SUM(quota), 0) AS quota",
+	)
+
+	query := filter.Apply(db.Model(&Usage{})).Select(strings.Join(columns, ", "))
+	if len(groups) > 0 {
+		query = query.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+	}
+
+	var summaries []UsageSummary
+	err := query.Scan(&summaries).Error
+	return summaries, err
+}
+
+// EachUsage 按 ID 顺序分批读取符合条件的使用记录，内存中最多保留 batchSize 条
+func EachUsage(db *gorm.DB, filter UsageFilter, batchSize int, fn func(usage *Usage) error) error {
+	var batch []Usage
+	return filter.Apply(db.Model(&Usage{})).FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	log "bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+
+	"gorm.io/gorm"
+)
+
+// 默认的缓存有效期和跨实例失效的轮询间隔
+const (
+	DefaultAPIKeyCacheTTL             = 60 * time.Second
+	DefaultAPIKeyInvalidationInterval = 5 * time.Second
+)
+
+// 轮询 apikey_change 时向前重叠的时间，覆盖实例之间的时钟偏差和写入到提交之间的延迟
+const apiKeyChangeOverlap = time.Minute
+
+// APIKeyInvalidator 在多个代理实例之间传播 API Key 的变更，可以替换为 Redis 等发布订阅实现
+type APIKeyInvalidator interface {
+	// Publish 通知所有实例 API Key 已变更
+	Publish(apiKeyId uint) error
+	// Subscribe 接收变更通知并调用 handler，直到 ctx 结束
+	Subscribe(ctx context.Context, handler func(apiKeyId uint))
+}
+
+type apiKeyCacheEntry struct {
+	apiKey   *models.APIKey
+	loadedAt time.Time
+}
+
+// APIKeyCache 以密钥的哈希为键缓存 API Key，内存中不保留密钥明文。
+// 缓存项在 ttl 后重新从数据库加载；管理接口修改 API Key 时按 ID 使缓存失效，并通过 invalidator 通知其他实例。
+// 其他实例的撤销延迟不超过 invalidator 的通知延迟，invalidator 不可用时不超过 ttl。
+type APIKeyCache struct {
+	mutex   sync.RWMutex
+	entries map[string]*apiKeyCacheEntry
+	// 每次失效时递增，避免失效前开始的加载把旧数据写回缓存
+	generation  uint64
+	ttl         time.Duration
+	now         func() time.Time
+	load        func(value string) (*models.APIKey, error)
+	invalidator APIKeyInvalidator
+}
+
+func NewAPIKeyCache(ttl time.Duration, load func(value string) (*models.APIKey, error), invalidator APIKeyInvalidator) *APIKeyCache {
+	return &APIKeyCache{
+		entries:     make(map[string]*apiKeyCacheEntry),
+		ttl:         ttl,
+		now:         time.Now,
+		load:        load,
+		invalidator: invalidator,
+	}
+}
+
+// apiKeyCacheKey 缓存以密钥的哈希为键
+func apiKeyCacheKey(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+// Get 从缓存中获取 API Key，不存在或已过期时重新加载
+func (this *APIKeyCache) Get(value string) (*models.APIKey, error) {
+	cacheKey := apiKeyCacheKey(value)
+	now := this.now()
+
+	this.mutex.RLock()
+	entry, exists := this.entries[cacheKey]
+	generation := this.generation
+	this.mutex.RUnlock()
+
+	if exists && now.Sub(entry.loadedAt) < this.ttl {
+		return entry.apiKey, nil
+	}
+
+	apiKey, err := this.load(value)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if err != nil {
+		// 密钥已被禁用或删除
+		delete(this.entries, cacheKey)
+		return nil, err
+	}
+	if generation == this.generation {
+		this.entries[cacheKey] = &apiKeyCacheEntry{apiKey: apiKey, loadedAt: now}
+	}
+	return apiKey, nil
+}
+
+// Invalidate 使本实例中该 API Key 的缓存失效，并通知其他实例
+func (this *APIKeyCache) Invalidate(apiKeyId uint) {
+	this.invalidateLocal(apiKeyId)
+	if this.invalidator != nil {
+		if err := this.invalidator.Publish(apiKeyId); err != nil {
+			log.Logger.Errorf("Failed to publish api key change %d: %v", apiKeyId, err)
+		}
+	}
+}
+
+func (this *APIKeyCache) invalidateLocal(apiKeyId uint) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.generation++
+	for cacheKey, entry := range this.entries {
+		if entry.apiKey.ID == apiKeyId {
+			delete(this.entries, cacheKey)
+		}
+	}
+}
+
+// Start 开始接收其他实例的变更通知
+func (this *APIKeyCache) Start(ctx context.Context) {
+	if this.invalidator != nil {
+		go this.invalidator.Subscribe(ctx, this.invalidateLocal)
+	}
+}
+
+// DBAPIKeyInvalidator 通过轮询 apikey_change 表在实例之间传播变更，不需要额外的中间件
+type DBAPIKeyInvalidator struct {
+	db        *gorm.DB
+	interval  time.Duration
+	retention time.Duration
+}
+
+func NewDBAPIKeyInvalidator(db *gorm.DB, interval time.Duration) *DBAPIKeyInvalidator {
+	return &DBAPIKeyInvalidator{
+		db:       db,
+		interval: interval,
+		// 变更记录只需要保留到所有实例都轮询过
+		retention: 24 * time.Hour,
+	}
+}
+
+func (this *DBAPIKeyInvalidator) Publish(apiKeyId uint) error {
+	return models.CreateAPIKeyChange(this.db, apiKeyId)
+}
+
+func (this *DBAPIKeyInvalidator) Subscribe(ctx context.Context, handler func(apiKeyId uint)) {
+	// 只处理启动之后的变更，启动前的缓存本来就是空的
+	cursor := newAPIKeyChangeCursor(time.Now(), apiKeyChangeOverlap)
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	lastCleanup := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pollStart := time.Now()
+		changes, err := models.ListAPIKeyChangesSince(this.db, cursor.Since())
+		if err != nil {
+			log.Logger.Errorf("Failed to poll api key changes: %v", err)
+			continue
+		}
+		for _, apiKeyId := range cursor.Advance(pollStart, changes) {
+			handler(apiKeyId)
+		}
+
+		if time.Since(lastCleanup) >= this.retention {
+			lastCleanup = time.Now()
+			if err := models.DeleteAPIKeyChangesBefore(this.db, lastCleanup.Add(-this.retention)); err != nil {
+				log.Logger.Errorf("Failed to clean up api key changes: %v", err)
+			}
+		}
+	}
+}
+
+// apiKeyChangeCursor 记录 apikey_change 的轮询进度。
+// 每次从上次轮询开始时间减去 overlap 处查询，晚提交或由时钟偏慢的实例写入的记录也能被读到，
+// 重叠窗口内已处理的记录按 ID 去重
+type apiKeyChangeCursor struct {
+	since   time.Time
+	overlap time.Duration
+	seen    map[uint]time.Time
+}
+
+func newAPIKeyChangeCursor(since time.Time, overlap time.Duration) *apiKeyChangeCursor {
+	return &apiKeyChangeCursor{
+		since:   since,
+		overlap: overlap,
+		seen:    make(map[uint]time.Time),
+	}
+}
+
+// Since 返回本次轮询查询的起始时间
+func (this *apiKeyChangeCursor) Since() time.Time {
+	return this.since.Add(-this.overlap)
+}
+
+// Advance 返回 changes 中尚未处理过的 API Key ID，并把游标移动到本次轮询的开始时间
+func (this *apiKeyChangeCursor) Advance(pollStart time.Time, changes []models.APIKeyChange) []uint {
+	var apiKeyIds []uint
+	for _, change := range changes {
+		if _, ok := this.seen[change.ID]; ok {
+			continue
+		}
+		this.seen[change.ID] = change.CreatedAt
+		apiKeyIds = append(apiKeyIds, change.APIKeyID)
+	}
+
+	this.since = pollStart
+	// 早于下次查询窗口的记录不会再被查到，不需要继续去重
+	for id, createdAt := range this.seen {
+		if createdAt.Before(this.Since()) {
+			delete(this.seen, id)
+		}
+	}
+	return apiKeyIds
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"bedrock-claude-proxy/models"
+)
+
+// memoryInvalidator 同步地把变更广播给所有订阅者，模拟多个实例共享的发布订阅
+type memoryInvalidator struct {
+	handlers []func(apiKeyId uint)
+}
+
+func (this *memoryInvalidator) Publish(apiKeyId uint) error {
+	for _, handler := range this.handlers {
+		handler(apiKeyId)
+	}
+	return nil
+}
+
+func (this *memoryInvalidator) Subscribe(ctx context.Context, handler func(apiKeyId uint)) {
+	this.handlers = append(this.handlers, handler)
+}
+
+// testAPIKeyStore 模拟数据库中的 API Key，记录加载次数
+type testAPIKeyStore struct {
+	enabled bool
+	loads   int
+}
+
+func (this *testAPIKeyStore) load(value string) (*models.APIKey, error) {
+	this.loads++
+	if !this.enabled {
+		return nil, errors.New("record not found")
+	}
+	apiKey := &models.APIKey{Name: "test", Enable: true}
+	apiKey.ID = 1
+	return apiKey, nil
+}
+
+func TestAPIKeyCache_Invalidate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &testAPIKeyStore{enabled: true}
+	broker := &memoryInvalidator{}
+
+	// 两个实例共享同一个数据库和发布订阅
+	replicaA := NewAPIKeyCache(time.Minute, store.load, broker)
+	replicaB := NewAPIKeyCache(time.Minute, store.load, broker)
+	for _, cache := range []*APIKeyCache{replicaA, replicaB} {
+		cache.now = func() time.Time { return now }
+		broker.Subscribe(context.Background(), cache.invalidateLocal)
+	}
+
+	for _, cache := range []*APIKeyCache{replicaA, replicaB, replicaA, replicaB} {
+		if _, err := cache.Get("bk-secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.loads != 2 {
+		t.Fatalf("expected cached lookups, got %d loads", store.loads)
+	}
+
+	// 在实例 A 上禁用后，实例 B 立即失效
+	store.enabled = false
+	replicaA.Invalidate(1)
+	if _, err := replicaB.Get("bk-secret"); err == nil {
+		t.Fatal("disabled key should be rejected on other replica")
+	}
+	if _, err := replicaA.Get("bk-secret"); err == nil {
+		t.Fatal("disabled key should be rejected on local replica")
+	}
+
+	// 重新启用后可以再次使用
+	store.enabled = true
+	replicaA.Invalidate(1)
+	if _, err := replicaB.Get("bk-secret"); err != nil {
+		t.Fatal(err)
+	}
+	t.Log("PASS")
+}
+
+func TestAPIKeyCache_TTL(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &testAPIKeyStore{enabled: true}
+
+	// 没有跨实例通知时，撤销延迟不超过 ttl
+	cache := NewAPIKeyCache(time.Minute, store.load, nil)
+	cache.now = func() time.Time { return now }
+	cache.Get("bk-secret")
+
+	store.enabled = false
+	now = now.Add(59 * time.Second)
+	if _, err := cache.Get("bk-secret"); err != nil {
+		t.Fatal("key should stay cached within ttl")
+	}
+	now = now.Add(time.Second)
+	if _, err := cache.Get("bk-secret"); err == nil {
+		t.Fatal("disabled key should be rejected after ttl")
+	}
+
+	// 失效前开始的加载不会把旧数据写回缓存
+	store.enabled = true
+	cache.load = func(value string) (*models.APIKey, error) {
+		apiKey, err := store.load(value)
+		cache.invalidateLocal(1)
+		return apiKey, err
+	}
+	cache.Get("bk-secret")
+	if len(cache.entries) != 0 {
+		t.Fatal("stale load should not be cached")
+	}
+	t.Log("PASS")
+}
+
+func TestAPIKeyChangeCursor(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	cursor := newAPIKeyChangeCursor(start, time.Minute)
+	change := func(id, apiKeyId uint, createdAt time.Time) models.APIKeyChange {
+		return models.APIKeyChange{ID: id, APIKeyID: apiKeyId, CreatedAt: createdAt}
+	}
+
+	if !cursor.Since().Equal(start.Add(-time.Minute)) {
+		t.Fatalf("unexpected since: %v", cursor.Since())
+	}
+
+	// ID 2 先提交，ID 1 的事务还未提交
+	ids := cursor.Advance(start.Add(5*time.Second), []models.APIKeyChange{
+		change(2, 20, start.Add(3*time.Second)),
+	})
+	if len(ids) != 1 || ids[0] != 20 {
+		t.Fatalf("unexpected first poll: %v", ids)
+	}
+
+	// 下一次轮询与上次重叠，读到晚提交的 ID 1，已处理的 ID 2 不重复通知
+	if !cursor.Since().Equal(start.Add(5*time.Second - time.Minute)) {
+		t.Fatalf("unexpected since: %v", cursor.Since())
+	}
+	ids = cursor.Advance(start.Add(10*time.Second), []models.APIKeyChange{
+		change(1, 10, start.Add(2*time.Second)),
+		change(2, 20, start.Add(3*time.Second)),
+	})
+	if len(ids) != 1 || ids[0] != 10 {
+		t.Fatalf("unexpected second poll: %v", ids)
+	}
+
+	// 离开重叠窗口的记录不再保留
+	cursor.Advance(start.Add(2*time.Minute), nil)
+	if len(cursor.seen) != 0 {
+		t.Fatalf("expected seen to be pruned, got %v", cursor.seen)
+	}
+	t.Log("PASS")
+}
+
+func TestAPIKeyCacheKey(t *testing.T) {
+	value := "bk-1a2b3c4d" + strings.Repeat("0", 56)
+	if apiKeyCacheKey(value) == value || len(apiKeyCacheKey(value)) != 64 {
+		t.Fatal("cache key should be a hash of the secret")
+	}
+	t.Log("PASS")
+}
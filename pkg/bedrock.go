@@ -21,30 +21,47 @@ import (
 	log "bedrock-claude-proxy/log"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
-	awsConfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	bedrock "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 type BedrockConfig struct {
 	AccessKey                string            `json:"access_key"`
 	SecretKey                string            `json:"secret_key"`
 	Region                   string            `json:"region"`
+	SessionToken             string            `json:"session_token,omitempty"`
 	RoleARN                  string            `json:"role_arn,omitempty"`
+	CredentialSource         string            `json:"credential_source,omitempty"`
+	Profile                  string            `json:"profile,omitempty"`
+	WebIdentityRoleARN       string            `json:"web_identity_role_arn,omitempty"`
+	WebIdentityTokenFile     string            `json:"web_identity_token_file,omitempty"`
 	AnthropicVersionMappings map[string]string `json:"anthropic_version_mappings"`
 	ModelMappings            map[string]string `json:"model_mappings"`
+	ModelBackends            map[string]string `json:"model_backends,omitempty"` // 模型使用的调用方式 invoke / converse，未配置时按模型 ID 自动选择
 	AnthropicDefaultModel    string            `json:"anthropic_default_model"`
 	AnthropicDefaultVersion  string            `json:"anthropic_default_version"`
 	EnableComputerUse        bool              `json:"enable_computer_use"`
 	EnableOutputReason       bool              `json:"enable_output_reasoning"`
 	ReasonBudgetTokens       int               `json:"reason_budget_tokens"`
+	RequestTimeout           int               `json:"request_timeout,omitempty"`     // 单个请求（含流式输出）的超时时间，单位秒，0 表示不限制
+	StreamIdleTimeout        int               `json:"stream_idle_timeout,omitempty"` // 流式输出两个事件之间的最长等待时间，单位秒，0 表示不限制
+	TokenizerBPEFile         string            `json:"tokenizer_bpe_file,omitempty"`  // 本地分词器词表的路径或 URL，未配置时下载 cl100k_base
 	DEBUG                    bool              `json:"debug,omitempty"`
 }
 
+func (this *BedrockConfig) GetRequestTimeout() time.Duration {
+	return time.Duration(this.RequestTimeout) * time.Second
+}
+
+func (this *BedrockConfig) GetStreamIdleTimeout() time.Duration {
+	return time.Duration(this.StreamIdleTimeout) * time.Second
+}
+
 func (this *BedrockConfig) GetInvokeEndpoint(modelId string) string {
 	return fmt.Sprintf("bedrock-runtime.%s.amazonaws.com/model/%s/invoke", this.Region, modelId)
 }
@@ -64,7 +81,7 @@ func ParseMappingsFromStr(raw string) map[string]string {
 	// 遍历每个键值对
 	for _, pair := range pairs {
 		// 以等号分割键和值
-		kv := strings.Split(pair, "=")
+		kv := strings.SplitN(pair, "=", 2)
 		if len(kv) == 2 {
 			key := strings.TrimSpace(kv[0])
 			value := strings.TrimSpace(kv[1])
@@ -80,14 +97,21 @@ func LoadBedrockConfigWithEnv() *BedrockConfig {
 		AccessKey:                os.Getenv("AWS_BEDROCK_ACCESS_KEY"),
 		SecretKey:                os.Getenv("AWS_BEDROCK_SECRET_KEY"),
 		Region:                   os.Getenv("AWS_BEDROCK_REGION"),
+		SessionToken:             os.Getenv("AWS_BEDROCK_SESSION_TOKEN"),
 		RoleARN:                  os.Getenv("AWS_BEDROCK_ROLE_ARN"),
+		CredentialSource:         os.Getenv("AWS_BEDROCK_CREDENTIAL_SOURCE"),
+		Profile:                  os.Getenv("AWS_BEDROCK_PROFILE"),
+		WebIdentityRoleARN:       os.Getenv("AWS_BEDROCK_WEB_IDENTITY_ROLE_ARN"),
+		WebIdentityTokenFile:     os.Getenv("AWS_BEDROCK_WEB_IDENTITY_TOKEN_FILE"),
 		ModelMappings:            ParseMappingsFromStr(os.Getenv("AWS_BEDROCK_MODEL_MAPPINGS")),
+		ModelBackends:            ParseMappingsFromStr(os.Getenv("AWS_BEDROCK_MODEL_BACKENDS")),
 		AnthropicVersionMappings: ParseMappingsFromStr(os.Getenv("AWS_BEDROCK_ANTHROPIC_VERSION_MAPPINGS")),
 		AnthropicDefaultModel:    os.Getenv("AWS_BEDROCK_ANTHROPIC_DEFAULT_MODEL"),
 		AnthropicDefaultVersion:  os.Getenv("AWS_BEDROCK_ANTHROPIC_DEFAULT_VERSION"),
 		EnableComputerUse:        os.Getenv("AWS_BEDROCK_ENABLE_COMPUTER_USE") == "true",
 		EnableOutputReason:       os.Getenv("AWS_BEDROCK_ENABLE_OUTPUT_REASON") == "true",
 		ReasonBudgetTokens:       1024,
+		TokenizerBPEFile:         os.Getenv("TOKENIZER_BPE_FILE"),
 	}
 
 	budget := os.Getenv("AWS_BEDROCK_REASON_BUDGET_TOKENS")
@@ -97,12 +121,21 @@ func LoadBedrockConfigWithEnv() *BedrockConfig {
 		}
 	}
 
+	if timeout, err := strconv.Atoi(os.Getenv("AWS_BEDROCK_REQUEST_TIMEOUT")); err == nil {
+		config.RequestTimeout = timeout
+	}
+	if timeout, err := strconv.Atoi(os.Getenv("AWS_BEDROCK_STREAM_IDLE_TIMEOUT")); err == nil {
+		config.StreamIdleTimeout = timeout
+	}
+
 	return config
 }
 
 type BedrockClient struct {
-	config *BedrockConfig
-	client *bedrock.Client
+	config    *BedrockConfig
+	sdkConfig aws.Config
+	client    *bedrock.Client
+	tokenizer *Tokenizer
 }
 
 type ClaudeTextCompletionRequest struct {
@@ -174,21 +207,18 @@ type ClaudeMessageCompletionRequestMetadata struct {
 	UserId string `json:"user_id,omitempty"`
 }
 
-type ClaudeMessageCompletionRequestInputSchema struct {
-	Type       string                                                   `json:"type,omitempty"`
-	Properties map[string]*ClaudeMessageCompletionRequestPropertiesItem `json:"properties,omitempty"`
-	Required   []string                                                 `json:"required,omitempty"`
-}
-
-type ClaudeMessageCompletionRequestPropertiesItem struct {
+type ClaudeMessageCompletionRequestTools struct {
 	Type        string `json:"type,omitempty"`
+	Name        string `json:"name,omitempty"`
 	Description string `json:"description,omitempty"`
+	// 保留原始 JSON Schema，嵌套的 properties、items、enum 等不会丢失
+	InputSchema json.RawMessage `json:"input_schema,omitempty"`
 }
 
-type ClaudeMessageCompletionRequestTools struct {
-	Name        string                                     `json:"name,omitempty"`
-	Description string                                     `json:"description,omitempty"`
-	InputSchema *ClaudeMessageCompletionRequestInputSchema `json:"input_schema,omitempty"`
+type ClaudeMessageCompletionRequestToolChoice struct {
+	Type                   string `json:"type,omitempty"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
 }
 
 type ClaudeMessageCompletionRequest struct {
@@ -201,10 +231,11 @@ type ClaudeMessageCompletionRequest struct {
 	AnthropicVersion string   `json:"anthropic_version,omitempty"`
 	MaxToken         int      `json:"max_tokens,omitempty"`
 	// System           string                                   `json:"system,omitempty"`
-	System   []ClaudeMessageCompletionRequestContent  `json:"system,omitempty"`
-	Messages []*ClaudeMessageCompletionRequestMessage `json:"messages,omitempty"`
-	Metadata *ClaudeMessageCompletionRequestMetadata  `json:"-"`
-	Tools    []*ClaudeMessageCompletionRequestTools   `json:"tools,omitempty"`
+	System     []ClaudeMessageCompletionRequestContent   `json:"system,omitempty"`
+	Messages   []*ClaudeMessageCompletionRequestMessage  `json:"messages,omitempty"`
+	Metadata   *ClaudeMessageCompletionRequestMetadata   `json:"-"`
+	Tools      []*ClaudeMessageCompletionRequestTools    `json:"tools,omitempty"`
+	ToolChoice *ClaudeMessageCompletionRequestToolChoice `json:"tool_choice,omitempty"`
 }
 
 func (this *ClaudeMessageCompletionRequest) UnmarshalJSON(data []byte) error {
@@ -253,6 +284,8 @@ type ClaudeTextCompletionResponse struct {
 	Stop       string `json:"stop,omitempty"`
 	Id         string `json:"id,omitempty"`
 	Model      string `json:"model,omitempty"`
+	// Text Completions 的响应体不包含用量，由 Bedrock 响应头读取，不输出给客户端
+	Usage *ClaudeMessageUsage `json:"-"`
 }
 
 type ClaudeMessageCompletionResponse struct {
@@ -266,6 +299,10 @@ type ClaudeMessageCompletionResponse struct {
 	Usage   *ClaudeMessageUsage          `json:"usage,omitempty"`
 }
 
+type ClaudeCountTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
 type ISSEDecoder interface {
 	GetBytes() []byte
 	GetEvent() string
@@ -277,7 +314,15 @@ type ClaudeTextCompletionStreamEvent struct {
 	StopReason string `json:"stop_reason,omitempty"`
 	Model      string `json:"model,omitempty"`
 	Completion string `json:"completion,omitempty"`
-	Raw        []byte `json:"-"`
+	// Bedrock 在最后一个事件中返回本次调用的 token 数量
+	InvocationMetrics *BedrockInvocationMetrics `json:"amazon-bedrock-invocationMetrics,omitempty"`
+	Raw               []byte                    `json:"-"`
+}
+
+// BedrockInvocationMetrics InvokeModelWithResponseStream 最后一个事件中的调用统计
+type BedrockInvocationMetrics struct {
+	InputTokenCount  int `json:"inputTokenCount"`
+	OutputTokenCount int `json:"outputTokenCount"`
 }
 
 func (this *ClaudeTextCompletionStreamEvent) GetBytes() []byte {
@@ -312,17 +357,21 @@ type ClaudeMessageInfo struct {
 	Usage   *ClaudeMessageUsage `json:"usage,omitempty"`
 }
 type ClaudeMessageContentBlock struct {
-	Type  string      `json:"type,omitempty"`
-	Text  string      `json:"text,omitempty"`
-	Id    string      `json:"id,omitempty"`
-	Name  string      `json:"name,omitempty"`
-	Input interface{} `json:"input,omitempty"`
+	Type      string      `json:"type,omitempty"`
+	Text      string      `json:"text,omitempty"`
+	Thinking  string      `json:"thinking,omitempty"`
+	Signature string      `json:"signature,omitempty"`
+	Id        string      `json:"id,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Input     interface{} `json:"input,omitempty"`
 }
 type ClaudeMessageDelta struct {
 	ClaudeMessageStop
 
 	Type        string `json:"type,omitempty"`
 	Text        string `json:"text,omitempty"`
+	Thinking    string `json:"thinking,omitempty"`
+	Signature   string `json:"signature,omitempty"`
 	PartialJson string `json:"partial_json,omitempty"`
 }
 
@@ -335,9 +384,24 @@ type ClaudeMessageCompletionStreamEvent struct {
 	Index        int                        `json:"index,omitempty"`
 	ContentBlock *ClaudeMessageContentBlock `json:"content_block,omitempty"`
 	Delta        *ClaudeMessageDelta        `json:"delta,omitempty"`
+	Error        *APIError                  `json:"error,omitempty"`
 	Raw          []byte                     `json:"-"`
 }
 
+// NewStreamErrorEvent 构造 Anthropic 风格的流式错误事件（event: error）
+func NewStreamErrorEvent(err error) *ClaudeMessageCompletionStreamEvent {
+	proxyErr := AsProxyError(err)
+	event := &ClaudeMessageCompletionStreamEvent{
+		Type: "error",
+		Error: &APIError{
+			Type:    proxyErr.Type,
+			Message: proxyErr.Message,
+		},
+	}
+	event.Raw, _ = json.Marshal(event)
+	return event
+}
+
 func (this *ClaudeMessageCompletionStreamEvent) GetBytes() []byte {
 	return this.Raw
 }
@@ -423,7 +487,11 @@ func (this *MessageCompleteResponse) GetEvents() <-chan ISSEDecoder {
 	return this.Events
 }
 
+// NewSSERaw 生成 SSE 帧，事件名为空时只输出 data 行（OpenAI 的流式格式）
 func NewSSERaw(encoder ISSEDecoder) []byte {
+	if len(encoder.GetEvent()) == 0 {
+		return []byte(fmt.Sprintf("data: %s\n\n", string(encoder.GetBytes())))
+	}
 	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", encoder.GetEvent(), string(encoder.GetBytes())))
 }
 
@@ -465,70 +533,20 @@ func (l loggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 	return resp, nil
 }
 
+// NewBedrockClient 创建一个可在多个请求间共享的 BedrockClient。
+// bedrock.Client 本身是并发安全的，凭证由 BedrockConfig.LoadAWSConfig 按凭证来源加载，
+// 临时凭证（AssumeRole、WebIdentity 等）通过 CredentialsCache 在过期前自动刷新。
 func NewBedrockClient(config *BedrockConfig) *BedrockClient {
-	staticProvider := credentials.NewStaticCredentialsProvider(config.AccessKey, config.SecretKey, "")
-
-	opt := []func(*awsConfig.LoadOptions) error{
-		awsConfig.WithRegion(config.Region),
-		awsConfig.WithCredentialsProvider(staticProvider),
-	}
-
-	if config.DEBUG {
-		httpClient := &http.Client{
-			Transport: loggingRoundTripper{
-				wrapped: http.DefaultTransport,
-			},
-		}
-		opt = append(opt, awsConfig.WithHTTPClient(httpClient))
-	}
-
-	if config.RoleARN == "" {
-		bedrockClient := bedrock.New(bedrock.Options{
-			Region:      config.Region,
-			Credentials: aws.NewCredentialsCache(staticProvider),
-		})
-		return &BedrockClient{
-			config: config,
-			client: bedrockClient,
-		}
-	}
-
-	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), opt...)
-	if err != nil {
-		log.Logger.Fatalf("unable to load SDK config, %v", err)
-	}
-
-	// ===== assume role ======
-	stsSvc := sts.NewFromConfig(cfg)
-
-	// Assume role
-	input := &sts.AssumeRoleInput{
-		RoleArn:         aws.String(config.RoleARN),
-		RoleSessionName: aws.String("bedrockruntime-session"),
-	}
-	result, err := stsSvc.AssumeRole(context.TODO(), input)
-	if err != nil {
-		log.Logger.Fatalf("unable to load SDK config, %v", err)
-	}
-	// Use assumed role to create new credentials
-	assumedCreds := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
-		*result.Credentials.AccessKeyId,
-		*result.Credentials.SecretAccessKey,
-		*result.Credentials.SessionToken,
-	))
-
-	bedrockCfg, err := awsConfig.LoadDefaultConfig(
-		context.TODO(),
-		awsConfig.WithRegion(config.Region),
-		awsConfig.WithCredentialsProvider(assumedCreds),
-	)
+	cfg, err := config.LoadAWSConfig(context.TODO())
 	if err != nil {
 		log.Logger.Fatalf("unable to load SDK config, %v", err)
 	}
 
 	return &BedrockClient{
-		config: config,
-		client: bedrock.NewFromConfig(bedrockCfg),
+		config:    config,
+		sdkConfig: cfg,
+		client:    bedrock.NewFromConfig(cfg),
+		tokenizer: NewTokenizer(config.TokenizerBPEFile),
 	}
 }
 
@@ -609,24 +627,12 @@ func (this *BedrockClient) SignRequest(request *http.Request) (*http.Request, bo
 		}
 	}
 
-	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(),
-		awsConfig.WithRegion(this.config.Region),
-		awsConfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			this.config.AccessKey,
-			this.config.SecretKey,
-			"",
-		)),
-	)
-	if err != nil {
-		return nil, false, err
-	}
-
 	bedrockRuntimeEndPoint := fmt.Sprintf(`https://bedrock-runtime.%s.amazonaws.com/model/%s/invoke`, this.config.Region, url.QueryEscape(Model))
 	if isStream {
 		bedrockRuntimeEndPoint = fmt.Sprintf(`https://bedrock-runtime.%s.amazonaws.com/model/%s/invoke-with-response-stream`, this.config.Region, url.QueryEscape(Model))
 	}
 
-	preSignReq, err := http.NewRequest("POST", bedrockRuntimeEndPoint, cloneReq.Body)
+	preSignReq, err := http.NewRequestWithContext(request.Context(), "POST", bedrockRuntimeEndPoint, cloneReq.Body)
 	if err != nil {
 		log.Logger.Error(err)
 		return nil, false, err
@@ -637,7 +643,7 @@ func (this *BedrockClient) SignRequest(request *http.Request) (*http.Request, bo
 	signer := v4.NewSigner()
 
 	// 获取凭证
-	credentialList, err := cfg.Credentials.Retrieve(context.TODO())
+	credentialList, err := this.sdkConfig.Credentials.Retrieve(request.Context())
 	if err != nil {
 		log.Logger.Error(err)
 		return nil, false, err
@@ -646,7 +652,7 @@ func (this *BedrockClient) SignRequest(request *http.Request) (*http.Request, bo
 	hash := sha256.Sum256(bodyBuff.Bytes())
 	payloadHash := hex.EncodeToString(hash[:])
 	// 签名请求
-	err = signer.SignHTTP(context.TODO(), credentialList, preSignReq, payloadHash, "bedrock", cfg.Region, time.Now(), func(options *v4.SignerOptions) {
+	err = signer.SignHTTP(request.Context(), credentialList, preSignReq, payloadHash, "bedrock", this.sdkConfig.Region, time.Now(), func(options *v4.SignerOptions) {
 		if this.config.DEBUG {
 			options.LogSigning = true
 		}
@@ -829,8 +835,9 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (this *BedrockClient) CompleteText(req *ClaudeTextCompletionRequest) (IStreamableResponse, error) {
-	modelId := req.Model
+// resolveModelId 将客户端请求的模型名映射为 Bedrock 的模型 ID
+func (this *BedrockClient) resolveModelId(model string) string {
+	modelId := model
 	mappedModel, exist := this.config.ModelMappings[modelId]
 	if exist {
 		modelId = mappedModel
@@ -838,65 +845,201 @@ func (this *BedrockClient) CompleteText(req *ClaudeTextCompletionRequest) (IStre
 	if len(modelId) == 0 {
 		modelId = this.config.AnthropicDefaultModel
 	}
+	return modelId
+}
+
+// resolveAnthropicVersion 将 anthropic-version 映射为 Bedrock 使用的版本
+func (this *BedrockClient) resolveAnthropicVersion(version string) string {
+	apiVersion, exist := this.config.AnthropicVersionMappings[version]
+	if exist {
+		version = apiVersion
+	}
+	if len(version) == 0 {
+		version = this.config.AnthropicDefaultVersion
+	}
+	return version
+}
+
+// withRequestTimeout 基于客户端请求的 ctx 派生出带超时的 ctx，客户端断开时同样会被取消
+func (this *BedrockClient) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := this.config.GetRequestTimeout(); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// streamReader Bedrock 事件流（InvokeModelWithResponseStream / ConverseStream）的公共接口
+type streamReader[T any] interface {
+	Events() <-chan T
+	Err() error
+	Close() error
+}
+
+// streamEvents 将 InvokeModelWithResponseStream 的事件流转换为 ISSEDecoder 队列
+func (this *BedrockClient) streamEvents(ctx context.Context, cancel context.CancelFunc,
+	reader *bedrock.InvokeModelWithResponseStreamEventStream, decode func(payload []byte) (ISSEDecoder, error)) <-chan ISSEDecoder {
+	return pipeStream[types.ResponseStream](ctx, cancel, this.config.GetStreamIdleTimeout(), reader,
+		func(event types.ResponseStream) ([]ISSEDecoder, error) {
+			switch v := event.(type) {
+			case *types.ResponseStreamMemberChunk:
+
+				//Log.Info("payload", string(v.Value.Bytes))
+
+				resp, err := decode(v.Value.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				return []ISSEDecoder{resp}, nil
+
+			case *types.UnknownUnionMember:
+				return nil, fmt.Errorf("unknown tag: %s", v.Tag)
+			}
+			return nil, fmt.Errorf("union is nil or unknown type")
+		})
+}
+
+// pipeStream 将 Bedrock 事件流转换为 ISSEDecoder 队列，decode 可以将一个 Bedrock 事件转换为多个事件。
+// ctx 结束（客户端断开或请求超时）或事件间隔超过 idleTimeout 时主动关闭 Bedrock 事件流，
+// 避免客户端离开后继续消耗 token。
+// Bedrock 流中途出错（异常事件、超时）时输出 event: error，客户端已断开则不再输出。
+func pipeStream[T any](ctx context.Context, cancel context.CancelFunc, idleTimeout time.Duration,
+	reader streamReader[T], decode func(event T) ([]ISSEDecoder, error)) <-chan ISSEDecoder {
+	eventQueue := make(chan ISSEDecoder, 10)
+
+	go func() {
+		defer cancel()
+		defer reader.Close()
+		defer close(eventQueue)
+
+		var idle <-chan time.Time
+		var idleTimer *time.Timer
+		if idleTimeout > 0 {
+			idleTimer = time.NewTimer(idleTimeout)
+			defer idleTimer.Stop()
+			idle = idleTimer.C
+		}
+
+		abort := func(err error) {
+			log.Logger.Warningf("bedrock stream aborted: %v", err)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			eventQueue <- NewStreamErrorEvent(err)
+		}
+
+		events := reader.Events()
+		for {
+			select {
+			case <-ctx.Done():
+				abort(ctx.Err())
+				return
+			case <-idle:
+				abort(NewProxyError(StatusOverloaded, ErrorTypeOverloaded,
+					fmt.Sprintf("no event received from bedrock for %s", idleTimeout)))
+				return
+			case event, ok := <-events:
+				if !ok {
+					if err := reader.Err(); err != nil {
+						log.Logger.Error(err)
+						if ctx.Err() != nil {
+							err = ctx.Err()
+						}
+						abort(err)
+					}
+					return
+				}
+
+				if idleTimer != nil {
+					if !idleTimer.Stop() {
+						select {
+						case <-idleTimer.C:
+						default:
+						}
+					}
+					idleTimer.Reset(idleTimeout)
+				}
+
+				resps, err := decode(event)
+				if err != nil {
+					log.Logger.Error(err)
+					continue
+				}
+
+				for _, resp := range resps {
+					select {
+					case eventQueue <- resp:
+					case <-ctx.Done():
+						abort(ctx.Err())
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return eventQueue
+}
+
+// invocationUsage 读取 InvokeModel 响应头中的 token 数量，没有时返回 nil
+func invocationUsage(metadata middleware.Metadata) *ClaudeMessageUsage {
+	response, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response)
+	if !ok {
+		return nil
+	}
+	inputTokens, err := strconv.Atoi(response.Header.Get("X-Amzn-Bedrock-Input-Token-Count"))
+	if err != nil {
+		return nil
+	}
+	outputTokens, err := strconv.Atoi(response.Header.Get("X-Amzn-Bedrock-Output-Token-Count"))
+	if err != nil {
+		return nil
+	}
+	return &ClaudeMessageUsage{InputTokens: inputTokens, OutputTokens: outputTokens}
+}
+
+func (this *BedrockClient) CompleteText(ctx context.Context, req *ClaudeTextCompletionRequest) (IStreamableResponse, error) {
+	modelId := this.resolveModelId(req.Model)
 
 	if !strings.HasSuffix(req.Prompt, "Assistant:") {
 		req.Prompt = fmt.Sprintf("\n\nHuman: %s\n\nAssistant:", req.Prompt)
 	}
 	body, err := json.Marshal(req)
 	if err != nil {
-		log.Logger.Errorf("Couldn't marshal the request: ", err)
+		log.Logger.Errorf("Couldn't marshal the request: %v", err)
 		return nil, err
 	}
 
+	ctx, cancel := this.withRequestTimeout(ctx)
+
 	if req.Stream {
-		output, err := this.client.InvokeModelWithResponseStream(context.Background(), &bedrock.InvokeModelWithResponseStreamInput{
+		output, err := this.client.InvokeModelWithResponseStream(ctx, &bedrock.InvokeModelWithResponseStreamInput{
 			Body:        body,
 			ModelId:     aws.String(modelId),
 			ContentType: aws.String("application/json"),
 		})
 		if err != nil {
+			cancel()
 			log.Logger.Error(err)
 			return nil, err
 		}
 
 		//Log.Debugf("Request: %+v", output)
 
-		reader := output.GetStream()
-		eventQueue := make(chan ISSEDecoder, 10)
-
-		go func() {
-			defer reader.Close()
-			defer close(eventQueue)
-
-			for event := range reader.Events() {
-				switch v := event.(type) {
-				case *types.ResponseStreamMemberChunk:
-
-					//Log.Info("payload", string(v.Value.Bytes))
-
-					var resp ClaudeTextCompletionStreamEvent
-					err := json.NewDecoder(bytes.NewReader(v.Value.Bytes)).Decode(&resp)
-					if err != nil {
-						log.Logger.Error(err)
-						continue
-					}
-					resp.Raw = v.Value.Bytes
-					eventQueue <- &resp
-
-				case *types.UnknownUnionMember:
-					log.Logger.Errorf("unknown tag:", v.Tag)
-					continue
-				default:
-					log.Logger.Errorf("union is nil or unknown type")
-					continue
-				}
+		eventQueue := this.streamEvents(ctx, cancel, output.GetStream(), func(payload []byte) (ISSEDecoder, error) {
+			var resp ClaudeTextCompletionStreamEvent
+			err := json.NewDecoder(bytes.NewReader(payload)).Decode(&resp)
+			if err != nil {
+				return nil, err
 			}
-		}()
+			resp.Raw = payload
+			return &resp, nil
+		})
 
 		return NewStreamCompleteTextResponse(eventQueue), nil
 	}
+	defer cancel()
 
-	output, err := this.client.InvokeModel(context.Background(), &bedrock.InvokeModelInput{
+	output, err := this.client.InvokeModel(ctx, &bedrock.InvokeModelInput{
 		Body:        body,
 		ModelId:     aws.String(modelId),
 		ContentType: aws.String("application/json"),
@@ -914,6 +1057,13 @@ func (this *BedrockClient) CompleteText(req *ClaudeTextCompletionRequest) (IStre
 			return nil, err
 		}
 		//Log.Debug(resp)
+		resp.Usage = invocationUsage(output.ResultMetadata)
+		if resp.Usage == nil {
+			resp.Usage = &ClaudeMessageUsage{
+				InputTokens:  EstimateTokens(req.Prompt),
+				OutputTokens: EstimateTokens(resp.Completion),
+			}
+		}
 
 		return NewCompleteTextResponse(&resp), nil
 	}
@@ -921,79 +1071,51 @@ func (this *BedrockClient) CompleteText(req *ClaudeTextCompletionRequest) (IStre
 	return nil, nil
 }
 
-func (this *BedrockClient) MessageCompletion(req *ClaudeMessageCompletionRequest) (IStreamableResponse, error) {
-	modelId := req.Model
-	mappedModel, exist := this.config.ModelMappings[modelId]
-	if exist {
-		modelId = mappedModel
-	}
-	if len(modelId) == 0 {
-		modelId = this.config.AnthropicDefaultModel
-	}
-	apiVersion, exist := this.config.AnthropicVersionMappings[req.AnthropicVersion]
-	if exist {
-		req.AnthropicVersion = apiVersion
-	}
-	if len(req.AnthropicVersion) == 0 {
-		req.AnthropicVersion = this.config.AnthropicDefaultVersion
+func (this *BedrockClient) MessageCompletion(ctx context.Context, req *ClaudeMessageCompletionRequest) (IStreamableResponse, error) {
+	modelId := this.resolveModelId(req.Model)
+	if this.config.GetModelBackend(req.Model, modelId) == ModelBackendConverse {
+		return this.ConverseCompletion(ctx, modelId, req)
 	}
+	req.AnthropicVersion = this.resolveAnthropicVersion(req.AnthropicVersion)
 
 	body, err := json.Marshal(req)
 	if err != nil {
-		log.Logger.Errorf("Couldn't marshal the request: ", err)
+		log.Logger.Errorf("Couldn't marshal the request: %v", err)
 		return nil, err
 	}
 
 	// Log.Debugf("Request: %s", string(body))
 	log.Logger.Debugf("Request Model ID: %s", modelId)
 
+	ctx, cancel := this.withRequestTimeout(ctx)
+
 	if req.Stream {
-		output, err := this.client.InvokeModelWithResponseStream(context.Background(), &bedrock.InvokeModelWithResponseStreamInput{
+		output, err := this.client.InvokeModelWithResponseStream(ctx, &bedrock.InvokeModelWithResponseStreamInput{
 			Body:        body,
 			ModelId:     aws.String(modelId),
 			ContentType: aws.String("application/json"),
 		})
 		if err != nil {
+			cancel()
 			log.Logger.Error(err)
 			return nil, err
 		}
 
-		reader := output.GetStream()
-		eventQueue := make(chan ISSEDecoder, 10)
-
-		go func() {
-			defer reader.Close()
-			defer close(eventQueue)
-
-			for event := range reader.Events() {
-				switch v := event.(type) {
-				case *types.ResponseStreamMemberChunk:
-
-					//Log.Info("payload", string(v.Value.Bytes))
-
-					var resp ClaudeMessageCompletionStreamEvent
-					err := json.NewDecoder(bytes.NewReader(v.Value.Bytes)).Decode(&resp)
-					if err != nil {
-						log.Logger.Error(err)
-						continue
-					}
-					resp.Raw = v.Value.Bytes
-					eventQueue <- &resp
-
-				case *types.UnknownUnionMember:
-					log.Logger.Errorf("unknown tag:", v.Tag)
-					continue
-				default:
-					log.Logger.Errorf("union is nil or unknown type")
-					continue
-				}
+		eventQueue := this.streamEvents(ctx, cancel, output.GetStream(), func(payload []byte) (ISSEDecoder, error) {
+			var resp ClaudeMessageCompletionStreamEvent
+			err := json.NewDecoder(bytes.NewReader(payload)).Decode(&resp)
+			if err != nil {
+				return nil, err
 			}
-		}()
+			resp.Raw = payload
+			return &resp, nil
+		})
 
 		return NewStreamMessageCompleteResponse(eventQueue), nil
 	}
+	defer cancel()
 
-	output, err := this.client.InvokeModel(context.Background(), &bedrock.InvokeModelInput{
+	output, err := this.client.InvokeModel(ctx, &bedrock.InvokeModelInput{
 		Body:        body,
 		ModelId:     aws.String(modelId),
 		ContentType: aws.String("application/json"),
@@ -1017,3 +1139,72 @@ func (this *BedrockClient) MessageCompletion(req *ClaudeMessageCompletionRequest
 
 	return nil, nil
 }
+
+// CountTokens 统计 Messages 请求的输入 token 数量。
+// 优先使用 Bedrock 的 CountTokens API，模型不支持时回退到本地估算。
+func (this *BedrockClient) CountTokens(ctx context.Context, req *ClaudeMessageCompletionRequest) (int, error) {
+	modelId := this.resolveModelId(req.Model)
+
+	countReq := *req
+	countReq.Stream = false
+	countReq.AnthropicVersion = this.resolveAnthropicVersion(req.AnthropicVersion)
+	if countReq.MaxToken <= 0 {
+		// InvokeModel 的请求体要求 max_tokens，计数时不影响结果
+		countReq.MaxToken = 1
+	}
+
+	body, err := json.Marshal(&countReq)
+	if err != nil {
+		log.Logger.Errorf("Couldn't marshal the request: %v", err)
+		return 0, err
+	}
+
+	ctx, cancel := this.withRequestTimeout(ctx)
+	defer cancel()
+
+	output, err := this.client.CountTokens(ctx, &bedrock.CountTokensInput{
+		ModelId: aws.String(modelId),
+		Input: &types.CountTokensInputMemberInvokeModel{
+			Value: types.InvokeModelTokensRequest{
+				Body: body,
+			},
+		},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		// 只有模型不支持 CountTokens 时回退到本地分词器，其他错误与 MessageCompletion 一样返回给客户端
+		if !isCountTokensUnsupported(err) {
+			log.Logger.Errorf("CountTokens failed for model %s: %v", modelId, err)
+			return 0, AsProxyError(err)
+		}
+		log.Logger.Warningf("CountTokens unsupported for model %s, falling back to local tokenizer: %v", modelId, err)
+		return this.countTokensLocally(req)
+	}
+	if output.InputTokens == nil {
+		return this.countTokensLocally(req)
+	}
+	return int(*output.InputTokens), nil
+}
+
+// countTokensLocally 使用本地分词器统计输入 token，词表不可用时返回错误而不是给出粗略估算
+func (this *BedrockClient) countTokensLocally(req *ClaudeMessageCompletionRequest) (int, error) {
+	tokens, err := this.tokenizer.CountMessageTokens(req)
+	if err != nil {
+		log.Logger.Errorf("local tokenizer failed: %v", err)
+		return 0, NewProxyError(http.StatusInternalServerError, ErrorTypeAPI, "local tokenizer is unavailable")
+	}
+	return tokens, nil
+}
+
+// isCountTokensUnsupported 判断是否为模型不支持 CountTokens 的 ValidationException，
+// 如 "The provided model doesn't support counting tokens."
+func isCountTokensUnsupported(err error) bool {
+	var validation *types.ValidationException
+	if !errors.As(err, &validation) {
+		return false
+	}
+	message := strings.ToLower(validation.ErrorMessage())
+	return strings.Contains(message, "support") && strings.Contains(message, "count")
+}
@@ -4,6 +4,7 @@ import (
 	"bedrock-claude-proxy/tests"
 	_ "bedrock-claude-proxy/tests"
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http/httptest"
@@ -21,7 +22,7 @@ func TestBedrockClient_CompleteTextWithStream(t *testing.T) {
 
 	prompt := "創作一首7言律詩"
 
-	resp, err := client.CompleteText(&ClaudeTextCompletionRequest{
+	resp, err := client.CompleteText(context.Background(), &ClaudeTextCompletionRequest{
 		Prompt:            prompt,
 		Temperature:       0.5,
 		MaxTokensToSample: 2048,
@@ -56,7 +57,7 @@ func TestBedrockClient_CompleteTextWithoutStream(t *testing.T) {
 
 	prompt := "創作一首7言律詩"
 
-	resp, err := client.CompleteText(&ClaudeTextCompletionRequest{
+	resp, err := client.CompleteText(context.Background(), &ClaudeTextCompletionRequest{
 		Prompt:            prompt,
 		Temperature:       0.5,
 		MaxTokensToSample: 2048,
@@ -90,18 +91,14 @@ func TestBedrockClient_MessageCompletionWithoutStream(t *testing.T) {
 
 	prompt := "創作一首7言律詩"
 
-	bin, err := json.Marshal([]*ClaudeMessageCompletionRequestContent{
-		&ClaudeMessageCompletionRequestContent{
+	content := []ClaudeMessageCompletionRequestContent{
+		{
 			Type: "text",
 			Text: prompt,
 		},
-	})
-	if err != nil {
-		t.Fatal(err)
-		return
 	}
 
-	resp, err := client.MessageCompletion(&ClaudeMessageCompletionRequest{
+	resp, err := client.MessageCompletion(context.Background(), &ClaudeMessageCompletionRequest{
 		Temperature: 0.5,
 		TopP:        1,
 		TopK:        5,
@@ -113,7 +110,7 @@ func TestBedrockClient_MessageCompletionWithoutStream(t *testing.T) {
 		Messages: []*ClaudeMessageCompletionRequestMessage{
 			&ClaudeMessageCompletionRequestMessage{
 				Role:    "user",
-				Content: bin,
+				Content: content,
 			},
 		},
 	})
@@ -140,28 +137,29 @@ func TestBedrockClient_MessageCompletionWithStream(t *testing.T) {
 
 	prompt := "創作一首7言律詩"
 
-	bin, err := json.Marshal([]*ClaudeMessageCompletionRequestContent{
-		&ClaudeMessageCompletionRequestContent{
+	content := []ClaudeMessageCompletionRequestContent{
+		{
 			Type: "text",
 			Text: prompt,
 		},
-	})
-	if err != nil {
-		t.Fatal(err)
-		return
 	}
 
-	resp, err := client.MessageCompletion(&ClaudeMessageCompletionRequest{
-		Temperature:      0.5,
-		Stream:           true,
-		Model:            "anthropic.claude-v2:1",
-		MaxToken:         2048,
-		System:           "You are a helpful assistant.",
+	resp, err := client.MessageCompletion(context.Background(), &ClaudeMessageCompletionRequest{
+		Temperature: 0.5,
+		Stream:      true,
+		Model:       "anthropic.claude-v2:1",
+		MaxToken:    2048,
+		System: []ClaudeMessageCompletionRequestContent{
+			{
+				Type: "text",
+				Text: "You are a helpful assistant.",
+			},
+		},
 		AnthropicVersion: "bedrock-2023-05-31",
 		Messages: []*ClaudeMessageCompletionRequestMessage{
 			&ClaudeMessageCompletionRequestMessage{
 				Role:    "user",
-				Content: bin,
+				Content: content,
 			},
 		},
 	})
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bedrock-claude-proxy/models"
+
+	"gorm.io/gorm"
+)
+
+// 预算状态
+const (
+	BudgetStatusOK                = "ok"
+	BudgetStatusSoftLimitExceeded = "soft_limit_exceeded"
+	BudgetStatusHardLimitExceeded = "hard_limit_exceeded"
+)
+
+// 跨区域推理配置文件的模型 ID 前缀，如 us.anthropic.claude-3-5-haiku-20241022-v1:0
+var crossRegionPrefixes = []string{"us.", "eu.", "apac.", "us-gov.", "global."}
+
+// LookupModelMeta 查找模型的计费倍率。客户端使用的模型名没有价格时，按映射后的 Bedrock 模型 ID 查找，
+// 并忽略推理配置文件 ARN 和跨区域前缀
+func (this *BedrockClient) LookupModelMeta(model string) (ModelMeta, bool) {
+	if meta, ok := ModelMetaMap[model]; ok {
+		return meta, true
+	}
+	modelId := this.resolveModelId(model)
+	if meta, ok := ModelMetaMap[modelId]; ok {
+		return meta, true
+	}
+	if i := strings.LastIndex(modelId, "/"); i >= 0 {
+		modelId = modelId[i+1:]
+	}
+	for _, prefix := range crossRegionPrefixes {
+		if strings.HasPrefix(modelId, prefix) {
+			modelId = strings.TrimPrefix(modelId, prefix)
+			break
+		}
+	}
+	meta, ok := ModelMetaMap[modelId]
+	return meta, ok
+}
+
+// NewUnpricedModelError 设置了预算的 API Key 不能使用没有价格的模型，否则消耗无法计入预算
+func NewUnpricedModelError(model string) *ProxyError {
+	return NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest,
+		fmt.Sprintf("model %s has no price configured and cannot be used with a budgeted api key", model))
+}
+
+// 已消耗额度的缓存时间，过期后重新从 usage 表汇总，避免每个请求都执行 SUM
+const budgetSpendTTL = 30 * time.Second
+
+type budgetSpend struct {
+	periodStart time.Time
+	spent       int64
+	loadedAt    time.Time
+}
+
+// BudgetTracker 缓存每个 API Key 在当前预算周期内已消耗的额度。
+// 记录用量时同步累加缓存，缓存过期后以数据库汇总为准，多实例部署时最多有 budgetSpendTTL 的延迟。
+type BudgetTracker struct {
+	mutex  sync.Mutex
+	spends map[uint]*budgetSpend
+	now    func() time.Time
+	// 从数据库汇总已消耗额度，测试时可以替换
+	sumQuota func(apiKeyName string, since time.Time) (int64, error)
+}
+
+func NewBudgetTracker(db *gorm.DB) *BudgetTracker {
+	return &BudgetTracker{
+		spends: make(map[uint]*budgetSpend),
+		now:    time.Now,
+		sumQuota: func(apiKeyName string, since time.Time) (int64, error) {
+			return models.SumQuotaSince(db, apiKeyName, since)
+		},
+	}
+}
+
+// Spent 返回 API Key 在当前预算周期内已消耗的额度
+func (this *BudgetTracker) Spent(apiKey *models.APIKey) (int64, error) {
+	now := this.now()
+	periodStart := models.BudgetPeriodStart(apiKey.BudgetPeriod, now)
+
+	this.mutex.Lock()
+	spend, ok := this.spends[apiKey.ID]
+	if ok && spend.periodStart.Equal(periodStart) && now.Sub(spend.loadedAt) < budgetSpendTTL {
+		spent := spend.spent
+		this.mutex.Unlock()
+		return spent, nil
+	}
+	this.mutex.Unlock()
+
+	spent, err := this.sumQuota(apiKey.Name, periodStart)
+	if err != nil {
+		return 0, err
+	}
+
+	this.mutex.Lock()
+	this.spends[apiKey.ID] = &budgetSpend{
+		periodStart: periodStart,
+		spent:       spent,
+		loadedAt:    now,
+	}
+	this.mutex.Unlock()
+	return spent, nil
+}
+
+// Add 在记录用量后累加缓存中的已消耗额度
+func (this *BudgetTracker) Add(apiKey *models.APIKey, quota int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if spend, ok := this.spends[apiKey.ID]; ok {
+		spend.spent += int64(quota)
+	}
+}
+
+// Check 返回 API Key 的预算状态，未配置预算时不查询已消耗额度
+func (this *BudgetTracker) Check(apiKey *models.APIKey) (string, error) {
+	if apiKey.BudgetHardLimit <= 0 && apiKey.BudgetSoftLimit <= 0 {
+		return BudgetStatusOK, nil
+	}
+	spent, err := this.Spent(apiKey)
+	if err != nil {
+		return "", err
+	}
+	return BudgetStatus(apiKey, spent), nil
+}
+
+// BudgetStatus 根据已消耗额度计算预算状态
+func BudgetStatus(apiKey *models.APIKey, spent int64) string {
+	if apiKey.BudgetHardLimit > 0 && spent >= apiKey.BudgetHardLimit {
+		return BudgetStatusHardLimitExceeded
+	}
+	if apiKey.BudgetSoftLimit > 0 && spent >= apiKey.BudgetSoftLimit {
+		return BudgetStatusSoftLimitExceeded
+	}
+	return BudgetStatusOK
+}
+
+// NewBudgetExceededError 超出硬性预算时返回的错误
+func NewBudgetExceededError() *ProxyError {
+	return NewProxyError(http.StatusForbidden, ErrorTypePermission, "api key has exceeded its budget")
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bedrock-claude-proxy/api"
+	"bedrock-claude-proxy/models"
+)
+
+func TestBudgetTracker_Check(t *testing.T) {
+	now := time.Date(2025, 3, 15, 12, 0, 0, 0, time.UTC)
+	queries := 0
+	tracker := NewBudgetTracker(nil)
+	tracker.now = func() time.Time { return now }
+	tracker.sumQuota = func(apiKeyName string, since time.Time) (int64, error) {
+		queries++
+		if !since.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("unexpected period start: %s", since)
+		}
+		return 800, nil
+	}
+
+	apiKey := &models.APIKey{BudgetSoftLimit: 800, BudgetHardLimit: 1000, BudgetPeriod: models.BudgetPeriodMonthly}
+	apiKey.ID = 1
+
+	if status, err := tracker.Check(apiKey); err != nil || status != BudgetStatusSoftLimitExceeded {
+		t.Fatalf("unexpected status: %s %v", status, err)
+	}
+
+	// 记录用量后使用缓存累加，不再查询数据库
+	tracker.Add(apiKey, 200)
+	if status, _ := tracker.Check(apiKey); status != BudgetStatusHardLimitExceeded {
+		t.Fatalf("unexpected status: %s", status)
+	}
+	if queries != 1 {
+		t.Fatalf("unexpected queries: %d", queries)
+	}
+
+	// 缓存过期后重新汇总
+	now = now.Add(budgetSpendTTL)
+	if status, _ := tracker.Check(apiKey); status != BudgetStatusSoftLimitExceeded || queries != 2 {
+		t.Fatalf("unexpected status after ttl: %s, queries %d", status, queries)
+	}
+
+	// 未配置预算时不查询
+	unlimited := &models.APIKey{}
+	unlimited.ID = 2
+	if status, _ := tracker.Check(unlimited); status != BudgetStatusOK || queries != 2 {
+		t.Fatalf("unexpected status without budget: %s, queries %d", status, queries)
+	}
+	t.Log("PASS")
+}
+
+func TestBedrockClient_LookupModelMeta(t *testing.T) {
+	client := &BedrockClient{config: &BedrockConfig{
+		ModelMappings: map[string]string{
+			"claude-3-haiku-20240307":    "anthropic.claude-3-haiku-20240307-v1:0",
+			"claude-3-5-haiku-latest":    "us.anthropic.claude-3-5-haiku-20241022-v1:0",
+			"claude-3-sonnet-20240229":   "arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-3-sonnet-20240229-v1:0",
+			"claude-unpriced-2099-01-01": "anthropic.claude-unpriced-v1:0",
+		},
+	}}
+
+	cases := map[string]float64{
+		"anthropic.claude-3-opus-20240229-v1:0": 7.5,
+		// 客户端使用的模型名没有价格时按映射后的模型 ID 计费
+		"claude-3-haiku-20240307":  0.125,
+		"claude-3-5-haiku-latest":  0.5,
+		"claude-3-sonnet-20240229": 1.5,
+	}
+	for model, ratio := range cases {
+		meta, ok := client.LookupModelMeta(model)
+		if !ok || meta.ModelRatio != ratio {
+			t.Fatalf("%s: got %+v, %v", model, meta, ok)
+		}
+	}
+	if _, ok := client.LookupModelMeta("claude-unpriced-2099-01-01"); ok {
+		t.Fatal("unpriced model should not have a price")
+	}
+
+	// 设置了预算的 API Key 不能使用没有价格的模型
+	service := &HTTPService{bedrock: client}
+	for _, apiKey := range []*models.APIKey{{Name: "free"}, {Name: "budgeted", BudgetHardLimit: 1000}} {
+		request := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		request = request.WithContext(api.SetAPIKey(request.Context(), apiKey))
+		err := service.authorizeModel(request, "claude-unpriced-2099-01-01")
+		if (err != nil) != (apiKey.BudgetHardLimit > 0) {
+			t.Fatalf("%s: unexpected result %v", apiKey.Name, err)
+		}
+		if err := service.authorizeModel(request, "claude-3-haiku-20240307"); err != nil {
+			t.Fatalf("%s: priced model should be allowed: %v", apiKey.Name, err)
+		}
+	}
+	t.Log("PASS")
+}
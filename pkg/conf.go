@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"os"
+	"strconv"
 )
 
 type Config struct {
@@ -28,6 +29,42 @@ func (this *Config) MarginWithENV() {
 	if len(this.APIKey) <= 0 {
 		this.APIKey = os.Getenv("API_KEY")
 	}
+	if this.APIKeyCacheTTL <= 0 {
+		this.APIKeyCacheTTL, _ = strconv.Atoi(os.Getenv("API_KEY_CACHE_TTL"))
+	}
+	if this.APIKeyInvalidationInterval <= 0 {
+		this.APIKeyInvalidationInterval, _ = strconv.Atoi(os.Getenv("API_KEY_INVALIDATION_INTERVAL"))
+	}
+	if !this.AllowQueryAPIKey {
+		this.AllowQueryAPIKey = os.Getenv("ALLOW_QUERY_API_KEY") == "true"
+	}
+	if len(this.AdminJWTKeys) <= 0 {
+		this.AdminJWTKeys = ParseMappingsFromStr(os.Getenv("ADMIN_JWT_KEYS"))
+		if secret := os.Getenv("ADMIN_JWT_SECRET"); len(this.AdminJWTKeys) <= 0 && len(secret) > 0 {
+			this.AdminJWTKeys = map[string]string{"default": secret}
+		}
+	}
+	if len(this.AdminJWTKid) <= 0 {
+		this.AdminJWTKid = os.Getenv("ADMIN_JWT_KID")
+	}
+	if this.AdminAccessTokenTTL <= 0 {
+		this.AdminAccessTokenTTL, _ = strconv.Atoi(os.Getenv("ADMIN_ACCESS_TOKEN_TTL"))
+	}
+	if this.AdminRefreshTokenTTL <= 0 {
+		this.AdminRefreshTokenTTL, _ = strconv.Atoi(os.Getenv("ADMIN_REFRESH_TOKEN_TTL"))
+	}
+	if this.AdminLoginMaxFailures <= 0 {
+		this.AdminLoginMaxFailures, _ = strconv.Atoi(os.Getenv("ADMIN_LOGIN_MAX_FAILURES"))
+	}
+	if this.AdminLoginMaxIPFailures <= 0 {
+		this.AdminLoginMaxIPFailures, _ = strconv.Atoi(os.Getenv("ADMIN_LOGIN_MAX_IP_FAILURES"))
+	}
+	if this.AdminLoginLockout <= 0 {
+		this.AdminLoginLockout, _ = strconv.Atoi(os.Getenv("ADMIN_LOGIN_LOCKOUT"))
+	}
+	if len(this.AdminTrustedProxies) <= 0 {
+		this.AdminTrustedProxies = os.Getenv("ADMIN_TRUSTED_PROXIES")
+	}
 	if this.BedrockConfig == nil {
 		this.BedrockConfig = LoadBedrockConfigWithEnv()
 	}
@@ -0,0 +1,478 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "bedrock-claude-proxy/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsMiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	bedrock "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// 模型的调用方式
+const (
+	// 使用 InvokeModel 发送 Anthropic 格式的请求体，仅适用于 Anthropic 模型
+	ModelBackendInvoke = "invoke"
+	// 使用 Converse API，适用于 Llama、DeepSeek、Mistral 等非 Anthropic 模型
+	ModelBackendConverse = "converse"
+)
+
+// GetModelBackend 返回模型使用的调用方式。
+// 先按客户端请求的模型名、再按映射后的 Bedrock 模型 ID 查找 ModelBackends，
+// 未配置时 Anthropic 模型使用 InvokeModel，其余模型使用 Converse。
+func (this *BedrockConfig) GetModelBackend(model string, modelId string) string {
+	if backend, ok := this.ModelBackends[model]; ok && len(backend) > 0 {
+		return backend
+	}
+	if backend, ok := this.ModelBackends[modelId]; ok && len(backend) > 0 {
+		return backend
+	}
+	if strings.Contains(modelId, "anthropic.") {
+		return ModelBackendInvoke
+	}
+	return ModelBackendConverse
+}
+
+// converseRequest Converse 与 ConverseStream 共用的请求参数
+type converseRequest struct {
+	Messages        []types.Message
+	System          []types.SystemContentBlock
+	InferenceConfig *types.InferenceConfiguration
+	ToolConfig      *types.ToolConfiguration
+}
+
+// newConverseRequest 将 Claude Messages 请求转换为 Converse 请求
+func newConverseRequest(req *ClaudeMessageCompletionRequest) (*converseRequest, error) {
+	converseReq := &converseRequest{
+		InferenceConfig: &types.InferenceConfiguration{
+			StopSequences: req.StopSequences,
+		},
+	}
+	if req.MaxToken > 0 {
+		converseReq.InferenceConfig.MaxTokens = aws.Int32(int32(req.MaxToken))
+	}
+	if req.Temperature > 0 {
+		converseReq.InferenceConfig.Temperature = aws.Float32(float32(req.Temperature))
+	}
+	if req.TopP > 0 {
+		converseReq.InferenceConfig.TopP = aws.Float32(float32(req.TopP))
+	}
+
+	for _, content := range req.System {
+		if len(content.Text) > 0 {
+			converseReq.System = append(converseReq.System, &types.SystemContentBlockMemberText{Value: content.Text})
+		}
+	}
+
+	for _, msg := range req.Messages {
+		if msg == nil {
+			continue
+		}
+		message := types.Message{Role: types.ConversationRole(msg.Role)}
+		if len(msg.Text) > 0 {
+			message.Content = append(message.Content, &types.ContentBlockMemberText{Value: msg.Text})
+		}
+		for i := range msg.Content {
+			block, err := newConverseContentBlock(&msg.Content[i])
+			if err != nil {
+				return nil, NewInvalidRequestError(err)
+			}
+			if block != nil {
+				message.Content = append(message.Content, block)
+			}
+		}
+		converseReq.Messages = append(converseReq.Messages, message)
+	}
+
+	if len(req.Tools) > 0 {
+		toolConfig := &types.ToolConfiguration{}
+		for _, tool := range req.Tools {
+			var schema interface{} = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+			if len(tool.InputSchema) > 0 {
+				if err := json.Unmarshal(tool.InputSchema, &schema); err != nil {
+					return nil, NewInvalidRequestError(fmt.Errorf("invalid input_schema of tool %s: %v", tool.Name, err))
+				}
+			}
+			spec := types.ToolSpecification{
+				Name:        aws.String(tool.Name),
+				InputSchema: &types.ToolInputSchemaMemberJson{Value: document.NewLazyDocument(schema)},
+			}
+			if len(tool.Description) > 0 {
+				spec.Description = aws.String(tool.Description)
+			}
+			toolConfig.Tools = append(toolConfig.Tools, &types.ToolMemberToolSpec{Value: spec})
+		}
+		if req.ToolChoice != nil {
+			switch req.ToolChoice.Type {
+			case "auto":
+				toolConfig.ToolChoice = &types.ToolChoiceMemberAuto{}
+			case "any":
+				toolConfig.ToolChoice = &types.ToolChoiceMemberAny{}
+			case "tool":
+				toolConfig.ToolChoice = &types.ToolChoiceMemberTool{Value: types.SpecificToolChoice{Name: aws.String(req.ToolChoice.Name)}}
+			}
+		}
+		converseReq.ToolConfig = toolConfig
+	}
+
+	return converseReq, nil
+}
+
+func newConverseContentBlock(content *ClaudeMessageCompletionRequestContent) (types.ContentBlock, error) {
+	switch content.Type {
+	case "text":
+		if len(content.Text) == 0 {
+			return nil, nil
+		}
+		return &types.ContentBlockMemberText{Value: content.Text}, nil
+
+	case "image":
+		image, err := newConverseImageBlock(content.Source)
+		if err != nil {
+			return nil, err
+		}
+		return &types.ContentBlockMemberImage{Value: *image}, nil
+
+	case "tool_use":
+		input := content.Input
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		return &types.ContentBlockMemberToolUse{Value: types.ToolUseBlock{
+			ToolUseId: aws.String(content.Id),
+			Name:      aws.String(content.Name),
+			Input:     document.NewLazyDocument(input),
+		}}, nil
+
+	case "tool_result":
+		result := types.ToolResultBlock{ToolUseId: aws.String(content.ToolUseID)}
+		if content.IsError == "true" {
+			result.Status = types.ToolResultStatusError
+		}
+		resultContents, err := newConverseToolResultContent(content.Content)
+		if err != nil {
+			return nil, err
+		}
+		result.Content = resultContents
+		return &types.ContentBlockMemberToolResult{Value: result}, nil
+
+	case "thinking":
+		reasoning := types.ReasoningTextBlock{Text: aws.String(content.Thinking)}
+		if len(content.Signature) > 0 {
+			reasoning.Signature = aws.String(content.Signature)
+		}
+		return &types.ContentBlockMemberReasoningContent{Value: &types.ReasoningContentBlockMemberReasoningText{
+			Value: reasoning,
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported content type for converse: %s", content.Type)
+}
+
+func newConverseImageBlock(source *ClaudeMessageCompletionRequestContentSource) (*types.ImageBlock, error) {
+	if source == nil || source.Type != "base64" {
+		return nil, fmt.Errorf("only base64 image source is supported")
+	}
+	raw, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image data: %v", err)
+	}
+	return &types.ImageBlock{
+		Format: types.ImageFormat(strings.TrimPrefix(source.MediaType, "image/")),
+		Source: &types.ImageSourceMemberBytes{Value: raw},
+	}, nil
+}
+
+// newConverseToolResultContent 转换 tool_result 的内容，可以是字符串或 text / image 内容数组
+func newConverseToolResultContent(raw json.RawMessage) ([]types.ToolResultContentBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return []types.ToolResultContentBlock{&types.ToolResultContentBlockMemberText{Value: ""}}, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []types.ToolResultContentBlock{&types.ToolResultContentBlockMemberText{Value: text}}, nil
+	}
+	var contents []ClaudeMessageCompletionRequestContent
+	if err := json.Unmarshal(raw, &contents); err != nil {
+		return nil, fmt.Errorf("invalid tool_result content: %v", err)
+	}
+	blocks := make([]types.ToolResultContentBlock, 0, len(contents))
+	for i := range contents {
+		switch contents[i].Type {
+		case "text":
+			blocks = append(blocks, &types.ToolResultContentBlockMemberText{Value: contents[i].Text})
+		case "image":
+			image, err := newConverseImageBlock(contents[i].Source)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, &types.ToolResultContentBlockMemberImage{Value: *image})
+		default:
+			return nil, fmt.Errorf("unsupported tool_result content type: %s", contents[i].Type)
+		}
+	}
+	return blocks, nil
+}
+
+// converseMessageId Converse 不返回消息 ID，使用 Bedrock 的 request id 生成
+func converseMessageId(metadata middleware.Metadata) string {
+	if requestId, ok := awsMiddleware.GetRequestIDMetadata(metadata); ok {
+		return "msg_bdrk_" + requestId
+	}
+	return "msg_bdrk"
+}
+
+func converseUsage(usage *types.TokenUsage) *ClaudeMessageUsage {
+	if usage == nil {
+		return nil
+	}
+	return &ClaudeMessageUsage{
+		InputTokens:  int(aws.ToInt32(usage.InputTokens)),
+		OutputTokens: int(aws.ToInt32(usage.OutputTokens)),
+	}
+}
+
+// newConverseMessageResponse 将 Converse 响应转换为 Claude Messages 响应
+func newConverseMessageResponse(model string, output *bedrock.ConverseOutput) (*ClaudeMessageCompletionResponse, error) {
+	resp := &ClaudeMessageCompletionResponse{
+		ClaudeMessageStop: ClaudeMessageStop{StopReason: string(output.StopReason)},
+		Id:                converseMessageId(output.ResultMetadata),
+		Model:             model,
+		Type:              "message",
+		Role:              "assistant",
+		Content:           []*ClaudeMessageContentBlock{},
+		Usage:             converseUsage(output.Usage),
+	}
+
+	message, ok := output.Output.(*types.ConverseOutputMemberMessage)
+	if !ok {
+		return resp, nil
+	}
+	for _, block := range message.Value.Content {
+		switch v := block.(type) {
+		case *types.ContentBlockMemberText:
+			resp.Content = append(resp.Content, &ClaudeMessageContentBlock{Type: "text", Text: v.Value})
+		case *types.ContentBlockMemberToolUse:
+			var input interface{} = map[string]interface{}{}
+			if v.Value.Input != nil {
+				raw, err := v.Value.Input.MarshalSmithyDocument()
+				if err != nil {
+					return nil, err
+				}
+				if err := json.Unmarshal(raw, &input); err != nil {
+					return nil, err
+				}
+			}
+			resp.Content = append(resp.Content, &ClaudeMessageContentBlock{
+				Type:  "tool_use",
+				Id:    aws.ToString(v.Value.ToolUseId),
+				Name:  aws.ToString(v.Value.Name),
+				Input: input,
+			})
+		case *types.ContentBlockMemberReasoningContent:
+			if reasoning, ok := v.Value.(*types.ReasoningContentBlockMemberReasoningText); ok {
+				resp.Content = append(resp.Content, &ClaudeMessageContentBlock{
+					Type:      "thinking",
+					Thinking:  aws.ToString(reasoning.Value.Text),
+					Signature: aws.ToString(reasoning.Value.Signature),
+				})
+			}
+		}
+	}
+	return resp, nil
+}
+
+// newConverseStreamEvent 构造 Claude 流式事件并生成原始 JSON。
+// content_block_* 事件需要输出 index 字段（包括 0），其他事件不输出。
+func newConverseStreamEvent(event *ClaudeMessageCompletionStreamEvent) *ClaudeMessageCompletionStreamEvent {
+	type alias ClaudeMessageCompletionStreamEvent
+	raw := struct {
+		*alias
+		Index *int `json:"index,omitempty"`
+	}{alias: (*alias)(event)}
+	if strings.HasPrefix(event.Type, "content_block_") {
+		raw.Index = &event.Index
+	}
+	event.Raw, _ = json.Marshal(raw)
+	return event
+}
+
+// converseStreamDecoder 将 ConverseStream 事件转换为 Claude Messages 流式事件。
+// Converse 不会为文本块发送 contentBlockStart，需要在收到第一个增量时补发 content_block_start；
+// usage 在 messageStop 之后的 metadata 事件中返回，因此 message_delta 与 message_stop 在 metadata 时输出。
+// messageStart 不带 usage，message_start 中的输入 token 使用本地估算值，流中断时据此记录用量，
+// 正常结束时以 message_delta 中 Bedrock 返回的值为准。
+type converseStreamDecoder struct {
+	id          string
+	model       string
+	inputTokens int
+	stopReason  string
+	started     map[int]bool
+}
+
+func newConverseStreamDecoder(id string, model string, inputTokens int) *converseStreamDecoder {
+	return &converseStreamDecoder{
+		id:          id,
+		model:       model,
+		inputTokens: inputTokens,
+		started:     map[int]bool{},
+	}
+}
+
+func (this *converseStreamDecoder) startBlock(index int, block *ClaudeMessageContentBlock) []ISSEDecoder {
+	if this.started[index] {
+		return nil
+	}
+	this.started[index] = true
+	return []ISSEDecoder{newConverseStreamEvent(&ClaudeMessageCompletionStreamEvent{
+		Type:         "content_block_start",
+		Index:        index,
+		ContentBlock: block,
+	})}
+}
+
+func (this *converseStreamDecoder) Decode(event types.ConverseStreamOutput) ([]ISSEDecoder, error) {
+	switch v := event.(type) {
+	case *types.ConverseStreamOutputMemberMessageStart:
+		return []ISSEDecoder{newConverseStreamEvent(&ClaudeMessageCompletionStreamEvent{
+			Type: "message_start",
+			Message: &ClaudeMessageInfo{
+				Id:      this.id,
+				Type:    "message",
+				Role:    string(v.Value.Role),
+				Content: []string{},
+				Model:   this.model,
+				Usage:   &ClaudeMessageUsage{InputTokens: this.inputTokens},
+			},
+		})}, nil
+
+	case *types.ConverseStreamOutputMemberContentBlockStart:
+		index := int(aws.ToInt32(v.Value.ContentBlockIndex))
+		if toolUse, ok := v.Value.Start.(*types.ContentBlockStartMemberToolUse); ok {
+			return this.startBlock(index, &ClaudeMessageContentBlock{
+				Type:  "tool_use",
+				Id:    aws.ToString(toolUse.Value.ToolUseId),
+				Name:  aws.ToString(toolUse.Value.Name),
+				Input: map[string]interface{}{},
+			}), nil
+		}
+		return nil, nil
+
+	case *types.ConverseStreamOutputMemberContentBlockDelta:
+		index := int(aws.ToInt32(v.Value.ContentBlockIndex))
+		var events []ISSEDecoder
+		var delta *ClaudeMessageDelta
+		switch d := v.Value.Delta.(type) {
+		case *types.ContentBlockDeltaMemberText:
+			events = this.startBlock(index, &ClaudeMessageContentBlock{Type: "text"})
+			delta = &ClaudeMessageDelta{Type: "text_delta", Text: d.Value}
+		case *types.ContentBlockDeltaMemberToolUse:
+			delta = &ClaudeMessageDelta{Type: "input_json_delta", PartialJson: aws.ToString(d.Value.Input)}
+		case *types.ContentBlockDeltaMemberReasoningContent:
+			events = this.startBlock(index, &ClaudeMessageContentBlock{Type: "thinking"})
+			switch r := d.Value.(type) {
+			case *types.ReasoningContentBlockDeltaMemberText:
+				delta = &ClaudeMessageDelta{Type: "thinking_delta", Thinking: r.Value}
+			case *types.ReasoningContentBlockDeltaMemberSignature:
+				delta = &ClaudeMessageDelta{Type: "signature_delta", Signature: r.Value}
+			}
+		}
+		if delta == nil {
+			return events, nil
+		}
+		return append(events, newConverseStreamEvent(&ClaudeMessageCompletionStreamEvent{
+			Type:  "content_block_delta",
+			Index: index,
+			Delta: delta,
+		})), nil
+
+	case *types.ConverseStreamOutputMemberContentBlockStop:
+		index := int(aws.ToInt32(v.Value.ContentBlockIndex))
+		if !this.started[index] {
+			return nil, nil
+		}
+		return []ISSEDecoder{newConverseStreamEvent(&ClaudeMessageCompletionStreamEvent{
+			Type:  "content_block_stop",
+			Index: index,
+		})}, nil
+
+	case *types.ConverseStreamOutputMemberMessageStop:
+		this.stopReason = string(v.Value.StopReason)
+		return nil, nil
+
+	case *types.ConverseStreamOutputMemberMetadata:
+		return []ISSEDecoder{
+			newConverseStreamEvent(&ClaudeMessageCompletionStreamEvent{
+				Type:  "message_delta",
+				Delta: &ClaudeMessageDelta{ClaudeMessageStop: ClaudeMessageStop{StopReason: this.stopReason}},
+				Usage: converseUsage(v.Value.Usage),
+			}),
+			newConverseStreamEvent(&ClaudeMessageCompletionStreamEvent{Type: "message_stop"}),
+		}, nil
+
+	case *types.UnknownUnionMember:
+		return nil, fmt.Errorf("unknown tag: %s", v.Tag)
+	}
+	return nil, fmt.Errorf("union is nil or unknown type")
+}
+
+// ConverseCompletion 使用 Bedrock Converse / ConverseStream 完成 Messages 请求
+func (this *BedrockClient) ConverseCompletion(ctx context.Context, modelId string, req *ClaudeMessageCompletionRequest) (IStreamableResponse, error) {
+	converseReq, err := newConverseRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Logger.Debugf("Request Model ID: %s (converse)", modelId)
+
+	ctx, cancel := this.withRequestTimeout(ctx)
+
+	if req.Stream {
+		output, err := this.client.ConverseStream(ctx, &bedrock.ConverseStreamInput{
+			ModelId:         aws.String(modelId),
+			Messages:        converseReq.Messages,
+			System:          converseReq.System,
+			InferenceConfig: converseReq.InferenceConfig,
+			ToolConfig:      converseReq.ToolConfig,
+		})
+		if err != nil {
+			cancel()
+			log.Logger.Error(err)
+			return nil, err
+		}
+
+		decoder := newConverseStreamDecoder(converseMessageId(output.ResultMetadata), req.Model, EstimateMessageTokens(req))
+		eventQueue := pipeStream[types.ConverseStreamOutput](ctx, cancel, this.config.GetStreamIdleTimeout(),
+			output.GetStream(), decoder.Decode)
+
+		return NewStreamMessageCompleteResponse(eventQueue), nil
+	}
+	defer cancel()
+
+	output, err := this.client.Converse(ctx, &bedrock.ConverseInput{
+		ModelId:         aws.String(modelId),
+		Messages:        converseReq.Messages,
+		System:          converseReq.System,
+		InferenceConfig: converseReq.InferenceConfig,
+		ToolConfig:      converseReq.ToolConfig,
+	})
+	if err != nil {
+		log.Logger.Error(err)
+		return nil, err
+	}
+
+	resp, err := newConverseMessageResponse(req.Model, output)
+	if err != nil {
+		log.Logger.Error(err)
+		return nil, err
+	}
+	return NewMessageCompleteResponse(resp), nil
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	bedrock "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func TestBedrockConfig_GetModelBackend(t *testing.T) {
+	config := &BedrockConfig{ModelBackends: map[string]string{"my-claude": ModelBackendConverse}}
+
+	cases := []struct {
+		model, modelId, backend string
+	}{
+		{"claude-3-haiku-20240307", "anthropic.claude-3-haiku-20240307-v1:0", ModelBackendInvoke},
+		{"claude-3-7-sonnet", "us.anthropic.claude-3-7-sonnet-20250219-v1:0", ModelBackendInvoke},
+		{"my-claude", "anthropic.claude-3-haiku-20240307-v1:0", ModelBackendConverse},
+		{"aws-llama3:70b", "meta.llama3-70b-instruct-v1:0", ModelBackendConverse},
+		{"deepseek.r1-v1:0", "us.deepseek.r1-v1:0", ModelBackendConverse},
+	}
+	for _, c := range cases {
+		if backend := config.GetModelBackend(c.model, c.modelId); backend != c.backend {
+			t.Errorf("%s (%s): got %s, want %s", c.model, c.modelId, backend, c.backend)
+		}
+	}
+	t.Log("PASS")
+}
+
+func TestNewConverseRequest(t *testing.T) {
+	body := `{
+		"model": "aws-llama3:70b",
+		"max_tokens": 512,
+		"temperature": 0.5,
+		"system": [{"type": "text", "text": "You are helpful."}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is the weather?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"}
+			]}
+		],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+		"tool_choice": {"type": "tool", "name": "get_weather"}
+	}`
+
+	var req ClaudeMessageCompletionRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	converseReq, err := newConverseRequest(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aws.ToInt32(converseReq.InferenceConfig.MaxTokens) != 512 || aws.ToFloat32(converseReq.InferenceConfig.Temperature) != 0.5 {
+		t.Fatalf("unexpected inference config: %+v", converseReq.InferenceConfig)
+	}
+	if len(converseReq.System) != 1 || len(converseReq.Messages) != 3 {
+		t.Fatalf("unexpected system/messages: %d %d", len(converseReq.System), len(converseReq.Messages))
+	}
+	image, ok := converseReq.Messages[0].Content[1].(*types.ContentBlockMemberImage)
+	if !ok || image.Value.Format != types.ImageFormatPng {
+		t.Fatalf("unexpected image block: %#v", converseReq.Messages[0].Content[1])
+	}
+	toolUse, ok := converseReq.Messages[1].Content[0].(*types.ContentBlockMemberToolUse)
+	if !ok || aws.ToString(toolUse.Value.ToolUseId) != "toolu_1" {
+		t.Fatalf("unexpected tool use block: %#v", converseReq.Messages[1].Content[0])
+	}
+	toolResult, ok := converseReq.Messages[2].Content[0].(*types.ContentBlockMemberToolResult)
+	if !ok || toolResult.Value.Content[0].(*types.ToolResultContentBlockMemberText).Value != "sunny" {
+		t.Fatalf("unexpected tool result block: %#v", converseReq.Messages[2].Content[0])
+	}
+	choice, ok := converseReq.ToolConfig.ToolChoice.(*types.ToolChoiceMemberTool)
+	if len(converseReq.ToolConfig.Tools) != 1 || !ok || aws.ToString(choice.Value.Name) != "get_weather" {
+		t.Fatalf("unexpected tool config: %#v", converseReq.ToolConfig)
+	}
+	t.Log("PASS")
+}
+
+func TestNewConverseMessageResponse(t *testing.T) {
+	output := &bedrock.ConverseOutput{
+		Output: &types.ConverseOutputMemberMessage{Value: types.Message{
+			Role: types.ConversationRoleAssistant,
+			Content: []types.ContentBlock{
+				&types.ContentBlockMemberText{Value: "Let me check."},
+				&types.ContentBlockMemberToolUse{Value: types.ToolUseBlock{
+					ToolUseId: aws.String("tooluse_1"),
+					Name:      aws.String("get_weather"),
+					Input:     document.NewLazyDocument(map[string]interface{}{"city": "Paris"}),
+				}},
+			},
+		}},
+		StopReason: types.StopReasonToolUse,
+		Usage:      &types.TokenUsage{InputTokens: aws.Int32(10), OutputTokens: aws.Int32(5)},
+	}
+
+	resp, err := newConverseMessageResponse("aws-llama3:70b", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StopReason != "tool_use" || resp.Usage.InputTokens != 10 || resp.Usage.OutputTokens != 5 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(resp.Content) != 2 || resp.Content[1].Type != "tool_use" || resp.Content[1].Id != "tooluse_1" {
+		t.Fatalf("unexpected content: %+v", resp.Content)
+	}
+	t.Log("PASS")
+}
+
+func TestConverseStreamDecoder(t *testing.T) {
+	decoder := newConverseStreamDecoder("msg_bdrk_1", "aws-llama3:70b", 8)
+	upstream := []types.ConverseStreamOutput{
+		&types.ConverseStreamOutputMemberMessageStart{Value: types.MessageStartEvent{Role: types.ConversationRoleAssistant}},
+		&types.ConverseStreamOutputMemberContentBlockDelta{Value: types.ContentBlockDeltaEvent{
+			ContentBlockIndex: aws.Int32(0),
+			Delta:             &types.ContentBlockDeltaMemberText{Value: "Hi"},
+		}},
+		&types.ConverseStreamOutputMemberContentBlockStop{Value: types.ContentBlockStopEvent{ContentBlockIndex: aws.Int32(0)}},
+		&types.ConverseStreamOutputMemberMessageStop{Value: types.MessageStopEvent{StopReason: types.StopReasonEndTurn}},
+		&types.ConverseStreamOutputMemberMetadata{Value: types.ConverseStreamMetadataEvent{
+			Usage: &types.TokenUsage{InputTokens: aws.Int32(10), OutputTokens: aws.Int32(3)},
+		}},
+	}
+
+	var frames []string
+	for _, event := range upstream {
+		events, err := decoder.Decode(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			frames = append(frames, e.GetEvent()+" "+string(e.GetBytes()))
+		}
+	}
+
+	expected := []string{
+		`message_start {"type":"message_start","message":{"id":"msg_bdrk_1","type":"message","role":"assistant","model":"aws-llama3:70b","usage":{"input_tokens":8}}}`,
+		`content_block_start {"type":"content_block_start","content_block":{"type":"text"},"index":0}`,
+		`content_block_delta {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hi"},"index":0}`,
+		`content_block_stop {"type":"content_block_stop","index":0}`,
+		`message_delta {"type":"message_delta","usage":{"input_tokens":10,"output_tokens":3},"delta":{"stop_reason":"end_turn"}}`,
+		`message_stop {"type":"message_stop"}`,
+	}
+	if strings.Join(frames, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected events:\n%s", strings.Join(frames, "\n"))
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// 凭证来源
+const (
+	// 使用 access_key / secret_key 静态凭证
+	CredentialSourceStatic = "static"
+	// 使用 access_key / secret_key / session_token 临时凭证
+	CredentialSourceSessionToken = "session_token"
+	// 使用 SDK 默认凭证链（环境变量、共享配置、IRSA、ECS 容器凭证、EC2 实例角色等）
+	CredentialSourceDefault = "default"
+	// 使用共享配置文件中的指定 profile
+	CredentialSourceProfile = "profile"
+	// 使用 OIDC token 文件通过 AssumeRoleWithWebIdentity 获取凭证
+	CredentialSourceWebIdentity = "web_identity"
+)
+
+// GetCredentialSource 返回实际使用的凭证来源。
+// 未显式配置时，配置了静态密钥则使用静态密钥（有 session_token 时使用临时凭证），否则回退到默认凭证链。
+func (this *BedrockConfig) GetCredentialSource() string {
+	if len(this.CredentialSource) > 0 {
+		return this.CredentialSource
+	}
+	if len(this.AccessKey) > 0 && len(this.SecretKey) > 0 {
+		if len(this.SessionToken) > 0 {
+			return CredentialSourceSessionToken
+		}
+		return CredentialSourceStatic
+	}
+	return CredentialSourceDefault
+}
+
+// LoadAWSConfig 根据凭证来源加载 AWS SDK 配置，SDK 客户端与 SigV4 签名共用同一份凭证。
+// 设置 RoleARN 时会在基础凭证之上再 AssumeRole，并在凭证过期前自动刷新。
+func (this *BedrockConfig) LoadAWSConfig(ctx context.Context) (aws.Config, error) {
+	opt := []func(*awsConfig.LoadOptions) error{
+		awsConfig.WithRegion(this.Region),
+	}
+
+	source := this.GetCredentialSource()
+	switch source {
+	case CredentialSourceStatic:
+		opt = append(opt, awsConfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(this.AccessKey, this.SecretKey, "")))
+	case CredentialSourceSessionToken:
+		opt = append(opt, awsConfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(this.AccessKey, this.SecretKey, this.SessionToken)))
+	case CredentialSourceProfile:
+		if len(this.Profile) == 0 {
+			return aws.Config{}, fmt.Errorf("credential source %s requires profile", source)
+		}
+		opt = append(opt, awsConfig.WithSharedConfigProfile(this.Profile))
+	case CredentialSourceWebIdentity, CredentialSourceDefault:
+	default:
+		return aws.Config{}, fmt.Errorf("unknown credential source: %s", source)
+	}
+
+	cfg, err := awsConfig.LoadDefaultConfig(ctx, opt...)
+	if err != nil {
+		return cfg, err
+	}
+
+	if this.DEBUG {
+		// SDK 只能对 BuildableClient 应用 AWS_CA_BUNDLE 等传输配置，加载完成后再包装日志，保留已应用的配置
+		if client, ok := cfg.HTTPClient.(*awshttp.BuildableClient); ok {
+			cfg.HTTPClient = &http.Client{
+				Transport: loggingRoundTripper{wrapped: client.GetTransport()},
+				Timeout:   client.GetTimeout(),
+			}
+		}
+	}
+
+	if source == CredentialSourceWebIdentity {
+		roleARN := this.WebIdentityRoleARN
+		if len(roleARN) == 0 {
+			roleARN = os.Getenv("AWS_ROLE_ARN")
+		}
+		tokenFile := this.WebIdentityTokenFile
+		if len(tokenFile) == 0 {
+			tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+		if len(roleARN) == 0 || len(tokenFile) == 0 {
+			return cfg, fmt.Errorf("credential source %s requires web identity role arn and token file", source)
+		}
+		provider := stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(cfg), roleARN,
+			stscreds.IdentityTokenFile(tokenFile), func(options *stscreds.WebIdentityRoleOptions) {
+				options.RoleSessionName = "bedrockruntime-session"
+			})
+		cfg.Credentials = newRefreshingCredentialsCache(provider)
+	}
+
+	if len(this.RoleARN) > 0 {
+		// ===== assume role ======
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), this.RoleARN, func(options *stscreds.AssumeRoleOptions) {
+			options.RoleSessionName = "bedrockruntime-session"
+		})
+		cfg.Credentials = newRefreshingCredentialsCache(provider)
+	}
+
+	return cfg, nil
+}
+
+func newRefreshingCredentialsCache(provider aws.CredentialsProvider) *aws.CredentialsCache {
+	return aws.NewCredentialsCache(provider, func(options *aws.CredentialsCacheOptions) {
+		// 提前刷新，避免请求进行中凭证过期
+		options.ExpiryWindow = 5 * time.Minute
+		options.ExpiryWindowJitterFrac = 0.2
+	})
+}
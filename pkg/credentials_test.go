@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBedrockConfig_LoadAWSConfigWithCABundle(t *testing.T) {
+	// 生成自签名证书作为 AWS_CA_BUNDLE
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test ca"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CA_BUNDLE", bundle)
+
+	// DEBUG 时包装日志，同时保留 CA 证书配置
+	config := &BedrockConfig{Region: "us-east-1", AccessKey: "AKIA", SecretKey: "secret", DEBUG: true}
+	cfg, err := config.LoadAWSConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, ok := cfg.HTTPClient.(*http.Client)
+	if !ok {
+		t.Fatalf("unexpected http client: %T", cfg.HTTPClient)
+	}
+	transport, ok := client.Transport.(loggingRoundTripper).wrapped.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+		t.Fatal("custom CA bundle should be applied to the debug transport")
+	}
+	t.Log("PASS")
+}
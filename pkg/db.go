@@ -1,43 +1,88 @@
 package pkg
 
 import (
+	log "bedrock-claude-proxy/log"
 	"bedrock-claude-proxy/models"
-	"crypto/sha256"
+	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"os"
 
 	"gorm.io/gorm"
 )
 
 // InitDB 初始化数据库，执行迁移操作
 func InitDB(db *gorm.DB) error {
+	if err := models.PurgeDeletedAdmins(db); err != nil {
+		return err
+	}
+
 	// 自动迁移数据库模型
-	err := db.AutoMigrate(&models.Admin{}, &models.APIKey{}, &models.Usage{})
+	err := db.AutoMigrate(&models.Admin{}, &models.APIKey{}, &models.Usage{}, &models.APIKeyChange{}, &models.RevokedToken{}, &models.AuditLog{})
 	if err != nil {
 		return err
 	}
 
-	// 检查是否需要创建默认管理员账户
-	var count int64
-	db.Model(&models.Admin{}).Count(&count)
+	// 旧版本以明文保存密钥，迁移为加盐哈希
+	if err := models.MigrateAPIKeyHashes(db); err != nil {
+		return err
+	}
+	if err := models.MigrateUsageAPIKeyPrefix(db); err != nil {
+		return err
+	}
+	if err := models.MigrateUsageIndexes(db); err != nil {
+		return err
+	}
 
-	// 如果没有管理员账户，则创建默认管理员账户
-	if count == 0 {
-		defaultAdmin := models.Admin{
-			Username: "proxy",
-			Password: HashPassword("hello@autel.com"), // 默认密码
-		}
+	return initAdmin(db)
+}
 
-		result := db.Create(&defaultAdmin)
-		if result.Error != nil {
-			return result.Error
+// initAdmin 首次启动时创建管理员账户。
+// 密码来自 ADMIN_INITIAL_PASSWORD，未设置时生成一次性的初始密码输出到日志，首次登录后必须修改。
+// 仍在使用旧版本默认密码的管理员会被要求修改密码。
+func initAdmin(db *gorm.DB) error {
+	var admins []models.Admin
+	if err := db.Find(&admins).Error; err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		if ok, _ := models.VerifyAdminPassword(admin.Password, models.LegacyDefaultAdminPassword); ok && !admin.MustChangePassword {
+			log.Logger.Warningf("Admin %s is using the default password and must change it on next login", admin.Username)
+			if err := db.Model(&admin).Update("must_change_password", true).Error; err != nil {
+				return err
+			}
 		}
 	}
+	if len(admins) > 0 {
+		return nil
+	}
 
-	return nil
-}
+	username := os.Getenv("ADMIN_INITIAL_USERNAME")
+	if len(username) == 0 {
+		username = "proxy"
+	}
+	password := os.Getenv("ADMIN_INITIAL_PASSWORD")
+	mustChangePassword := false
+	if len(password) == 0 {
+		bytes := make([]byte, 12)
+		if _, err := rand.Read(bytes); err != nil {
+			return err
+		}
+		password = hex.EncodeToString(bytes)
+		mustChangePassword = true
+		log.Logger.Warningf("Created admin %s with one-time bootstrap password: %s (change it after first login)", username, password)
+	} else if len(password) < models.MinAdminPasswordLength {
+		return fmt.Errorf("ADMIN_INITIAL_PASSWORD must be at least %d characters", models.MinAdminPasswordLength)
+	}
 
-// HashPassword 哈希密码
-func HashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+	hash, err := models.HashAdminPassword(password)
+	if err != nil {
+		return err
+	}
+	return db.Create(&models.Admin{
+		Username:           username,
+		Password:           hash,
+		Role:               models.RoleOwner,
+		MustChangePassword: mustChangePassword,
+	}).Error
 }
@@ -0,0 +1,249 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	log "bedrock-claude-proxy/log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	bedrock "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// OpenAI 兼容的 Embeddings 接口，后端为 Bedrock 上的 Cohere 与 Titan 向量模型
+
+const (
+	// Cohere Embed 单次请求最多 96 条文本
+	cohereEmbedMaxTexts = 96
+	// Cohere Embed v3 的向量维度固定为 1024
+	cohereEmbedDimensions = 1024
+	// 未指定 input_type 时 Cohere 按文档向量处理
+	cohereDefaultInputType = "search_document"
+)
+
+// Titan Embed Text v2 支持的向量维度
+var titanEmbedV2Dimensions = map[int]bool{256: true, 512: true, 1024: true}
+
+type OpenAIEmbeddingRequest struct {
+	Model string `json:"model"`
+	// 字符串或字符串数组
+	Input          json.RawMessage `json:"input"`
+	EncodingFormat string          `json:"encoding_format,omitempty"`
+	Dimensions     int             `json:"dimensions,omitempty"`
+	// Cohere 的 input_type：search_document、search_query、classification、clustering
+	InputType string `json:"input_type,omitempty"`
+	User      string `json:"user,omitempty"`
+}
+
+type OpenAIEmbedding struct {
+	Object string `json:"object"`
+	Index  int    `json:"index"`
+	// []float64，encoding_format 为 base64 时为 float32 小端序的 base64 字符串
+	Embedding interface{} `json:"embedding"`
+}
+
+type OpenAIEmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+type OpenAIEmbeddingResponse struct {
+	Object string                `json:"object"`
+	Data   []*OpenAIEmbedding    `json:"data"`
+	Model  string                `json:"model"`
+	Usage  *OpenAIEmbeddingUsage `json:"usage"`
+}
+
+type CohereEmbedRequest struct {
+	Texts     []string `json:"texts"`
+	InputType string   `json:"input_type"`
+	Truncate  string   `json:"truncate,omitempty"`
+}
+
+type CohereEmbedResponse struct {
+	Id         string      `json:"id,omitempty"`
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+type TitanEmbedRequest struct {
+	InputText  string `json:"inputText"`
+	Dimensions int    `json:"dimensions,omitempty"`
+	Normalize  *bool  `json:"normalize,omitempty"`
+}
+
+type TitanEmbedResponse struct {
+	Embedding           []float64 `json:"embedding"`
+	InputTextTokenCount int       `json:"inputTextTokenCount"`
+}
+
+// GetInputs 解析 input 字段，支持字符串与字符串数组
+func (this *OpenAIEmbeddingRequest) GetInputs() ([]string, error) {
+	if len(this.Input) == 0 || string(this.Input) == "null" {
+		return nil, fmt.Errorf("input is required")
+	}
+	var input string
+	if err := json.Unmarshal(this.Input, &input); err == nil {
+		return []string{input}, nil
+	}
+	var inputs []string
+	if err := json.Unmarshal(this.Input, &inputs); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of strings")
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("input must not be empty")
+	}
+	return inputs, nil
+}
+
+// encodeEmbedding 按 encoding_format 输出向量
+func encodeEmbedding(embedding []float64, encodingFormat string) interface{} {
+	if encodingFormat != "base64" {
+		return embedding
+	}
+	buf := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(value)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func (this *BedrockClient) invokeJSONModel(ctx context.Context, modelId string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	output, err := this.client.InvokeModel(ctx, &bedrock.InvokeModelInput{
+		Body:        body,
+		ModelId:     aws.String(modelId),
+		ContentType: aws.String("application/json"),
+		Accept:      aws.String("application/json"),
+	})
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(output.Body)).Decode(response)
+}
+
+// embedCohere 按 Cohere 的单次条数限制分批请求，token 数量在本地估算
+func (this *BedrockClient) embedCohere(ctx context.Context, modelId string, req *OpenAIEmbeddingRequest, inputs []string) ([][]float64, int, error) {
+	if req.Dimensions > 0 && req.Dimensions != cohereEmbedDimensions {
+		return nil, 0, NewInvalidRequestError(fmt.Errorf("model %s only supports %d dimensions", req.Model, cohereEmbedDimensions))
+	}
+	inputType := req.InputType
+	if len(inputType) == 0 {
+		inputType = cohereDefaultInputType
+	}
+
+	embeddings := make([][]float64, 0, len(inputs))
+	tokens := 0
+	for start := 0; start < len(inputs); start += cohereEmbedMaxTexts {
+		end := start + cohereEmbedMaxTexts
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		var resp CohereEmbedResponse
+		err := this.invokeJSONModel(ctx, modelId, &CohereEmbedRequest{
+			Texts:     inputs[start:end],
+			InputType: inputType,
+			Truncate:  "END",
+		}, &resp)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(resp.Embeddings) != end-start {
+			return nil, 0, fmt.Errorf("cohere returned %d embeddings for %d texts", len(resp.Embeddings), end-start)
+		}
+		embeddings = append(embeddings, resp.Embeddings...)
+		for _, input := range inputs[start:end] {
+			tokens += EstimateTokens(input)
+		}
+	}
+	return embeddings, tokens, nil
+}
+
+// embedTitan Titan 每次请求只能处理一条文本，token 数量使用模型返回的 inputTextTokenCount
+func (this *BedrockClient) embedTitan(ctx context.Context, modelId string, req *OpenAIEmbeddingRequest, inputs []string) ([][]float64, int, error) {
+	isV2 := strings.Contains(modelId, "titan-embed-text-v2")
+	if req.Dimensions > 0 {
+		if !isV2 {
+			return nil, 0, NewInvalidRequestError(fmt.Errorf("model %s does not support dimensions", req.Model))
+		}
+		if !titanEmbedV2Dimensions[req.Dimensions] {
+			return nil, 0, NewInvalidRequestError(fmt.Errorf("dimensions must be one of 256, 512, 1024"))
+		}
+	}
+
+	embeddings := make([][]float64, 0, len(inputs))
+	tokens := 0
+	for _, input := range inputs {
+		titanReq := &TitanEmbedRequest{InputText: input}
+		if isV2 {
+			// 与 OpenAI 一致，输出归一化向量
+			titanReq.Dimensions = req.Dimensions
+			titanReq.Normalize = aws.Bool(true)
+		}
+		var resp TitanEmbedResponse
+		if err := this.invokeJSONModel(ctx, modelId, titanReq, &resp); err != nil {
+			return nil, 0, err
+		}
+		embeddings = append(embeddings, resp.Embedding)
+		tokens += resp.InputTextTokenCount
+	}
+	return embeddings, tokens, nil
+}
+
+// Embeddings 生成文本向量，根据映射后的模型 ID 选择 Cohere 或 Titan
+func (this *BedrockClient) Embeddings(ctx context.Context, req *OpenAIEmbeddingRequest) (*OpenAIEmbeddingResponse, error) {
+	if len(req.Model) == 0 {
+		return nil, NewInvalidRequestError(fmt.Errorf("model is required"))
+	}
+	inputs, err := req.GetInputs()
+	if err != nil {
+		return nil, NewInvalidRequestError(err)
+	}
+	if len(req.EncodingFormat) > 0 && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		return nil, NewInvalidRequestError(fmt.Errorf("encoding_format must be float or base64"))
+	}
+
+	modelId := this.resolveModelId(req.Model)
+	log.Logger.Debugf("Embedding Model ID: %s, inputs: %d", modelId, len(inputs))
+
+	ctx, cancel := this.withRequestTimeout(ctx)
+	defer cancel()
+
+	var embeddings [][]float64
+	var tokens int
+	switch {
+	case strings.Contains(modelId, "cohere.embed"):
+		embeddings, tokens, err = this.embedCohere(ctx, modelId, req, inputs)
+	case strings.Contains(modelId, "titan-embed-text"):
+		embeddings, tokens, err = this.embedTitan(ctx, modelId, req, inputs)
+	default:
+		return nil, NewInvalidRequestError(fmt.Errorf("model %s does not support embeddings", req.Model))
+	}
+	if err != nil {
+		log.Logger.Error(err)
+		return nil, err
+	}
+
+	resp := &OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]*OpenAIEmbedding, 0, len(embeddings)),
+		Model:  req.Model,
+		Usage:  &OpenAIEmbeddingUsage{PromptTokens: tokens, TotalTokens: tokens},
+	}
+	for i, embedding := range embeddings {
+		resp.Data = append(resp.Data, &OpenAIEmbedding{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: encodeEmbedding(embedding, req.EncodingFormat),
+		})
+	}
+	return resp, nil
+}
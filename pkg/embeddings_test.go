@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestOpenAIEmbeddingRequest_GetInputs(t *testing.T) {
+	cases := []struct {
+		body  string
+		count int
+		valid bool
+	}{
+		{`{"model":"cohere.embed-english-v3","input":"hello"}`, 1, true},
+		{`{"model":"cohere.embed-english-v3","input":["hello","world"]}`, 2, true},
+		{`{"model":"cohere.embed-english-v3","input":[]}`, 0, false},
+		{`{"model":"cohere.embed-english-v3","input":[1,2,3]}`, 0, false},
+		{`{"model":"cohere.embed-english-v3"}`, 0, false},
+	}
+
+	for _, c := range cases {
+		var req OpenAIEmbeddingRequest
+		if err := json.Unmarshal([]byte(c.body), &req); err != nil {
+			t.Fatal(err)
+		}
+		inputs, err := req.GetInputs()
+		if (err == nil) != c.valid || len(inputs) != c.count {
+			t.Errorf("%s: got %v %v", c.body, inputs, err)
+		}
+	}
+	t.Log("PASS")
+}
+
+func TestEncodeEmbedding(t *testing.T) {
+	embedding := []float64{0.5, -1.25, 3}
+
+	if floats, ok := encodeEmbedding(embedding, "float").([]float64); !ok || len(floats) != 3 {
+		t.Fatalf("unexpected float encoding: %v", floats)
+	}
+
+	encoded, ok := encodeEmbedding(embedding, "base64").(string)
+	if !ok {
+		t.Fatal("base64 encoding should return a string")
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 12 {
+		t.Fatalf("unexpected base64 payload: %q %v", encoded, err)
+	}
+	for i, expected := range embedding {
+		value := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+		if float64(value) != expected {
+			t.Fatalf("index %d: got %v, want %v", i, value, expected)
+		}
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// Anthropic API 的错误类型，见 https://docs.anthropic.com/en/api/errors
+const (
+	ErrorTypeInvalidRequest  = "invalid_request_error"
+	ErrorTypeAuthentication  = "authentication_error"
+	ErrorTypePermission      = "permission_error"
+	ErrorTypeNotFound        = "not_found_error"
+	ErrorTypeRequestTooLarge = "request_too_large"
+	ErrorTypeRateLimit       = "rate_limit_error"
+	ErrorTypeAPI             = "api_error"
+	ErrorTypeOverloaded      = "overloaded_error"
+)
+
+// StatusOverloaded Anthropic 在服务过载时使用的非标准 HTTP 状态码
+const StatusOverloaded = 529
+
+// ProxyError 带有 HTTP 状态码和 Anthropic 错误类型的错误
+type ProxyError struct {
+	Status  int
+	Type    string
+	Message string
+}
+
+func NewProxyError(status int, errorType string, message string) *ProxyError {
+	return &ProxyError{
+		Status:  status,
+		Type:    errorType,
+		Message: message,
+	}
+}
+
+func NewInvalidRequestError(err error) *ProxyError {
+	return NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest, err.Error())
+}
+
+func (this *ProxyError) Error() string {
+	return this.Message
+}
+
+// AsProxyError 将任意错误映射为 Anthropic 的错误类型与 HTTP 状态码。
+// Bedrock SDK 的异常按类型映射，其他带 HTTP 状态码的错误按状态码映射，其余视为 api_error。
+func AsProxyError(err error) *ProxyError {
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return proxyErr
+	}
+
+	var (
+		accessDenied     *types.AccessDeniedException
+		validation       *types.ValidationException
+		notFound         *types.ResourceNotFoundException
+		throttling       *types.ThrottlingException
+		quotaExceeded    *types.ServiceQuotaExceededException
+		modelTimeout     *types.ModelTimeoutException
+		modelNotReady    *types.ModelNotReadyException
+		modelError       *types.ModelErrorException
+		modelStreamError *types.ModelStreamErrorException
+		internalServer   *types.InternalServerException
+		withStatusCode   interface{ HTTPStatusCode() int }
+		message          = err.Error()
+	)
+
+	switch {
+	case errors.As(err, &accessDenied):
+		return NewProxyError(http.StatusForbidden, ErrorTypePermission, accessDenied.ErrorMessage())
+	case errors.As(err, &validation):
+		return NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest, validation.ErrorMessage())
+	case errors.As(err, &notFound):
+		return NewProxyError(http.StatusNotFound, ErrorTypeNotFound, notFound.ErrorMessage())
+	case errors.As(err, &throttling):
+		return NewProxyError(http.StatusTooManyRequests, ErrorTypeRateLimit, throttling.ErrorMessage())
+	case errors.As(err, &quotaExceeded):
+		return NewProxyError(http.StatusTooManyRequests, ErrorTypeRateLimit, quotaExceeded.ErrorMessage())
+	case errors.As(err, &modelTimeout):
+		return NewProxyError(StatusOverloaded, ErrorTypeOverloaded, modelTimeout.ErrorMessage())
+	case errors.As(err, &modelNotReady):
+		return NewProxyError(StatusOverloaded, ErrorTypeOverloaded, modelNotReady.ErrorMessage())
+	case errors.As(err, &modelError):
+		return NewProxyError(http.StatusInternalServerError, ErrorTypeAPI, modelError.ErrorMessage())
+	case errors.As(err, &modelStreamError):
+		return NewProxyError(http.StatusInternalServerError, ErrorTypeAPI, modelStreamError.ErrorMessage())
+	case errors.As(err, &internalServer):
+		return NewProxyError(http.StatusInternalServerError, ErrorTypeAPI, internalServer.ErrorMessage())
+	case errors.Is(err, context.DeadlineExceeded):
+		return NewProxyError(http.StatusGatewayTimeout, ErrorTypeAPI, message)
+	case errors.As(err, &withStatusCode):
+		return newProxyErrorFromStatus(withStatusCode.HTTPStatusCode(), message)
+	}
+
+	return NewProxyError(http.StatusInternalServerError, ErrorTypeAPI, message)
+}
+
+func newProxyErrorFromStatus(status int, message string) *ProxyError {
+	switch {
+	case status == http.StatusBadRequest:
+		return NewProxyError(status, ErrorTypeInvalidRequest, message)
+	case status == http.StatusUnauthorized:
+		return NewProxyError(status, ErrorTypeAuthentication, message)
+	case status == http.StatusForbidden:
+		return NewProxyError(status, ErrorTypePermission, message)
+	case status == http.StatusNotFound:
+		return NewProxyError(status, ErrorTypeNotFound, message)
+	case status == http.StatusRequestEntityTooLarge:
+		return NewProxyError(status, ErrorTypeRequestTooLarge, message)
+	case status == http.StatusTooManyRequests:
+		return NewProxyError(status, ErrorTypeRateLimit, message)
+	case status == http.StatusServiceUnavailable || status == StatusOverloaded:
+		return NewProxyError(StatusOverloaded, ErrorTypeOverloaded, message)
+	case status >= 400 && status < 500:
+		return NewProxyError(status, ErrorTypeInvalidRequest, message)
+	}
+	return NewProxyError(http.StatusInternalServerError, ErrorTypeAPI, message)
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func TestAsProxyError(t *testing.T) {
+	cases := []struct {
+		err       error
+		status    int
+		errorType string
+	}{
+		{&types.AccessDeniedException{Message: aws.String("denied")}, http.StatusForbidden, ErrorTypePermission},
+		{&types.ValidationException{Message: aws.String("invalid")}, http.StatusBadRequest, ErrorTypeInvalidRequest},
+		{&types.ResourceNotFoundException{Message: aws.String("missing")}, http.StatusNotFound, ErrorTypeNotFound},
+		{&types.ThrottlingException{Message: aws.String("slow down")}, http.StatusTooManyRequests, ErrorTypeRateLimit},
+		{&types.ServiceQuotaExceededException{Message: aws.String("quota")}, http.StatusTooManyRequests, ErrorTypeRateLimit},
+		{&types.ModelTimeoutException{Message: aws.String("timeout")}, StatusOverloaded, ErrorTypeOverloaded},
+		{&types.InternalServerException{Message: aws.String("boom")}, http.StatusInternalServerError, ErrorTypeAPI},
+		{fmt.Errorf("operation error: %w", &types.ThrottlingException{Message: aws.String("wrapped")}), http.StatusTooManyRequests, ErrorTypeRateLimit},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, ErrorTypeAPI},
+		{NewProxyError(http.StatusUnauthorized, ErrorTypeAuthentication, "invalid api key"), http.StatusUnauthorized, ErrorTypeAuthentication},
+		{errors.New("unknown"), http.StatusInternalServerError, ErrorTypeAPI},
+	}
+
+	for _, c := range cases {
+		proxyErr := AsProxyError(c.err)
+		if proxyErr.Status != c.status || proxyErr.Type != c.errorType {
+			t.Errorf("%v: got %d %s, want %d %s", c.err, proxyErr.Status, proxyErr.Type, c.status, c.errorType)
+		}
+	}
+	t.Log("PASS")
+}
+
+func TestHTTPService_ResponseError(t *testing.T) {
+	service := &HTTPService{}
+	recorder := httptest.NewRecorder()
+
+	service.ResponseError(&types.ThrottlingException{Message: aws.String("Too many requests")}, recorder)
+
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status: %d", recorder.Code)
+	}
+	var body APIStandardError
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != "error" || body.Error == nil || body.Error.Type != ErrorTypeRateLimit || body.Error.Message != "Too many requests" {
+		t.Fatalf("unexpected body: %s", recorder.Body.String())
+	}
+	t.Log("PASS")
+}
+
+func TestNewStreamErrorEvent(t *testing.T) {
+	event := NewStreamErrorEvent(&types.ModelStreamErrorException{Message: aws.String("stream failed")})
+
+	raw := string(NewSSERaw(event))
+	expected := "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"api_error\",\"message\":\"stream failed\"}}\n\n"
+	if raw != expected {
+		t.Fatalf("unexpected SSE frame: %q", raw)
+	}
+	t.Log("PASS")
+}
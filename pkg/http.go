@@ -3,12 +3,16 @@ package pkg
 import (
 	"bedrock-claude-proxy/api"
 	"bedrock-claude-proxy/models"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 
 	log "bedrock-claude-proxy/log"
 
@@ -22,13 +26,34 @@ type HttpConfig struct {
 	WebRoot string `json:"web_root,omitempty"`
 	APIKey  string `json:"api_key,omitempty"`
 	DBPath  string `json:"db_path,omitempty"`
+	// API Key 缓存的有效期和跨实例失效的轮询间隔，单位秒
+	APIKeyCacheTTL             int `json:"apikey_cache_ttl,omitempty"`
+	APIKeyInvalidationInterval int `json:"apikey_invalidation_interval,omitempty"`
+	// 允许通过 URL 参数 api_key 传递 API Key，用于无法设置请求头的浏览器 SSE 客户端
+	AllowQueryAPIKey bool `json:"allow_query_api_key,omitempty"`
+	// 管理员 token 的签名密钥（kid -> 密钥）、当前使用的 kid 和有效期（秒）
+	AdminJWTKeys         map[string]string `json:"admin_jwt_keys,omitempty"`
+	AdminJWTKid          string            `json:"admin_jwt_kid,omitempty"`
+	AdminAccessTokenTTL  int               `json:"admin_access_token_ttl,omitempty"`
+	AdminRefreshTokenTTL int               `json:"admin_refresh_token_ttl,omitempty"`
+	// 管理员登录失败的限制：同一用户名和同一 IP 的失败次数，以及锁定时长（秒）
+	AdminLoginMaxFailures   int `json:"admin_login_max_failures,omitempty"`
+	AdminLoginMaxIPFailures int `json:"admin_login_max_ip_failures,omitempty"`
+	AdminLoginLockout       int `json:"admin_login_lockout,omitempty"`
+	// 可信的反向代理地址（逗号分隔的 IP 或 CIDR），来自这些地址的请求按 X-Forwarded-For 识别管理员的客户端 IP
+	AdminTrustedProxies string `json:"admin_trusted_proxies,omitempty"`
 }
 
 type HTTPService struct {
 	conf        *Config
 	db          *gorm.DB
-	apiKeyCache map[string]*models.APIKey
-	cacheMutex  sync.RWMutex
+	bedrock     *BedrockClient
+	apiKeys     *APIKeyCache
+	jwt         *api.JWTManager
+	logins      *api.LoginThrottler
+	proxies     api.TrustedProxies
+	rateLimiter *RateLimiter
+	budgets     *BudgetTracker
 }
 
 type APIError struct {
@@ -65,10 +90,50 @@ func NewHttpService(conf *Config) *HTTPService {
 		log.Logger.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	cacheTTL := DefaultAPIKeyCacheTTL
+	if conf.APIKeyCacheTTL > 0 {
+		cacheTTL = time.Duration(conf.APIKeyCacheTTL) * time.Second
+	}
+	invalidationInterval := DefaultAPIKeyInvalidationInterval
+	if conf.APIKeyInvalidationInterval > 0 {
+		invalidationInterval = time.Duration(conf.APIKeyInvalidationInterval) * time.Second
+	}
+	loadAPIKey := func(value string) (*models.APIKey, error) {
+		apiKey, err := models.GetAPIKey(db, value)
+		if err != nil {
+			return nil, err
+		}
+		return &apiKey, nil
+	}
+
+	jwtManager, err := api.NewJWTManager(api.JWTConfig{
+		Keys:            conf.AdminJWTKeys,
+		Kid:             conf.AdminJWTKid,
+		AccessTokenTTL:  time.Duration(conf.AdminAccessTokenTTL) * time.Second,
+		RefreshTokenTTL: time.Duration(conf.AdminRefreshTokenTTL) * time.Second,
+	})
+	if err != nil {
+		log.Logger.Fatalf("Failed to initialize admin JWT: %v", err)
+	}
+	proxies, err := api.ParseTrustedProxies(conf.AdminTrustedProxies)
+	if err != nil {
+		log.Logger.Fatalf("Failed to parse admin trusted proxies: %v", err)
+	}
+
 	service := &HTTPService{
-		conf:        conf,
+		conf: conf,
+		jwt:  jwtManager,
+		logins: api.NewLoginThrottler(api.LoginThrottleConfig{
+			MaxFailures:   conf.AdminLoginMaxFailures,
+			MaxIPFailures: conf.AdminLoginMaxIPFailures,
+			Lockout:       time.Duration(conf.AdminLoginLockout) * time.Second,
+		}),
+		proxies:     proxies,
 		db:          db,
-		apiKeyCache: make(map[string]*models.APIKey),
+		bedrock:     NewBedrockClient(conf.BedrockConfig),
+		apiKeys:     NewAPIKeyCache(cacheTTL, loadAPIKey, NewDBAPIKeyInvalidator(db, invalidationInterval)),
+		rateLimiter: NewRateLimiter(),
+		budgets:     NewBudgetTracker(db),
 	}
 
 	return service
@@ -79,21 +144,22 @@ func (this *HTTPService) RedirectSwagger(writer http.ResponseWriter, request *ht
 }
 
 func (this *HTTPService) NotFoundHandle(writer http.ResponseWriter, request *http.Request) {
-	server_error := &APIStandardError{Type: "error", Error: &APIError{
-		Type:    "error",
-		Message: "not found",
-	}}
-	json_str, _ := json.Marshal(server_error)
-	http.Error(writer, string(json_str), 404)
+	this.ResponseError(NewProxyError(http.StatusNotFound, ErrorTypeNotFound, "not found"), writer)
 }
 
+// ResponseError 按 Anthropic 的错误格式输出错误，HTTP 状态码与错误类型由 AsProxyError 决定
 func (this *HTTPService) ResponseError(err error, writer http.ResponseWriter) {
+	proxyErr := AsProxyError(err)
 	server_error := &APIStandardError{Type: "error", Error: &APIError{
-		Type:    "invalid_request_error",
-		Message: err.Error(),
+		Type:    proxyErr.Type,
+		Message: proxyErr.Message,
 	}}
 	json_str, _ := json.Marshal(server_error)
-	http.Error(writer, string(json_str), 200)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(proxyErr.Status)
+	writer.Write(json_str)
 }
 
 func (this *HTTPService) ResponseJSON(source interface{}, writer http.ResponseWriter) {
@@ -131,11 +197,11 @@ func (this *HTTPService) ResponseSSE(writer http.ResponseWriter, queue <-chan IS
 
 func (this *HTTPService) HandleComplete(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != "POST" {
-		this.ResponseError(fmt.Errorf("method not allowed"), writer)
+		this.ResponseError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
 		return
 	}
 	if request.Header.Get("Content-Type") != "application/json" {
-		this.ResponseError(fmt.Errorf("invalid content type"), writer)
+		this.ResponseError(NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid content type"), writer)
 		return
 	}
 	defer request.Body.Close()
@@ -143,15 +209,19 @@ func (this *HTTPService) HandleComplete(writer http.ResponseWriter, request *htt
 	var req *ClaudeTextCompletionRequest
 	err := json.NewDecoder(request.Body).Decode(&req)
 	if err != nil {
-		this.ResponseError(err, writer)
+		this.ResponseError(NewInvalidRequestError(err), writer)
 		return
 	}
 	// get anthropic-version,x-api-key from request
 	//anthropicVersion := request.Header.Get("anthropic-version")
 	//anthropicKey := request.Header.Get("x-api-key")
 
-	bedrockClient := NewBedrockClient(this.conf.BedrockConfig)
-	response, err := bedrockClient.CompleteText(req)
+	if err := this.authorizeModel(request, req.Model); err != nil {
+		this.ResponseError(err, writer)
+		return
+	}
+
+	response, err := this.bedrock.CompleteText(request.Context(), req)
 	if err != nil {
 		this.ResponseError(err, writer)
 		return
@@ -168,7 +238,7 @@ func (this *HTTPService) HandleComplete(writer http.ResponseWriter, request *htt
 		writer.Header().Set("Cache-Control", "no-cache")
 		writer.Header().Set("Connection", "keep-alive")
 
-		for event := range response.GetEvents() {
+		for event := range this.trackTextStreamUsage(request, req, response.GetEvents()) {
 			_, err = writer.Write(NewSSERaw(event))
 			if err != nil {
 				log.Logger.Error(err)
@@ -179,25 +249,32 @@ func (this *HTTPService) HandleComplete(writer http.ResponseWriter, request *htt
 		return
 	}
 
+	if resp, ok := response.GetResponse().(*ClaudeTextCompletionResponse); ok && resp.Usage != nil {
+		log.Logger.Infof("Usage - Input Tokens: %d, Output Tokens: %d",
+			resp.Usage.InputTokens, resp.Usage.OutputTokens)
+
+		this.recordUsage(request, req.Model, resp.Usage.InputTokens, resp.Usage.OutputTokens)
+	}
+
 	this.ResponseJSON(response.GetResponse(), writer)
 }
 
 func (this *HTTPService) HandleMessageComplete(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != "POST" {
 		log.Logger.Errorf("Method not allowed: %s", request.Method)
-		this.ResponseError(fmt.Errorf("method not allowed"), writer)
+		this.ResponseError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
 		return
 	}
 	if request.Header.Get("Content-Type") != "application/json" {
 		log.Logger.Errorf("Invalid content type: %s", request.Header.Get("Content-Type"))
-		this.ResponseError(fmt.Errorf("invalid content type"), writer)
+		this.ResponseError(NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest, "invalid content type"), writer)
 		return
 	}
 	// 读取请求 body
 	body, err := io.ReadAll(request.Body)
 	if err != nil {
 		log.Logger.Error(err)
-		this.ResponseError(fmt.Errorf("Error reading request body"), writer)
+		this.ResponseError(NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest, "Error reading request body"), writer)
 		return
 	}
 	defer request.Body.Close()
@@ -207,7 +284,7 @@ func (this *HTTPService) HandleMessageComplete(writer http.ResponseWriter, reque
 	err = json.Unmarshal(body, &req)
 	if err != nil {
 		log.Logger.Error(err)
-		this.ResponseError(err, writer)
+		this.ResponseError(NewInvalidRequestError(err), writer)
 		return
 	}
 	// fmt.Printf("Request: %+v", req)
@@ -223,71 +300,20 @@ func (this *HTTPService) HandleMessageComplete(writer http.ResponseWriter, reque
 		log.Logger.Debugf("%+v", msg)
 	}
 
-	bedrockClient := NewBedrockClient(this.conf.BedrockConfig)
-	response, err := bedrockClient.MessageCompletion(&req)
+	if err := this.authorizeModel(request, req.Model); err != nil {
+		this.ResponseError(err, writer)
+		return
+	}
+
+	response, err := this.bedrock.MessageCompletion(request.Context(), &req)
 	if err != nil {
 		this.ResponseError(err, writer)
 		return
 	}
 
 	if response.IsStream() {
-		// 创建一个新的通道来拦截事件
-		eventQueue := make(chan ISSEDecoder, 10)
-		go func() {
-			defer close(eventQueue)
-
-			// var lastEvent *ClaudeMessageCompletionStreamEvent
-			var inputTokens, outputTokens int
-			var usageRecorded bool = false
-
-			// 从原始通道读取事件
-			for event := range response.GetEvents() {
-				// 传递给新通道
-				eventQueue <- event
-
-				// 尝试将事件转换为特定类型以检查 usage 信息
-				if streamEvent, ok := event.(*ClaudeMessageCompletionStreamEvent); ok {
-					// lastEvent = streamEvent
-					eventType := streamEvent.GetEvent()
-
-					// 收集输入和输出token信息
-					if eventType == "message_start" && streamEvent.Message != nil && streamEvent.Message.Usage != nil {
-						inputTokens = streamEvent.Message.Usage.InputTokens
-						log.Logger.Infof("Stream Usage - Input Tokens: %d", inputTokens)
-					} else if eventType == "message_delta" && streamEvent.Usage != nil {
-						if streamEvent.Usage.OutputTokens > outputTokens {
-							outputTokens = streamEvent.Usage.OutputTokens
-						}
-						log.Logger.Infof("Stream Usage - Output Tokens: %d", outputTokens)
-					} else if (eventType == "message_stop" || eventType == "content_block_stop") && !usageRecorded &&
-						inputTokens > 0 && outputTokens > 0 {
-						// 记录API使用情况
-						apiKeyValue := request.Header.Get("x-api-key")
-						apiKeyName := "default"
-
-						// 查询API密钥名称 - 使用缓存
-						if apiKeyValue != "" {
-							if apiKey, err := this.getAPIKeyFromCache(apiKeyValue); err == nil {
-								apiKeyName = apiKey.Name
-							}
-						}
-
-						// 记录使用情况
-						quota := int(float64(inputTokens)*ModelMetaMap[req.Model].ModelRatio + float64(outputTokens)*ModelMetaMap[req.Model].CompletionRatio)
-						if err := models.CreateUsage(this.db, apiKeyName, apiKeyValue, req.Model,
-							inputTokens, outputTokens, quota); err != nil {
-							log.Logger.Errorf("Failed to log API usage: %v", err)
-						} else {
-							usageRecorded = true
-							log.Logger.Infof("API usage recorded - Input: %d, Output: %d, Quota: %d", inputTokens, outputTokens, quota)
-						}
-					}
-				}
-			}
-		}()
-
 		// 使用拦截后的通道进行响应
-		this.ResponseSSE(writer, eventQueue)
+		this.ResponseSSE(writer, this.trackStreamUsage(request, req.Model, response.GetEvents()))
 		return
 	}
 
@@ -296,26 +322,402 @@ func (this *HTTPService) HandleMessageComplete(writer http.ResponseWriter, reque
 		log.Logger.Infof("Usage - Input Tokens: %d, Output Tokens: %d",
 			resp.Usage.InputTokens, resp.Usage.OutputTokens)
 
-		// 记录API使用情况
-		apiKeyValue := request.Header.Get("x-api-key")
-		apiKeyName := "default"
+		this.recordUsage(request, resp.Model, resp.Usage.InputTokens, resp.Usage.OutputTokens)
+	}
+
+	this.ResponseJSON(response.GetResponse(), writer)
+}
+
+// HandleCountTokens 统计 Messages 请求的输入 token 数量（/v1/messages/count_tokens）
+func (this *HTTPService) HandleCountTokens(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		this.ResponseError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
+		return
+	}
+	defer request.Body.Close()
+
+	var req ClaudeMessageCompletionRequest
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		log.Logger.Error(err)
+		this.ResponseError(NewInvalidRequestError(err), writer)
+		return
+	}
+	anthropicVersion := request.Header.Get("anthropic-version")
+	if len(anthropicVersion) > 0 {
+		req.AnthropicVersion = anthropicVersion
+	}
+
+	if err := this.authorizeModel(request, req.Model); err != nil {
+		this.ResponseError(err, writer)
+		return
+	}
+
+	inputTokens, err := this.bedrock.CountTokens(request.Context(), &req)
+	if err != nil {
+		this.ResponseError(err, writer)
+		return
+	}
+
+	this.ResponseJSON(&ClaudeCountTokensResponse{InputTokens: inputTokens}, writer)
+}
+
+// HandleListModels 列出可用模型（/v1/models），支持 before_id、after_id、limit 分页参数
+func (this *HTTPService) HandleListModels(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		this.ResponseError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
+		return
+	}
+
+	list := this.filterAllowedModels(request, ListModels(this.conf.BedrockConfig))
+
+	limit := 20
+	if limitParam := request.URL.Query().Get("limit"); limitParam != "" {
+		l, err := strconv.Atoi(limitParam)
+		if err != nil || l < 1 || l > 1000 {
+			this.ResponseError(NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest, "limit must be between 1 and 1000"), writer)
+			return
+		}
+		limit = l
+	}
+
+	data, hasMore := PaginateModels(list, request.URL.Query().Get("before_id"), request.URL.Query().Get("after_id"), limit)
+	response := &ModelListResponse{
+		Data:    data,
+		HasMore: hasMore,
+	}
+	if len(response.Data) > 0 {
+		response.FirstId = &response.Data[0].Id
+		response.LastId = &response.Data[len(response.Data)-1].Id
+	}
+
+	this.ResponseJSON(response, writer)
+}
+
+// HandleGetModel 获取单个模型信息（/v1/models/{id}）
+func (this *HTTPService) HandleGetModel(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		this.ResponseError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
+		return
+	}
+
+	id := mux.Vars(request)["id"]
+	for _, model := range this.filterAllowedModels(request, ListModels(this.conf.BedrockConfig)) {
+		if model.Id == id {
+			this.ResponseJSON(model, writer)
+			return
+		}
+	}
+
+	this.ResponseError(NewProxyError(http.StatusNotFound, ErrorTypeNotFound, fmt.Sprintf("model: %s", id)), writer)
+}
+
+// ResponseOpenAIError 按 OpenAI 的错误格式输出错误
+func (this *HTTPService) ResponseOpenAIError(err error, writer http.ResponseWriter) {
+	status, openAIErr := NewOpenAIError(err)
+	json_str, _ := json.Marshal(openAIErr)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(status)
+	writer.Write(json_str)
+}
+
+// HandleChatCompletions OpenAI 兼容的 Chat Completions 接口（/v1/chat/completions）
+func (this *HTTPService) HandleChatCompletions(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		this.ResponseOpenAIError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
+		return
+	}
+	defer request.Body.Close()
+
+	var openAIReq OpenAIChatCompletionRequest
+	err := json.NewDecoder(request.Body).Decode(&openAIReq)
+	if err != nil {
+		this.ResponseOpenAIError(NewInvalidRequestError(err), writer)
+		return
+	}
+
+	req, err := openAIReq.ToClaudeRequest()
+	if err != nil {
+		this.ResponseOpenAIError(err, writer)
+		return
+	}
+
+	if err := this.authorizeModel(request, req.Model); err != nil {
+		this.ResponseOpenAIError(err, writer)
+		return
+	}
+
+	response, err := this.bedrock.MessageCompletion(request.Context(), req)
+	if err != nil {
+		this.ResponseOpenAIError(err, writer)
+		return
+	}
+
+	if response.IsStream() {
+		includeUsage := openAIReq.StreamOptions != nil && openAIReq.StreamOptions.IncludeUsage
+		events := this.trackStreamUsage(request, req.Model, response.GetEvents())
+		this.ResponseSSE(writer, ConvertOpenAIStream(req.Model, includeUsage, events))
+		return
+	}
+
+	resp, ok := response.GetResponse().(*ClaudeMessageCompletionResponse)
+	if !ok {
+		this.ResponseOpenAIError(fmt.Errorf("unexpected response type"), writer)
+		return
+	}
+	if resp.Usage != nil {
+		this.recordUsage(request, req.Model, resp.Usage.InputTokens, resp.Usage.OutputTokens)
+	}
+
+	this.ResponseJSON(NewOpenAIChatCompletionResponse(req.Model, resp), writer)
+}
+
+// HandleEmbeddings OpenAI 兼容的 Embeddings 接口（/v1/embeddings）
+func (this *HTTPService) HandleEmbeddings(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		this.ResponseOpenAIError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
+		return
+	}
+	defer request.Body.Close()
+
+	var req OpenAIEmbeddingRequest
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		this.ResponseOpenAIError(NewInvalidRequestError(err), writer)
+		return
+	}
+
+	if err := this.authorizeModel(request, req.Model); err != nil {
+		this.ResponseOpenAIError(err, writer)
+		return
+	}
+
+	resp, err := this.bedrock.Embeddings(request.Context(), &req)
+	if err != nil {
+		this.ResponseOpenAIError(err, writer)
+		return
+	}
+	this.recordUsage(request, req.Model, resp.Usage.PromptTokens, 0)
+
+	this.ResponseJSON(resp, writer)
+}
+
+// HandleRerank Cohere / Jina 兼容的 Rerank 接口（/v1/rerank）
+func (this *HTTPService) HandleRerank(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		this.ResponseError(NewProxyError(http.StatusMethodNotAllowed, ErrorTypeInvalidRequest, "method not allowed"), writer)
+		return
+	}
+	defer request.Body.Close()
+
+	var req RerankRequest
+	err := json.NewDecoder(request.Body).Decode(&req)
+	if err != nil {
+		this.ResponseError(NewInvalidRequestError(err), writer)
+		return
+	}
+
+	if len(req.Model) == 0 {
+		req.Model = DefaultRerankModel
+	}
+	if err := this.authorizeModel(request, req.Model); err != nil {
+		this.ResponseError(err, writer)
+		return
+	}
+
+	resp, err := this.bedrock.Rerank(request.Context(), &req)
+	if err != nil {
+		this.ResponseError(err, writer)
+		return
+	}
+	this.recordSearchUsage(request, req.Model, resp.Meta.BilledUnits.SearchUnits)
+
+	this.ResponseJSON(resp, writer)
+}
+
+// trackStreamUsage 拦截流式事件并统计 token 用量。
+// 正常结束时在 message_stop 记录用量；流中途出错或客户端断开时记录已产生的部分用量，
+// 此时若尚未收到 message_delta，输出 token 按已输出的文本、思考过程和工具参数估算。
+func (this *HTTPService) trackStreamUsage(request *http.Request, model string, events <-chan ISSEDecoder) <-chan ISSEDecoder {
+	// 创建一个新的通道来拦截事件
+	eventQueue := make(chan ISSEDecoder, 10)
+	go func() {
+		defer close(eventQueue)
+
+		var inputTokens, outputTokens int
+		var output strings.Builder
+		var usageRecorded bool = false
+
+		record := func() {
+			if usageRecorded || inputTokens <= 0 {
+				return
+			}
+			if outputTokens <= 0 {
+				outputTokens = EstimateTokens(output.String())
+			}
+			this.recordUsage(request, model, inputTokens, outputTokens)
+			usageRecorded = true
+		}
+
+		// 从原始通道读取事件
+		for event := range events {
+			// 传递给新通道
+			eventQueue <- event
+
+			// 尝试将事件转换为特定类型以检查 usage 信息
+			streamEvent, ok := event.(*ClaudeMessageCompletionStreamEvent)
+			if !ok {
+				continue
+			}
+
+			// 收集输入和输出token信息
+			switch streamEvent.GetEvent() {
+			case "message_start":
+				if streamEvent.Message != nil && streamEvent.Message.Usage != nil {
+					inputTokens = streamEvent.Message.Usage.InputTokens
+					log.Logger.Infof("Stream Usage - Input Tokens: %d", inputTokens)
+				}
+			case "content_block_delta":
+				if delta := streamEvent.Delta; delta != nil {
+					output.WriteString(delta.Text)
+					output.WriteString(delta.Thinking)
+					output.WriteString(delta.PartialJson)
+				}
+			case "message_delta":
+				if streamEvent.Usage != nil {
+					// Converse 后端的输入 token 在 message_delta 中返回，覆盖 message_start 中的本地估算值
+					if streamEvent.Usage.InputTokens > 0 {
+						inputTokens = streamEvent.Usage.InputTokens
+					}
+					if streamEvent.Usage.OutputTokens > outputTokens {
+						outputTokens = streamEvent.Usage.OutputTokens
+					}
+					log.Logger.Infof("Stream Usage - Output Tokens: %d", outputTokens)
+				}
+			case "message_stop":
+				record()
+			case "error":
+				log.Logger.Warningf("Stream error: %s", string(streamEvent.GetBytes()))
+				record()
+			}
+		}
+
+		// 流被中断（例如客户端断开）时记录部分用量
+		record()
+	}()
+
+	return eventQueue
+}
+
+// trackTextStreamUsage 拦截 Text Completions 的流式事件，结束时记录用量。
+// 优先使用 Bedrock 在最后一个事件中返回的 token 数量，没有时按 prompt 与已输出的文本估算
+func (this *HTTPService) trackTextStreamUsage(request *http.Request, req *ClaudeTextCompletionRequest, events <-chan ISSEDecoder) <-chan ISSEDecoder {
+	eventQueue := make(chan ISSEDecoder, 10)
+	go func() {
+		defer close(eventQueue)
+
+		var completion strings.Builder
+		var metrics *BedrockInvocationMetrics
+		for event := range events {
+			eventQueue <- event
 
-		// 查询API密钥名称 - 使用缓存
-		if apiKeyValue != "" {
-			if apiKey, err := this.getAPIKeyFromCache(apiKeyValue); err == nil {
-				apiKeyName = apiKey.Name
+			streamEvent, ok := event.(*ClaudeTextCompletionStreamEvent)
+			if !ok {
+				continue
+			}
+			completion.WriteString(streamEvent.Completion)
+			if streamEvent.InvocationMetrics != nil {
+				metrics = streamEvent.InvocationMetrics
 			}
 		}
 
-		// 记录使用情况
-		quota := int(float64(resp.Usage.InputTokens)*ModelMetaMap[resp.Model].ModelRatio + float64(resp.Usage.OutputTokens)*ModelMetaMap[resp.Model].CompletionRatio)
-		if err := models.CreateUsage(this.db, apiKeyName, apiKeyValue, resp.Model,
-			resp.Usage.InputTokens, resp.Usage.OutputTokens, quota); err != nil {
-			log.Logger.Errorf("Failed to log API usage: %v", err)
+		// 流被中断（例如客户端断开）时按已输出的部分估算
+		inputTokens, outputTokens := EstimateTokens(req.Prompt), EstimateTokens(completion.String())
+		if metrics != nil {
+			inputTokens, outputTokens = metrics.InputTokenCount, metrics.OutputTokenCount
 		}
+		log.Logger.Infof("Stream Usage - Input Tokens: %d, Output Tokens: %d", inputTokens, outputTokens)
+		this.recordUsage(request, req.Model, inputTokens, outputTokens)
+	}()
+
+	return eventQueue
+}
+
+// usageAPIKey 返回记录用量使用的 API Key 名称与密钥前缀
+func (this *HTTPService) usageAPIKey(request *http.Request) (string, string) {
+	if apiKey, ok := api.GetAPIKey(request.Context()); ok {
+		return apiKey.Name, apiKey.Prefix
 	}
 
-	this.ResponseJSON(response.GetResponse(), writer)
+	apiKeyValue := this.extractAPIKey(request)
+	apiKeyName := "default"
+
+	// 查询API密钥名称 - 使用缓存
+	if apiKeyValue != "" {
+		if apiKey, err := this.apiKeys.Get(apiKeyValue); err == nil {
+			apiKeyName = apiKey.Name
+		}
+	}
+	return apiKeyName, models.APIKeyDisplayPrefix(apiKeyValue)
+}
+
+// addBudgetSpend 将本次消耗的额度累加到预算缓存
+func (this *HTTPService) addBudgetSpend(request *http.Request, quota int) {
+	if apiKey, ok := api.GetAPIKey(request.Context()); ok {
+		this.budgets.Add(apiKey, quota)
+	}
+}
+
+// recordSearchUsage 记录按搜索单元计费的 API 使用情况
+func (this *HTTPService) recordSearchUsage(request *http.Request, model string, searchUnits int) {
+	apiKeyName, apiKeyValue := this.usageAPIKey(request)
+
+	quota := searchUnits * RerankQuotaPerSearchUnit
+	if err := models.CreateSearchUsage(this.db, apiKeyName, apiKeyValue, model, searchUnits, quota); err != nil {
+		log.Logger.Errorf("Failed to log API usage: %v", err)
+		return
+	}
+	this.addBudgetSpend(request, quota)
+	log.Logger.Infof("API usage recorded - Search Units: %d, Quota: %d", searchUnits, quota)
+}
+
+// recordUsage 记录 API 使用情况
+func (this *HTTPService) recordUsage(request *http.Request, model string, inputTokens, outputTokens int) {
+	apiKeyName, apiKeyValue := this.usageAPIKey(request)
+
+	// 按实际用量校正速率限制
+	this.rateLimiter.Reconcile(getRateLimitReservation(request.Context()), inputTokens, outputTokens)
+
+	// 记录使用情况
+	meta, ok := this.bedrock.LookupModelMeta(model)
+	if !ok {
+		log.Logger.Warningf("Model %s has no price, usage recorded with zero quota", model)
+	}
+	quota := int(float64(inputTokens)*meta.ModelRatio + float64(outputTokens)*meta.CompletionRatio)
+	if err := models.CreateUsage(this.db, apiKeyName, apiKeyValue, model,
+		inputTokens, outputTokens, quota); err != nil {
+		log.Logger.Errorf("Failed to log API usage: %v", err)
+		return
+	}
+	this.addBudgetSpend(request, quota)
+	log.Logger.Infof("API usage recorded - Input: %d, Output: %d, Quota: %d", inputTokens, outputTokens, quota)
+}
+
+// extractAPIKey 从请求中读取 API Key，依次支持 x-api-key 请求头、Authorization: Bearer 请求头，
+// 以及开启 AllowQueryAPIKey 时的 api_key URL 参数
+func (this *HTTPService) extractAPIKey(request *http.Request) string {
+	if apiKeyValue := request.Header.Get("x-api-key"); apiKeyValue != "" {
+		return apiKeyValue
+	}
+	if authHeader := request.Header.Get("Authorization"); len(authHeader) > 7 && strings.EqualFold(authHeader[:7], "Bearer ") {
+		return strings.TrimSpace(authHeader[7:])
+	}
+	if this.conf != nil && this.conf.AllowQueryAPIKey {
+		return request.URL.Query().Get("api_key")
+	}
+	return ""
 }
 
 // APIKeyMiddleware 验证 API Key 的中间件
@@ -323,66 +725,138 @@ func (this *HTTPService) APIKeyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		log.Logger.Infof("Request URL Path: %s", request.URL.Path)
 
-		apiKeyValue := request.Header.Get("x-api-key")
+		apiKeyValue := this.extractAPIKey(request)
 		if apiKeyValue == "" {
-			this.ResponseError(fmt.Errorf("invalid api key"), writer)
+			this.ResponseError(NewProxyError(http.StatusUnauthorized, ErrorTypeAuthentication, "invalid api key"), writer)
 			return
 		}
 
 		// 使用缓存检查API Key
-		apiKey, err := this.getAPIKeyFromCache(apiKeyValue)
+		apiKey, err := this.authenticateAPIKey(apiKeyValue)
 		if err != nil {
-			this.ResponseError(fmt.Errorf("invalid api key"), writer)
+			this.ResponseError(err, writer)
 			return
 		}
 
-		// 这里可以添加更多的 API Key 验证逻辑
-		if apiKey.Value != apiKeyValue {
-			this.ResponseError(fmt.Errorf("invalid api key"), writer)
+		// 预算检查
+		budgetStatus, err := this.budgets.Check(apiKey)
+		if err != nil {
+			log.Logger.Errorf("Failed to check budget for api key %s: %v", apiKey.Name, err)
+			this.ResponseError(err, writer)
 			return
 		}
+		switch budgetStatus {
+		case BudgetStatusHardLimitExceeded:
+			log.Logger.Warningf("Budget hard limit exceeded for api key %s", apiKey.Name)
+			this.ResponseError(NewBudgetExceededError(), writer)
+			return
+		case BudgetStatusSoftLimitExceeded:
+			log.Logger.Warningf("Budget soft limit exceeded for api key %s", apiKey.Name)
+		}
 
-		next.ServeHTTP(writer, request)
-	})
-}
+		ctx := api.SetAPIKey(request.Context(), apiKey)
+
+		// 速率限制
+		if apiKey.RPM > 0 || apiKey.InputTPM > 0 || apiKey.OutputTPM > 0 {
+			estimatedTokens := 0
+			if apiKey.InputTPM > 0 && request.Body != nil {
+				body, err := io.ReadAll(request.Body)
+				request.Body.Close()
+				if err != nil {
+					this.ResponseError(NewProxyError(http.StatusBadRequest, ErrorTypeInvalidRequest, "Error reading request body"), writer)
+					return
+				}
+				request.Body = io.NopCloser(bytes.NewReader(body))
+				estimatedTokens = EstimateRequestTokens(request.URL.Path, body)
+			}
 
-// getAPIKeyFromCache 从缓存中获取API Key，如果不存在则从数据库中获取并缓存
-func (this *HTTPService) getAPIKeyFromCache(value string) (*models.APIKey, error) {
-	// 尝试从缓存中读取
-	this.cacheMutex.RLock()
-	cachedKey, exists := this.apiKeyCache[value]
-	this.cacheMutex.RUnlock()
+			result, reservation := this.rateLimiter.Acquire(apiKey, estimatedTokens)
+			result.SetHeaders(writer.Header())
+			if !result.Allowed {
+				log.Logger.Warningf("Rate limit exceeded for api key %s, retry after %s", apiKey.Name, result.RetryAfter)
+				this.ResponseError(NewProxyError(http.StatusTooManyRequests, ErrorTypeRateLimit,
+					"rate limit exceeded for this api key"), writer)
+				return
+			}
+			// 请求未记录用量（例如 Bedrock 调用失败）时退回预扣的输入 token
+			defer this.rateLimiter.Reconcile(reservation, 0, 0)
+			ctx = withRateLimitReservation(ctx, reservation)
+		}
 
-	// 如果存在，直接返回
-	if exists {
-		return cachedKey, nil
-	}
+		next.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
 
-	// 缓存不存在，从数据库获取
-	apiKey, err := models.GetAPIKey(this.db, value)
+// authenticateAPIKey 验证密钥明文，拒绝已过期的密钥（包括超过宽限期的旧密钥）
+func (this *HTTPService) authenticateAPIKey(value string) (*models.APIKey, error) {
+	apiKey, err := this.apiKeys.Get(value)
 	if err != nil {
-		return nil, err
+		return nil, NewProxyError(http.StatusUnauthorized, ErrorTypeAuthentication, "invalid api key")
+	}
+	if apiKey.IsExpired(value, time.Now()) {
+		log.Logger.Warningf("Expired api key used: %s", apiKey.Name)
+		return nil, NewProxyError(http.StatusUnauthorized, ErrorTypeAuthentication, "api key has expired")
 	}
+	return apiKey, nil
+}
 
-	// 更新缓存
-	this.cacheMutex.Lock()
-	this.apiKeyCache[value] = &apiKey
-	this.cacheMutex.Unlock()
+func (this *HTTPService) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
+	handler := api.AdminLogin(this.db, this.jwt, this.logins)
+	handler(w, r)
+}
 
-	return &apiKey, nil
+func (this *HTTPService) HandleAdminRefreshToken(w http.ResponseWriter, r *http.Request) {
+	handler := api.AdminRefreshToken(this.db, this.jwt)
+	handler(w, r)
 }
 
-// 从缓存中移除API Key
-func (this *HTTPService) refreshAPIKeyCache(value string) {
-	this.cacheMutex.Lock()
-	defer this.cacheMutex.Unlock()
+func (this *HTTPService) HandleChangeAdminPassword(w http.ResponseWriter, r *http.Request) {
+	handler := api.ChangeAdminPassword(this.db)
+	handler(w, r)
+}
 
-	// 从缓存中删除该API Key，下次请求时会重新从数据库加载
-	delete(this.apiKeyCache, value)
+func (this *HTTPService) HandleEnrollAdminTOTP(w http.ResponseWriter, r *http.Request) {
+	handler := api.EnrollAdminTOTP(this.db)
+	handler(w, r)
 }
 
-func (this *HTTPService) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
-	handler := api.AdminLogin(this.db)
+func (this *HTTPService) HandleConfirmAdminTOTP(w http.ResponseWriter, r *http.Request) {
+	handler := api.ConfirmAdminTOTP(this.db)
+	handler(w, r)
+}
+
+func (this *HTTPService) HandleDisableAdminTOTP(w http.ResponseWriter, r *http.Request) {
+	handler := api.DisableAdminTOTP(this.db)
+	handler(w, r)
+}
+
+func (this *HTTPService) HandleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	handler := api.AdminLogout(this.db, this.jwt)
+	handler(w, r)
+}
+
+func (this *HTTPService) ListAdminUsers(w http.ResponseWriter, r *http.Request) {
+	handler := api.ListAdminUsers(this.db)
+	handler(w, r)
+}
+
+func (this *HTTPService) CreateAdminUser(w http.ResponseWriter, r *http.Request) {
+	handler := api.CreateAdminUser(this.db)
+	handler(w, r)
+}
+
+func (this *HTTPService) UpdateAdminUser(w http.ResponseWriter, r *http.Request) {
+	handler := api.UpdateAdminUser(this.db)
+	handler(w, r)
+}
+
+func (this *HTTPService) DeleteAdminUser(w http.ResponseWriter, r *http.Request) {
+	handler := api.DeleteAdminUser(this.db)
+	handler(w, r)
+}
+
+func (this *HTTPService) ListAuditLogs(w http.ResponseWriter, r *http.Request) {
+	handler := api.ListAuditLogs(this.db)
 	handler(w, r)
 }
 
@@ -391,29 +865,48 @@ func (this *HTTPService) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 	handler(w, r)
 }
 
-func (this *HTTPService) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	// 先获取要删除的API Key值
-	var apiKey models.APIKey
-	if err := this.db.First(&apiKey, id).Error; err == nil {
-		// 记住API Key值
-		keyValue := apiKey.Value
+// apiKeyIdFromRequest 在管理接口执行前解析要修改的 API Key ID，来自 URL 中的 id 或请求体中的 name
+func (this *HTTPService) apiKeyIdFromRequest(r *http.Request) (uint, bool) {
+	if id := mux.Vars(r)["id"]; id != "" {
+		apiKeyId, err := strconv.ParseUint(id, 10, 64)
+		return uint(apiKeyId), err == nil
+	}
+	if r.Body == nil {
+		return 0, false
+	}
 
-		// 执行删除
-		handler := api.DeleteAPIKey(this.db)
-		handler(w, r)
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return 0, false
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Name == "" {
+		return 0, false
+	}
+	apiKey, err := models.GetAPIKeyByName(this.db, req.Name)
+	if err != nil {
+		return 0, false
+	}
+	return apiKey.ID, true
+}
 
-		// 从缓存中移除
-		this.refreshAPIKeyCache(keyValue)
-	} else {
-		// 如果找不到API Key，仍然执行原始处理程序
-		handler := api.DeleteAPIKey(this.db)
-		handler(w, r)
+// mutateAPIKey 执行修改 API Key 的管理接口，完成后使本实例和其他实例中该 API Key 的缓存失效
+func (this *HTTPService) mutateAPIKey(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	apiKeyId, ok := this.apiKeyIdFromRequest(r)
+	handler(w, r)
+	if ok {
+		this.apiKeys.Invalidate(apiKeyId)
 	}
 }
 
+func (this *HTTPService) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	this.mutateAPIKey(w, r, api.DeleteAPIKey(this.db))
+}
+
 func (this *HTTPService) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 	handler := api.ListAPIKeys(this.db)
 	handler(w, r)
@@ -424,54 +917,111 @@ func (this *HTTPService) ListUsage(w http.ResponseWriter, r *http.Request) {
 	handler(w, r)
 }
 
+func (this *HTTPService) SummarizeUsage(w http.ResponseWriter, r *http.Request) {
+	handler := api.SummarizeUsage(this.db)
+	handler(w, r)
+}
+
+func (this *HTTPService) ExportUsage(w http.ResponseWriter, r *http.Request) {
+	handler := api.ExportUsage(this.db)
+	handler(w, r)
+}
+
 func (this *HTTPService) GetAPIKeyQuota(w http.ResponseWriter, r *http.Request) {
 	handler := api.GetAPIKeyQuota(this.db)
 	handler(w, r)
 }
 
 func (this *HTTPService) AdminMiddleware(next http.Handler) http.Handler {
-	return api.AdminMiddleware(this.db)(next)
+	adminHandler := api.AdminMiddleware(this.db, this.jwt)(next)
+	readOnlyHandler := this.adminReadOnlyMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 使用 API Key 而不是管理员 token 访问时，按 admin:read 权限检查
+		if strings.HasPrefix(this.extractAPIKey(r), models.APIKeyValuePrefix) {
+			readOnlyHandler.ServeHTTP(w, r)
+			return
+		}
+		adminHandler.ServeHTTP(w, r)
+	})
 }
 
 func (this *HTTPService) EnableAPIKey(w http.ResponseWriter, r *http.Request) {
-	handler := api.EnableAPIKey(this.db)
-	handler(w, r)
+	this.mutateAPIKey(w, r, api.EnableAPIKey(this.db))
 }
 
 func (this *HTTPService) DisableAPIKey(w http.ResponseWriter, r *http.Request) {
-	handler := api.DisableAPIKey(this.db)
-	handler(w, r)
+	this.mutateAPIKey(w, r, api.DisableAPIKey(this.db))
+}
+
+func (this *HTTPService) UpdateAPIKeyRateLimit(w http.ResponseWriter, r *http.Request) {
+	this.mutateAPIKey(w, r, api.UpdateAPIKeyRateLimit(this.db))
+}
+
+func (this *HTTPService) UpdateAPIKeyBudget(w http.ResponseWriter, r *http.Request) {
+	this.mutateAPIKey(w, r, api.UpdateAPIKeyBudget(this.db))
+}
 
-	// 清空缓存
-	this.cacheMutex.Lock()
-	this.apiKeyCache = make(map[string]*models.APIKey)
-	this.cacheMutex.Unlock()
+func (this *HTTPService) UpdateAPIKeyPermissions(w http.ResponseWriter, r *http.Request) {
+	this.mutateAPIKey(w, r, api.UpdateAPIKeyPermissions(this.db))
+}
+
+func (this *HTTPService) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	this.mutateAPIKey(w, r, api.RotateAPIKey(this.db))
 }
 
 func (this *HTTPService) Start() {
+	// 接收其他实例的 API Key 变更
+	this.apiKeys.Start(context.Background())
+
 	rHandler := mux.NewRouter()
 
+	// 识别负载均衡之后的客户端地址
+	rHandler.Use(api.ClientIPMiddleware(this.proxies))
+
 	// 管理员登录
 	mainRouter := rHandler.PathPrefix("/").Subrouter()
 	mainRouter.HandleFunc("/login/admin", this.HandleAdminLogin)
+	mainRouter.HandleFunc("/login/refresh", this.HandleAdminRefreshToken)
 
 	// 需要管理员权限的路由
 	adminRouter := rHandler.PathPrefix("/admin").Subrouter()
 	adminRouter.Use(this.AdminMiddleware)
+	adminRouter.HandleFunc("/logout", this.HandleAdminLogout)
+	adminRouter.HandleFunc("/password", this.HandleChangeAdminPassword)
+	adminRouter.HandleFunc("/totp/enroll", this.HandleEnrollAdminTOTP)
+	adminRouter.HandleFunc("/totp/confirm", this.HandleConfirmAdminTOTP)
+	adminRouter.HandleFunc("/totp/disable", this.HandleDisableAdminTOTP)
+	adminRouter.HandleFunc("/users/list", this.ListAdminUsers)
+	adminRouter.HandleFunc("/users/create", this.CreateAdminUser)
+	adminRouter.HandleFunc("/users/update", this.UpdateAdminUser)
+	adminRouter.HandleFunc("/users/{username}/delete", this.DeleteAdminUser)
 	adminRouter.HandleFunc("/apikey/create", this.CreateAPIKey)
 	adminRouter.HandleFunc("/apikey/{id}/delete", this.DeleteAPIKey)
 	adminRouter.HandleFunc("/apikey/list", this.ListAPIKeys)
 	adminRouter.HandleFunc("/apikey/quota", this.GetAPIKeyQuota)
 	adminRouter.HandleFunc("/apikey/enable", this.EnableAPIKey)
 	adminRouter.HandleFunc("/apikey/disable", this.DisableAPIKey)
+	adminRouter.HandleFunc("/apikey/ratelimit", this.UpdateAPIKeyRateLimit)
+	adminRouter.HandleFunc("/apikey/budget", this.UpdateAPIKeyBudget)
+	adminRouter.HandleFunc("/apikey/permissions", this.UpdateAPIKeyPermissions)
+	adminRouter.HandleFunc("/apikey/rotate", this.RotateAPIKey)
 	adminRouter.HandleFunc("/usage/list", this.ListUsage)
+	adminRouter.HandleFunc("/usage/summary", this.SummarizeUsage)
+	adminRouter.HandleFunc("/usage/export", this.ExportUsage)
+	adminRouter.HandleFunc("/audit/list", this.ListAuditLogs)
 
 	// 需要 API Key 的路由
 	apiRouter := rHandler.PathPrefix("/v1").Subrouter()
 	apiRouter.Use(this.APIKeyMiddleware)
 
-	apiRouter.HandleFunc("/complete", this.HandleComplete)
-	apiRouter.HandleFunc("/messages", this.HandleMessageComplete)
+	apiRouter.HandleFunc("/complete", this.RequireScope(models.ScopeComplete, this.ResponseError, this.HandleComplete))
+	apiRouter.HandleFunc("/messages", this.RequireScope(models.ScopeMessages, this.ResponseError, this.HandleMessageComplete))
+	apiRouter.HandleFunc("/messages/count_tokens", this.RequireScope(models.ScopeMessages, this.ResponseError, this.HandleCountTokens))
+	apiRouter.HandleFunc("/chat/completions", this.RequireScope(models.ScopeMessages, this.ResponseOpenAIError, this.HandleChatCompletions))
+	apiRouter.HandleFunc("/embeddings", this.RequireScope(models.ScopeEmbeddings, this.ResponseOpenAIError, this.HandleEmbeddings))
+	apiRouter.HandleFunc("/rerank", this.RequireScope(models.ScopeRerank, this.ResponseError, this.HandleRerank))
+	apiRouter.HandleFunc("/models", this.HandleListModels)
+	apiRouter.HandleFunc("/models/{id}", this.HandleGetModel)
 
 	rHandler.HandleFunc("/", this.RedirectSwagger)
 	rHandler.PathPrefix("/").Handler(http.StripPrefix("/",
@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModelInfo Anthropic /v1/models 返回的模型信息，bedrock_model_id 为扩展字段
+type ModelInfo struct {
+	Type           string `json:"type"`
+	Id             string `json:"id"`
+	DisplayName    string `json:"display_name"`
+	CreatedAt      string `json:"created_at"`
+	BedrockModelId string `json:"bedrock_model_id"`
+}
+
+type ModelListResponse struct {
+	Data    []*ModelInfo `json:"data"`
+	HasMore bool         `json:"has_more"`
+	FirstId *string      `json:"first_id"`
+	LastId  *string      `json:"last_id"`
+}
+
+// 可以直接作为模型 ID 调用的 Bedrock 模型提供商前缀
+var bedrockModelProviders = []string{"anthropic.", "amazon.", "cohere.", "meta.", "deepseek.", "mistral.", "ai21."}
+
+var (
+	modelDatePattern    = regexp.MustCompile(`(^|[-:])(20\d{6})($|[-:])`)
+	modelVersionPattern = regexp.MustCompile(`-v\d+(:\d+)?$`)
+)
+
+func isBedrockModelId(model string) bool {
+	for _, provider := range bedrockModelProviders {
+		if strings.HasPrefix(model, provider) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListModels 列出代理接受的模型：ModelMappings 中的模型名，以及 ModelMetaMap 中可直接调用的 Bedrock 模型 ID
+func ListModels(config *BedrockConfig) []*ModelInfo {
+	list := make([]*ModelInfo, 0)
+	exists := map[string]bool{}
+
+	for name, modelId := range config.ModelMappings {
+		list = append(list, NewModelInfo(name, modelId))
+		exists[name] = true
+	}
+
+	for name, meta := range ModelMetaMap {
+		if exists[name] || meta.ChannelType != 33 || !isBedrockModelId(name) {
+			continue
+		}
+		list = append(list, NewModelInfo(name, name))
+		exists[name] = true
+	}
+
+	// 与 Anthropic 一致，最新发布的模型排在前面
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt > list[j].CreatedAt
+		}
+		return list[i].Id < list[j].Id
+	})
+
+	return list
+}
+
+// PaginateModels 按 Anthropic 的游标方式分页：after_id 向后翻页，before_id 向前翻页
+func PaginateModels(list []*ModelInfo, beforeId string, afterId string, limit int) ([]*ModelInfo, bool) {
+	indexOf := func(id string) int {
+		for i, model := range list {
+			if model.Id == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	start, end := 0, len(list)
+	if len(afterId) > 0 {
+		if start = indexOf(afterId) + 1; start == 0 {
+			return []*ModelInfo{}, false
+		}
+	}
+	if len(beforeId) > 0 {
+		if end = indexOf(beforeId); end < 0 {
+			return []*ModelInfo{}, false
+		}
+	}
+	if start >= end {
+		return []*ModelInfo{}, false
+	}
+
+	page := list[start:end]
+	if len(page) <= limit {
+		return page, false
+	}
+	if len(beforeId) > 0 {
+		return page[len(page)-limit:], true
+	}
+	return page[:limit], true
+}
+
+func NewModelInfo(id string, bedrockModelId string) *ModelInfo {
+	return &ModelInfo{
+		Type:           "model",
+		Id:             id,
+		DisplayName:    ModelDisplayName(id),
+		CreatedAt:      ModelCreatedAt(id).Format(time.RFC3339),
+		BedrockModelId: bedrockModelId,
+	}
+}
+
+// ModelCreatedAt 从模型名中的日期（如 20241022）推断发布时间，没有日期时返回 Unix 零点
+func ModelCreatedAt(model string) time.Time {
+	match := modelDatePattern.FindStringSubmatch(model)
+	if match != nil {
+		if date, err := time.Parse("20060102", match[2]); err == nil {
+			return date
+		}
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// ModelDisplayName 根据模型名生成可读名称，如 claude-3-5-sonnet-20241022 => Claude 3.5 Sonnet
+func ModelDisplayName(model string) string {
+	name := model
+	for _, provider := range bedrockModelProviders {
+		name = strings.TrimPrefix(name, provider)
+	}
+	name = modelVersionPattern.ReplaceAllString(name, "")
+
+	words := make([]string, 0)
+	numbers := make([]string, 0)
+	flushNumbers := func() {
+		if len(numbers) > 0 {
+			words = append(words, strings.Join(numbers, "."))
+			numbers = numbers[:0]
+		}
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == ':' || r == '_' }) {
+		if len(part) == 8 && strings.HasPrefix(part, "20") && isDigits(part) {
+			continue
+		}
+		if isDigits(part) {
+			numbers = append(numbers, part)
+			continue
+		}
+		flushNumbers()
+		words = append(words, strings.ToUpper(part[:1])+part[1:])
+	}
+	flushNumbers()
+
+	return strings.Join(words, " ")
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
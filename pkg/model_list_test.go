@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestModelDisplayName(t *testing.T) {
+	cases := map[string]string{
+		"claude-3-5-sonnet-20241022":                "Claude 3.5 Sonnet",
+		"claude-3-haiku-20240307":                   "Claude 3 Haiku",
+		"claude-2.1":                                "Claude 2.1",
+		"anthropic.claude-3-7-sonnet-20250219-v1:0": "Claude 3.7 Sonnet",
+		"cohere.embed-english-v3":                   "Embed English",
+	}
+	for model, expected := range cases {
+		if name := ModelDisplayName(model); name != expected {
+			t.Errorf("%s: got %q, want %q", model, name, expected)
+		}
+	}
+	t.Log("PASS")
+}
+
+func TestListModels(t *testing.T) {
+	config := &BedrockConfig{
+		ModelMappings: map[string]string{
+			"claude-3-5-sonnet-20241022": "anthropic.claude-3-5-sonnet-20241022-v2:0",
+		},
+	}
+
+	list := ListModels(config)
+	for i := 1; i < len(list); i++ {
+		if list[i-1].CreatedAt < list[i].CreatedAt {
+			t.Fatalf("models not sorted by created_at: %s before %s", list[i-1].Id, list[i].Id)
+		}
+	}
+
+	found := false
+	for _, model := range list {
+		if model.Id == "claude-3-5-sonnet-20241022" {
+			found = true
+			if model.BedrockModelId != "anthropic.claude-3-5-sonnet-20241022-v2:0" || model.CreatedAt != "2024-10-22T00:00:00Z" {
+				t.Fatalf("unexpected model info: %+v", model)
+			}
+		}
+		if model.Id == "gpt-4o" || model.Id == "aws-claude3:haiku-20240307" {
+			t.Fatalf("model %s is not a bedrock model", model.Id)
+		}
+	}
+	if !found {
+		t.Fatal("mapped model not listed")
+	}
+	t.Log("PASS")
+}
+
+func TestPaginateModels(t *testing.T) {
+	list := []*ModelInfo{{Id: "a"}, {Id: "b"}, {Id: "c"}, {Id: "d"}}
+
+	page, hasMore := PaginateModels(list, "", "", 2)
+	if len(page) != 2 || page[0].Id != "a" || !hasMore {
+		t.Fatalf("first page: %v %v", page, hasMore)
+	}
+	page, hasMore = PaginateModels(list, "", "b", 2)
+	if len(page) != 2 || page[0].Id != "c" || hasMore {
+		t.Fatalf("after_id: %v %v", page, hasMore)
+	}
+	page, hasMore = PaginateModels(list, "d", "", 2)
+	if len(page) != 2 || page[0].Id != "b" || !hasMore {
+		t.Fatalf("before_id: %v %v", page, hasMore)
+	}
+	page, _ = PaginateModels(list, "", "unknown", 2)
+	if len(page) != 0 {
+		t.Fatalf("unknown cursor: %v", page)
+	}
+	t.Log("PASS")
+}
@@ -28,6 +28,8 @@ var ModelMetaMap = map[string]ModelMeta{
 	"cohere.rerank-v3-5:0":                      {Model: "cohere.rerank-v3-5:0", ChannelType: 33, ModelRatio: 0, CompletionRatio: 0},
 	"cohere.embed-english-v3":                   {Model: "cohere.embed-english-v3", ChannelType: 33, ModelRatio: 0.05, CompletionRatio: 0},
 	"cohere.embed-multilingual-v3":              {Model: "cohere.embed-multilingual-v3", ChannelType: 33, ModelRatio: 0.05, CompletionRatio: 0},
+	"amazon.titan-embed-text-v1":                {Model: "amazon.titan-embed-text-v1", ChannelType: 33, ModelRatio: 0.05, CompletionRatio: 0},
+	"amazon.titan-embed-text-v2:0":              {Model: "amazon.titan-embed-text-v2:0", ChannelType: 33, ModelRatio: 0.01, CompletionRatio: 0},
 	"deepseek.r1-v1:0":                          {Model: "deepseek.r1-v1:0", ChannelType: 33, ModelRatio: 0.675, CompletionRatio: 0.675},
 	"aws-llama3:8b":                             {Model: "aws-llama3:8b", ChannelType: 33, ModelRatio: 0.15, CompletionRatio: 0.3},
 	"aws-llama3:70b":                            {Model: "aws-llama3:70b", ChannelType: 33, ModelRatio: 1.325, CompletionRatio: 2.65},
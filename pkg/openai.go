@@ -0,0 +1,612 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI Chat Completions 协议与 Claude Messages 协议之间的转换
+
+const (
+	// OpenAI 请求未指定 max_tokens 时使用的默认值，Claude 要求必须提供 max_tokens
+	openAIDefaultMaxTokens = 4096
+	// OpenAI 流式输出的结束标记
+	openAIStreamDone = "[DONE]"
+)
+
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+type OpenAIFunction struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type OpenAITool struct {
+	Type     string          `json:"type,omitempty"`
+	Function *OpenAIFunction `json:"function,omitempty"`
+}
+
+type OpenAIJSONSchema struct {
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Strict      bool            `json:"strict,omitempty"`
+}
+
+type OpenAIResponseFormat struct {
+	Type       string            `json:"type,omitempty"`
+	JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type OpenAIImageURL struct {
+	URL    string `json:"url,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type OpenAIContentPart struct {
+	Type     string          `json:"type,omitempty"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *OpenAIImageURL `json:"image_url,omitempty"`
+}
+
+type OpenAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type OpenAIToolCall struct {
+	Index    *int                `json:"index,omitempty"`
+	Id       string              `json:"id,omitempty"`
+	Type     string              `json:"type,omitempty"`
+	Function *OpenAIFunctionCall `json:"function,omitempty"`
+}
+
+type OpenAIChatMessage struct {
+	Role string `json:"role,omitempty"`
+	// 字符串或 OpenAIContentPart 数组
+	Content    json.RawMessage   `json:"content,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	ToolCalls  []*OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string            `json:"tool_call_id,omitempty"`
+}
+
+type OpenAIChatCompletionRequest struct {
+	Model               string                `json:"model"`
+	Messages            []*OpenAIChatMessage  `json:"messages"`
+	MaxTokens           int                   `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int                   `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64              `json:"temperature,omitempty"`
+	TopP                *float64              `json:"top_p,omitempty"`
+	Stop                json.RawMessage       `json:"stop,omitempty"`
+	Stream              bool                  `json:"stream,omitempty"`
+	StreamOptions       *OpenAIStreamOptions  `json:"stream_options,omitempty"`
+	Tools               []*OpenAITool         `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage       `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool                 `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat      *OpenAIResponseFormat `json:"response_format,omitempty"`
+	User                string                `json:"user,omitempty"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type OpenAIChatResponseMessage struct {
+	Role             string            `json:"role,omitempty"`
+	Content          *string           `json:"content,omitempty"`
+	ReasoningContent string            `json:"reasoning_content,omitempty"`
+	ToolCalls        []*OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type OpenAIChatChoice struct {
+	Index        int                        `json:"index"`
+	Message      *OpenAIChatResponseMessage `json:"message,omitempty"`
+	Delta        *OpenAIChatResponseMessage `json:"delta,omitempty"`
+	FinishReason *string                    `json:"finish_reason"`
+}
+
+type OpenAIChatCompletionResponse struct {
+	Id      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []*OpenAIChatChoice `json:"choices"`
+	Usage   *OpenAIUsage        `json:"usage,omitempty"`
+}
+
+type OpenAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+type OpenAIError struct {
+	Error *OpenAIErrorDetail `json:"error"`
+}
+
+// OpenAIStreamEvent OpenAI 流式输出的一帧，事件名为空，NewSSERaw 只输出 data 行
+type OpenAIStreamEvent struct {
+	Raw []byte
+}
+
+func (this *OpenAIStreamEvent) GetBytes() []byte {
+	return this.Raw
+}
+
+func (this *OpenAIStreamEvent) GetEvent() string {
+	return ""
+}
+
+func (this *OpenAIStreamEvent) GetText() string {
+	return ""
+}
+
+func newOpenAIStreamEvent(source interface{}) *OpenAIStreamEvent {
+	raw, _ := json.Marshal(source)
+	return &OpenAIStreamEvent{Raw: raw}
+}
+
+// NewOpenAIError 将错误转换为 OpenAI 的错误格式
+func NewOpenAIError(err error) (int, *OpenAIError) {
+	proxyErr := AsProxyError(err)
+	return proxyErr.Status, &OpenAIError{Error: &OpenAIErrorDetail{
+		Message: proxyErr.Message,
+		Type:    proxyErr.Type,
+	}}
+}
+
+// parseOpenAIContent 解析 OpenAI 的消息内容，可以是字符串或内容片段数组
+func parseOpenAIContent(raw json.RawMessage) ([]*OpenAIContentPart, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []*OpenAIContentPart{{Type: "text", Text: text}}, nil
+	}
+	var parts []*OpenAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("invalid message content: %v", err)
+	}
+	return parts, nil
+}
+
+func openAIContentText(raw json.RawMessage) (string, error) {
+	parts, err := parseOpenAIContent(raw)
+	if err != nil {
+		return "", err
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// openAIImageSource 将 data URL 转换为 Claude 的 base64 图片，Bedrock 不支持远程图片 URL
+func openAIImageSource(imageURL *OpenAIImageURL) (*ClaudeMessageCompletionRequestContentSource, error) {
+	if imageURL == nil || !strings.HasPrefix(imageURL.URL, "data:") {
+		return nil, fmt.Errorf("only base64 data URLs are supported for image_url")
+	}
+	meta, data, found := strings.Cut(strings.TrimPrefix(imageURL.URL, "data:"), ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return nil, fmt.Errorf("image_url must be a base64 encoded data URL")
+	}
+	return &ClaudeMessageCompletionRequestContentSource{
+		Type:      "base64",
+		MediaType: strings.TrimSuffix(meta, ";base64"),
+		Data:      data,
+	}, nil
+}
+
+func parseOpenAIStop(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var stop string
+	if err := json.Unmarshal(raw, &stop); err == nil {
+		return []string{stop}, nil
+	}
+	var stops []string
+	if err := json.Unmarshal(raw, &stops); err != nil {
+		return nil, fmt.Errorf("invalid stop: %v", err)
+	}
+	return stops, nil
+}
+
+func parseOpenAIToolChoice(raw json.RawMessage) (*ClaudeMessageCompletionRequestToolChoice, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var choice string
+	if err := json.Unmarshal(raw, &choice); err == nil {
+		switch choice {
+		case "auto":
+			return &ClaudeMessageCompletionRequestToolChoice{Type: "auto"}, nil
+		case "required":
+			return &ClaudeMessageCompletionRequestToolChoice{Type: "any"}, nil
+		case "none":
+			return &ClaudeMessageCompletionRequestToolChoice{Type: "none"}, nil
+		}
+		return nil, fmt.Errorf("invalid tool_choice: %s", choice)
+	}
+	var named OpenAITool
+	if err := json.Unmarshal(raw, &named); err != nil || named.Function == nil {
+		return nil, fmt.Errorf("invalid tool_choice")
+	}
+	return &ClaudeMessageCompletionRequestToolChoice{Type: "tool", Name: named.Function.Name}, nil
+}
+
+// ToClaudeRequest 将 OpenAI Chat Completions 请求转换为 Claude Messages 请求
+func (this *OpenAIChatCompletionRequest) ToClaudeRequest() (*ClaudeMessageCompletionRequest, error) {
+	req := &ClaudeMessageCompletionRequest{
+		Model:    this.Model,
+		Stream:   this.Stream,
+		MaxToken: this.MaxCompletionTokens,
+		Messages: make([]*ClaudeMessageCompletionRequestMessage, 0, len(this.Messages)),
+		Tools:    make([]*ClaudeMessageCompletionRequestTools, 0, len(this.Tools)),
+	}
+	if req.MaxToken <= 0 {
+		req.MaxToken = this.MaxTokens
+	}
+	if req.MaxToken <= 0 {
+		req.MaxToken = openAIDefaultMaxTokens
+	}
+	if this.Temperature != nil {
+		// OpenAI 的 temperature 范围为 0~2，Claude 为 0~1
+		req.Temperature = *this.Temperature
+		if req.Temperature > 1 {
+			req.Temperature = 1
+		}
+	}
+	if this.TopP != nil {
+		req.TopP = *this.TopP
+	}
+	if len(this.User) > 0 {
+		req.Metadata = &ClaudeMessageCompletionRequestMetadata{UserId: this.User}
+	}
+
+	stops, err := parseOpenAIStop(this.Stop)
+	if err != nil {
+		return nil, NewInvalidRequestError(err)
+	}
+	req.StopSequences = stops
+
+	appendContent := func(role string, contents ...ClaudeMessageCompletionRequestContent) {
+		if len(contents) == 0 {
+			return
+		}
+		// Claude 要求 user / assistant 交替出现，连续相同角色的消息合并为一条
+		if last := len(req.Messages) - 1; last >= 0 && req.Messages[last].Role == role {
+			req.Messages[last].Content = append(req.Messages[last].Content, contents...)
+			return
+		}
+		req.Messages = append(req.Messages, &ClaudeMessageCompletionRequestMessage{
+			Role:    role,
+			Content: contents,
+		})
+	}
+
+	for _, msg := range this.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			text, err := openAIContentText(msg.Content)
+			if err != nil {
+				return nil, NewInvalidRequestError(err)
+			}
+			req.System = append(req.System, ClaudeMessageCompletionRequestContent{Type: "text", Text: text})
+
+		case "user":
+			parts, err := parseOpenAIContent(msg.Content)
+			if err != nil {
+				return nil, NewInvalidRequestError(err)
+			}
+			contents := make([]ClaudeMessageCompletionRequestContent, 0, len(parts))
+			for _, part := range parts {
+				switch part.Type {
+				case "text":
+					contents = append(contents, ClaudeMessageCompletionRequestContent{Type: "text", Text: part.Text})
+				case "image_url":
+					source, err := openAIImageSource(part.ImageURL)
+					if err != nil {
+						return nil, NewInvalidRequestError(err)
+					}
+					contents = append(contents, ClaudeMessageCompletionRequestContent{Type: "image", Source: source})
+				default:
+					return nil, NewInvalidRequestError(fmt.Errorf("unsupported content type: %s", part.Type))
+				}
+			}
+			appendContent("user", contents...)
+
+		case "assistant":
+			text, err := openAIContentText(msg.Content)
+			if err != nil {
+				return nil, NewInvalidRequestError(err)
+			}
+			contents := make([]ClaudeMessageCompletionRequestContent, 0, len(msg.ToolCalls)+1)
+			if len(strings.TrimSpace(text)) > 0 {
+				contents = append(contents, ClaudeMessageCompletionRequestContent{Type: "text", Text: text})
+			}
+			for _, call := range msg.ToolCalls {
+				if call.Function == nil {
+					continue
+				}
+				var input interface{} = map[string]interface{}{}
+				if len(call.Function.Arguments) > 0 {
+					if err := json.Unmarshal([]byte(call.Function.Arguments), &input); err != nil {
+						return nil, NewInvalidRequestError(fmt.Errorf("invalid tool call arguments: %v", err))
+					}
+				}
+				contents = append(contents, ClaudeMessageCompletionRequestContent{
+					Type:  "tool_use",
+					Id:    call.Id,
+					Name:  call.Function.Name,
+					Input: input,
+				})
+			}
+			appendContent("assistant", contents...)
+
+		case "tool":
+			text, err := openAIContentText(msg.Content)
+			if err != nil {
+				return nil, NewInvalidRequestError(err)
+			}
+			content, _ := json.Marshal(text)
+			appendContent("user", ClaudeMessageCompletionRequestContent{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallId,
+				Content:   content,
+			})
+
+		default:
+			return nil, NewInvalidRequestError(fmt.Errorf("unsupported message role: %s", msg.Role))
+		}
+	}
+
+	for _, tool := range this.Tools {
+		if tool.Type != "function" || tool.Function == nil {
+			continue
+		}
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		req.Tools = append(req.Tools, &ClaudeMessageCompletionRequestTools{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	req.ToolChoice, err = parseOpenAIToolChoice(this.ToolChoice)
+	if err != nil {
+		return nil, NewInvalidRequestError(err)
+	}
+	if this.ParallelToolCalls != nil && !*this.ParallelToolCalls && len(req.Tools) > 0 {
+		if req.ToolChoice == nil {
+			req.ToolChoice = &ClaudeMessageCompletionRequestToolChoice{Type: "auto"}
+		}
+		req.ToolChoice.DisableParallelToolUse = true
+	}
+
+	// Claude 没有 response_format，通过系统提示约束输出 JSON
+	if this.ResponseFormat != nil {
+		switch this.ResponseFormat.Type {
+		case "json_object":
+			req.System = append(req.System, ClaudeMessageCompletionRequestContent{
+				Type: "text",
+				Text: "Respond only with a single valid JSON object, without any surrounding text or markdown code fences.",
+			})
+		case "json_schema":
+			if this.ResponseFormat.JSONSchema == nil {
+				return nil, NewInvalidRequestError(fmt.Errorf("response_format.json_schema is required"))
+			}
+			req.System = append(req.System, ClaudeMessageCompletionRequestContent{
+				Type: "text",
+				Text: fmt.Sprintf("Respond only with a single valid JSON object, without any surrounding text or markdown code fences. "+
+					"The JSON object must conform to the following JSON schema:\n%s", string(this.ResponseFormat.JSONSchema.Schema)),
+			})
+		}
+	}
+
+	return req, nil
+}
+
+// openAIFinishReason 将 Claude 的 stop_reason 转换为 OpenAI 的 finish_reason
+func openAIFinishReason(stopReason string) *string {
+	var reason string
+	switch stopReason {
+	case "":
+		return nil
+	case "max_tokens":
+		reason = "length"
+	case "tool_use":
+		reason = "tool_calls"
+	default:
+		reason = "stop"
+	}
+	return &reason
+}
+
+func openAIUsage(usage *ClaudeMessageUsage) *OpenAIUsage {
+	if usage == nil {
+		return nil
+	}
+	return &OpenAIUsage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      usage.InputTokens + usage.OutputTokens,
+	}
+}
+
+// NewOpenAIChatCompletionResponse 将 Claude Messages 响应转换为 OpenAI Chat Completions 响应
+func NewOpenAIChatCompletionResponse(model string, resp *ClaudeMessageCompletionResponse) *OpenAIChatCompletionResponse {
+	message := &OpenAIChatResponseMessage{Role: "assistant"}
+	var text strings.Builder
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			message.ReasoningContent += block.Thinking
+		case "tool_use":
+			arguments, _ := json.Marshal(block.Input)
+			message.ToolCalls = append(message.ToolCalls, &OpenAIToolCall{
+				Id:   block.Id,
+				Type: "function",
+				Function: &OpenAIFunctionCall{
+					Name:      block.Name,
+					Arguments: string(arguments),
+				},
+			})
+		}
+	}
+	if text.Len() > 0 || len(message.ToolCalls) == 0 {
+		content := text.String()
+		message.Content = &content
+	}
+
+	return &OpenAIChatCompletionResponse{
+		Id:      "chatcmpl-" + resp.Id,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []*OpenAIChatChoice{{
+			Index:        0,
+			Message:      message,
+			FinishReason: openAIFinishReason(resp.StopReason),
+		}},
+		Usage: openAIUsage(resp.Usage),
+	}
+}
+
+// ConvertOpenAIStream 将 Claude 流式事件转换为 OpenAI chat.completion.chunk 流，以 data: [DONE] 结束
+func ConvertOpenAIStream(model string, includeUsage bool, events <-chan ISSEDecoder) <-chan ISSEDecoder {
+	eventQueue := make(chan ISSEDecoder, 10)
+
+	go func() {
+		defer close(eventQueue)
+
+		id := "chatcmpl-"
+		created := time.Now().Unix()
+		usage := &ClaudeMessageUsage{}
+		// Claude 的 content block 序号 => OpenAI 的 tool_calls 序号
+		toolIndexes := map[int]int{}
+
+		newChunk := func(delta *OpenAIChatResponseMessage, finishReason *string) *OpenAIChatCompletionResponse {
+			return &OpenAIChatCompletionResponse{
+				Id:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   model,
+				Choices: []*OpenAIChatChoice{{
+					Index:        0,
+					Delta:        delta,
+					FinishReason: finishReason,
+				}},
+			}
+		}
+
+		for event := range events {
+			streamEvent, ok := event.(*ClaudeMessageCompletionStreamEvent)
+			if !ok {
+				continue
+			}
+
+			switch streamEvent.GetEvent() {
+			case "message_start":
+				if streamEvent.Message != nil {
+					id = "chatcmpl-" + streamEvent.Message.Id
+					if streamEvent.Message.Usage != nil {
+						usage.InputTokens = streamEvent.Message.Usage.InputTokens
+					}
+				}
+				empty := ""
+				eventQueue <- newOpenAIStreamEvent(newChunk(&OpenAIChatResponseMessage{Role: "assistant", Content: &empty}, nil))
+
+			case "content_block_start":
+				block := streamEvent.ContentBlock
+				if block == nil || block.Type != "tool_use" {
+					continue
+				}
+				index := len(toolIndexes)
+				toolIndexes[streamEvent.Index] = index
+				eventQueue <- newOpenAIStreamEvent(newChunk(&OpenAIChatResponseMessage{
+					ToolCalls: []*OpenAIToolCall{{
+						Index:    &index,
+						Id:       block.Id,
+						Type:     "function",
+						Function: &OpenAIFunctionCall{Name: block.Name},
+					}},
+				}, nil))
+
+			case "content_block_delta":
+				delta := streamEvent.Delta
+				if delta == nil {
+					continue
+				}
+				switch delta.Type {
+				case "text_delta":
+					text := delta.Text
+					eventQueue <- newOpenAIStreamEvent(newChunk(&OpenAIChatResponseMessage{Content: &text}, nil))
+				case "thinking_delta":
+					eventQueue <- newOpenAIStreamEvent(newChunk(&OpenAIChatResponseMessage{ReasoningContent: delta.Thinking}, nil))
+				case "input_json_delta":
+					index := toolIndexes[streamEvent.Index]
+					eventQueue <- newOpenAIStreamEvent(newChunk(&OpenAIChatResponseMessage{
+						ToolCalls: []*OpenAIToolCall{{
+							Index:    &index,
+							Function: &OpenAIFunctionCall{Arguments: delta.PartialJson},
+						}},
+					}, nil))
+				}
+
+			case "message_delta":
+				if streamEvent.Usage != nil {
+					if usage.InputTokens <= 0 {
+						usage.InputTokens = streamEvent.Usage.InputTokens
+					}
+					usage.OutputTokens = streamEvent.Usage.OutputTokens
+				}
+				if streamEvent.Delta != nil && len(streamEvent.Delta.StopReason) > 0 {
+					eventQueue <- newOpenAIStreamEvent(newChunk(&OpenAIChatResponseMessage{}, openAIFinishReason(streamEvent.Delta.StopReason)))
+				}
+
+			case "message_stop":
+				if includeUsage {
+					eventQueue <- newOpenAIStreamEvent(&OpenAIChatCompletionResponse{
+						Id:      id,
+						Object:  "chat.completion.chunk",
+						Created: created,
+						Model:   model,
+						Choices: []*OpenAIChatChoice{},
+						Usage:   openAIUsage(usage),
+					})
+				}
+
+			case "error":
+				proxyErr := NewProxyError(http.StatusInternalServerError, ErrorTypeAPI, "stream error")
+				if streamEvent.Error != nil {
+					proxyErr = NewProxyError(http.StatusInternalServerError, streamEvent.Error.Type, streamEvent.Error.Message)
+				}
+				_, openAIErr := NewOpenAIError(proxyErr)
+				eventQueue <- newOpenAIStreamEvent(openAIErr)
+			}
+		}
+
+		eventQueue <- &OpenAIStreamEvent{Raw: []byte(openAIStreamDone)}
+	}()
+
+	return eventQueue
+}
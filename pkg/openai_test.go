@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIChatCompletionRequest_ToClaudeRequest(t *testing.T) {
+	body := `{
+		"model": "claude-3-5-sonnet-20241022",
+		"temperature": 1.5,
+		"stop": "END",
+		"parallel_tool_calls": false,
+		"messages": [
+			{"role": "system", "content": "You are helpful."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is the weather?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "sunny"},
+			{"role": "user", "content": "Thanks"}
+		],
+		"tools": [
+			{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string", "enum": ["Paris"]}}}}}
+		],
+		"tool_choice": "required"
+	}`
+
+	var openAIReq OpenAIChatCompletionRequest
+	if err := json.Unmarshal([]byte(body), &openAIReq); err != nil {
+		t.Fatal(err)
+	}
+	req, err := openAIReq.ToClaudeRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.MaxToken != openAIDefaultMaxTokens || req.Temperature != 1 {
+		t.Fatalf("unexpected max_tokens/temperature: %d %v", req.MaxToken, req.Temperature)
+	}
+	if len(req.StopSequences) != 1 || req.StopSequences[0] != "END" {
+		t.Fatalf("unexpected stop sequences: %v", req.StopSequences)
+	}
+	if len(req.System) != 1 || req.System[0].Text != "You are helpful." {
+		t.Fatalf("unexpected system: %+v", req.System)
+	}
+	// tool 结果与随后的 user 消息合并为一条 user 消息
+	if len(req.Messages) != 3 {
+		t.Fatalf("unexpected messages count: %d", len(req.Messages))
+	}
+	if req.Messages[0].Content[1].Type != "image" || req.Messages[0].Content[1].Source.MediaType != "image/png" {
+		t.Fatalf("unexpected image content: %+v", req.Messages[0].Content[1])
+	}
+	if req.Messages[1].Role != "assistant" || req.Messages[1].Content[0].Type != "tool_use" || req.Messages[1].Content[0].Id != "call_1" {
+		t.Fatalf("unexpected assistant message: %+v", req.Messages[1].Content)
+	}
+	if req.Messages[2].Content[0].Type != "tool_result" || req.Messages[2].Content[1].Text != "Thanks" {
+		t.Fatalf("unexpected tool result message: %+v", req.Messages[2].Content)
+	}
+	if len(req.Tools) != 1 || !strings.Contains(string(req.Tools[0].InputSchema), `"enum"`) {
+		t.Fatalf("unexpected tools: %+v", req.Tools)
+	}
+	if req.ToolChoice == nil || req.ToolChoice.Type != "any" || !req.ToolChoice.DisableParallelToolUse {
+		t.Fatalf("unexpected tool choice: %+v", req.ToolChoice)
+	}
+	t.Log("PASS")
+}
+
+func TestNewOpenAIChatCompletionResponse(t *testing.T) {
+	resp := &ClaudeMessageCompletionResponse{
+		Id:                "msg_1",
+		ClaudeMessageStop: ClaudeMessageStop{StopReason: "tool_use"},
+		Content: []*ClaudeMessageContentBlock{
+			{Type: "text", Text: "Let me check."},
+			{Type: "tool_use", Id: "toolu_1", Name: "get_weather", Input: map[string]interface{}{"city": "Paris"}},
+		},
+		Usage: &ClaudeMessageUsage{InputTokens: 10, OutputTokens: 5},
+	}
+
+	result := NewOpenAIChatCompletionResponse("claude-3-5-sonnet-20241022", resp)
+	choice := result.Choices[0]
+	if *choice.FinishReason != "tool_calls" || *choice.Message.Content != "Let me check." {
+		t.Fatalf("unexpected choice: %+v", choice)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Fatalf("unexpected tool calls: %+v", choice.Message.ToolCalls)
+	}
+	if result.Usage.TotalTokens != 15 {
+		t.Fatalf("unexpected usage: %+v", result.Usage)
+	}
+	t.Log("PASS")
+}
+
+func TestConvertOpenAIStream(t *testing.T) {
+	events := make(chan ISSEDecoder, 10)
+	for _, raw := range []string{
+		`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":3}}`,
+		`{"type":"message_stop"}`,
+	} {
+		var event ClaudeMessageCompletionStreamEvent
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			t.Fatal(err)
+		}
+		events <- &event
+	}
+	close(events)
+
+	var frames []string
+	for event := range ConvertOpenAIStream("claude-3-5-sonnet-20241022", true, events) {
+		frames = append(frames, string(NewSSERaw(event)))
+	}
+
+	if len(frames) != 5 {
+		t.Fatalf("unexpected frames: %q", frames)
+	}
+	if !strings.Contains(frames[1], `"content":"Hi"`) || !strings.Contains(frames[2], `"finish_reason":"stop"`) {
+		t.Fatalf("unexpected content frames: %q", frames)
+	}
+	if !strings.Contains(frames[3], `"total_tokens":13`) {
+		t.Fatalf("unexpected usage frame: %q", frames[3])
+	}
+	if frames[4] != "data: [DONE]\n\n" {
+		t.Fatalf("unexpected final frame: %q", frames[4])
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+
+	"bedrock-claude-proxy/api"
+	log "bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+
+	"github.com/gorilla/mux"
+)
+
+// 具有 admin:read 权限的 API Key 可以访问的管理接口
+var adminReadOnlyRoutes = map[string]bool{
+	"/admin/apikey/list":   true,
+	"/admin/apikey/quota":  true,
+	"/admin/usage/list":    true,
+	"/admin/usage/summary": true,
+	"/admin/usage/export":  true,
+}
+
+// NewModelNotAllowedError API Key 请求了未被授权的模型时返回的错误
+func NewModelNotAllowedError(model string) *ProxyError {
+	return NewProxyError(http.StatusForbidden, ErrorTypePermission, fmt.Sprintf("api key is not allowed to use model %s", model))
+}
+
+// NewScopeNotAllowedError API Key 访问了未被授权的接口时返回的错误
+func NewScopeNotAllowedError(scope string) *ProxyError {
+	return NewProxyError(http.StatusForbidden, ErrorTypePermission, fmt.Sprintf("api key does not have the %s scope", scope))
+}
+
+// IsModelAllowed 检查 API Key 是否允许使用模型，客户端请求的模型名或映射后的 Bedrock 模型 ID 匹配任一规则即可
+func (this *BedrockClient) IsModelAllowed(apiKey *models.APIKey, model string) bool {
+	return apiKey.IsModelAllowed(model) || apiKey.IsModelAllowed(this.resolveModelId(model))
+}
+
+// authorizeModel 在调用 Bedrock 之前检查请求的 API Key 是否允许使用该模型，设置了预算的 API Key 只能使用有价格的模型
+func (this *HTTPService) authorizeModel(request *http.Request, model string) error {
+	apiKey, ok := api.GetAPIKey(request.Context())
+	if !ok {
+		return nil
+	}
+	if this.bedrock.IsModelAllowed(apiKey, model) {
+		if apiKey.BudgetHardLimit > 0 || apiKey.BudgetSoftLimit > 0 {
+			if _, priced := this.bedrock.LookupModelMeta(model); !priced {
+				log.Logger.Warningf("Model %s has no price, refused for budgeted api key %s", model, apiKey.Name)
+				return NewUnpricedModelError(model)
+			}
+		}
+		return nil
+	}
+	if len(model) == 0 {
+		model = this.bedrock.resolveModelId(model)
+	}
+	log.Logger.Warningf("Model %s is not allowed for api key %s", model, apiKey.Name)
+	return NewModelNotAllowedError(model)
+}
+
+// filterAllowedModels 过滤出 API Key 允许使用的模型
+func (this *HTTPService) filterAllowedModels(request *http.Request, list []*ModelInfo) []*ModelInfo {
+	apiKey, ok := api.GetAPIKey(request.Context())
+	if !ok {
+		return list
+	}
+	allowed := make([]*ModelInfo, 0, len(list))
+	for _, model := range list {
+		if apiKey.IsModelAllowed(model.Id) || apiKey.IsModelAllowed(model.BedrockModelId) {
+			allowed = append(allowed, model)
+		}
+	}
+	return allowed
+}
+
+// RequireScope 检查 API Key 是否允许访问该接口，respond 用于按接口的格式输出错误
+func (this *HTTPService) RequireScope(scope string, respond func(error, http.ResponseWriter), next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if apiKey, ok := api.GetAPIKey(request.Context()); ok && !apiKey.HasScope(scope) {
+			log.Logger.Warningf("Scope %s is not allowed for api key %s", scope, apiKey.Name)
+			respond(NewScopeNotAllowedError(scope), writer)
+			return
+		}
+		next(writer, request)
+	}
+}
+
+// adminReadOnlyMiddleware 允许具有 admin:read 权限的 API Key 以 GET 方式访问只读管理接口
+func (this *HTTPService) adminReadOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey, err := this.authenticateAPIKey(this.extractAPIKey(r))
+		if err != nil {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil || r.Method != http.MethodGet || !adminReadOnlyRoutes[template] || !apiKey.HasScope(models.ScopeAdminRead) {
+			log.Logger.Warningf("Admin access denied for api key %s: %s %s", apiKey.Name, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(api.SetAPIKey(r.Context(), apiKey)))
+	})
+}
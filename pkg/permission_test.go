@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bedrock-claude-proxy/api"
+	"bedrock-claude-proxy/models"
+)
+
+func TestBedrockClient_IsModelAllowed(t *testing.T) {
+	client := &BedrockClient{config: &BedrockConfig{
+		ModelMappings: map[string]string{
+			"claude-3-5-haiku-latest": "us.anthropic.claude-3-5-haiku-20241022-v1:0",
+			"claude-opus-4-1":         "us.anthropic.claude-opus-4-1-20250805-v1:0",
+		},
+		AnthropicDefaultModel: "us.anthropic.claude-3-5-haiku-20241022-v1:0",
+	}}
+	apiKey := &models.APIKey{AllowedModels: "claude-3-5-haiku-*, *.titan-embed-*"}
+
+	cases := map[string]bool{
+		"claude-3-5-haiku-latest":      true,
+		"claude-opus-4-1":              false,
+		"amazon.titan-embed-text-v2:0": true,
+		// 未指定模型时按默认模型检查
+		"": false,
+	}
+	for model, expected := range cases {
+		if got := client.IsModelAllowed(apiKey, model); got != expected {
+			t.Errorf("%q: got %v, want %v", model, got, expected)
+		}
+	}
+
+	// 规则也可以匹配映射后的 Bedrock 模型 ID
+	apiKey.AllowedModels = "*claude-3-5-haiku-*"
+	if !client.IsModelAllowed(apiKey, "") {
+		t.Error("default model should match mapped model id")
+	}
+
+	// 未配置时不限制
+	if !client.IsModelAllowed(&models.APIKey{}, "claude-opus-4-1") {
+		t.Error("empty allowed models should allow all")
+	}
+	t.Log("PASS")
+}
+
+func TestHTTPService_RequireScope(t *testing.T) {
+	service := &HTTPService{}
+	handler := service.RequireScope(models.ScopeEmbeddings, service.ResponseOpenAIError, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	cases := map[string]int{
+		"":                    http.StatusNoContent,
+		"messages":            http.StatusForbidden,
+		"messages,embeddings": http.StatusNoContent,
+		"admin:read":          http.StatusForbidden,
+	}
+	for scopes, expected := range cases {
+		apiKey := &models.APIKey{Name: "test", Scopes: scopes}
+		request := httptest.NewRequest(http.MethodPost, "/v1/embeddings", nil)
+		request = request.WithContext(api.SetAPIKey(request.Context(), apiKey))
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		if recorder.Code != expected {
+			t.Errorf("%q: got %d, want %d", scopes, recorder.Code, expected)
+		}
+	}
+
+	if err := models.ValidateScopes([]string{"messages", "admin:write"}); err == nil {
+		t.Error("expected error for invalid scope")
+	}
+	if err := models.ValidateModelPatterns([]string{"claude-["}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	t.Log("PASS")
+}
+
+func TestHTTPService_ExtractAPIKey(t *testing.T) {
+	service := &HTTPService{conf: &Config{}}
+
+	request := httptest.NewRequest(http.MethodPost, "/v1/messages?api_key=bk-query", nil)
+	request.Header.Set("Authorization", "Bearer bk-bearer")
+	if got := service.extractAPIKey(request); got != "bk-bearer" {
+		t.Fatalf("unexpected bearer key: %s", got)
+	}
+
+	// x-api-key 优先
+	request.Header.Set("x-api-key", "bk-header")
+	if got := service.extractAPIKey(request); got != "bk-header" {
+		t.Fatalf("unexpected header key: %s", got)
+	}
+
+	// URL 参数默认不启用
+	request = httptest.NewRequest(http.MethodGet, "/v1/messages?api_key=bk-query", nil)
+	if got := service.extractAPIKey(request); got != "" {
+		t.Fatalf("query key should be disabled by default: %s", got)
+	}
+	service.conf.AllowQueryAPIKey = true
+	if got := service.extractAPIKey(request); got != "bk-query" {
+		t.Fatalf("unexpected query key: %s", got)
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bedrock-claude-proxy/models"
+)
+
+// 每个 API Key 的 RPM / 输入 TPM / 输出 TPM 限制，使用按分钟匀速补充的令牌桶实现。
+// 请求进入时按估算的输入 token 预扣，响应结束后按实际用量校正。
+
+// tokenBucket 容量为每分钟限额，令牌按 capacity/分钟 的速率补充，余额可以为负（超出预估的部分记为欠额）
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(capacity int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		last:     now,
+	}
+}
+
+func (this *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(this.last)
+	if elapsed > 0 {
+		this.tokens = math.Min(this.capacity, this.tokens+this.capacity*elapsed.Minutes())
+		this.last = now
+	}
+}
+
+// wait 返回桶内积累到 n 个令牌需要等待的时间
+func (this *tokenBucket) wait(n float64) time.Duration {
+	if this.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - this.tokens) / this.capacity * float64(time.Minute))
+}
+
+// reset 返回桶被补满的时间
+func (this *tokenBucket) reset(now time.Time) time.Time {
+	return now.Add(this.wait(this.capacity))
+}
+
+func (this *tokenBucket) remaining() int {
+	if this.tokens < 0 {
+		return 0
+	}
+	return int(this.tokens)
+}
+
+type rateLimitBuckets struct {
+	requests     *tokenBucket
+	inputTokens  *tokenBucket
+	outputTokens *tokenBucket
+}
+
+// RateLimitStatus 单项限额的状态，用于输出 anthropic-ratelimit-* 响应头
+type RateLimitStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// RateLimitResult 一次准入检查的结果，未配置的限额为 nil
+type RateLimitResult struct {
+	Allowed      bool
+	RetryAfter   time.Duration
+	Requests     *RateLimitStatus
+	InputTokens  *RateLimitStatus
+	OutputTokens *RateLimitStatus
+}
+
+// RateLimitReservation 准入时预扣的输入 token，响应结束后通过 Reconcile 校正
+type RateLimitReservation struct {
+	apiKeyId        uint
+	estimatedTokens int
+	once            sync.Once
+}
+
+type RateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[uint]*rateLimitBuckets
+	now     func() time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets: make(map[uint]*rateLimitBuckets),
+		now:     time.Now,
+	}
+}
+
+// syncBucket 根据 API Key 当前的限额创建或调整令牌桶，限额为 0 时移除
+func syncBucket(bucket *tokenBucket, limit int, now time.Time) *tokenBucket {
+	if limit <= 0 {
+		return nil
+	}
+	if bucket == nil {
+		return newTokenBucket(limit, now)
+	}
+	if bucket.capacity != float64(limit) {
+		bucket.refill(now)
+		bucket.capacity = float64(limit)
+		bucket.tokens = math.Min(bucket.tokens, bucket.capacity)
+	}
+	return bucket
+}
+
+func (this *RateLimiter) getBuckets(apiKey *models.APIKey, now time.Time) *rateLimitBuckets {
+	buckets, ok := this.buckets[apiKey.ID]
+	if !ok {
+		buckets = &rateLimitBuckets{}
+		this.buckets[apiKey.ID] = buckets
+	}
+	buckets.requests = syncBucket(buckets.requests, apiKey.RPM, now)
+	buckets.inputTokens = syncBucket(buckets.inputTokens, apiKey.InputTPM, now)
+	buckets.outputTokens = syncBucket(buckets.outputTokens, apiKey.OutputTPM, now)
+	return buckets
+}
+
+func newRateLimitStatus(bucket *tokenBucket, now time.Time) *RateLimitStatus {
+	if bucket == nil {
+		return nil
+	}
+	return &RateLimitStatus{
+		Limit:     int(bucket.capacity),
+		Remaining: bucket.remaining(),
+		Reset:     bucket.reset(now),
+	}
+}
+
+// Acquire 检查 API Key 的限额，通过时预扣 1 个请求和 estimatedTokens 个输入 token。
+// 估算值超过输入 TPM 时按 TPM 预扣，避免单个大请求永远无法通过。
+// 输出 token 数量在请求前未知，只要求余额为正。
+func (this *RateLimiter) Acquire(apiKey *models.APIKey, estimatedTokens int) (*RateLimitResult, *RateLimitReservation) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.now()
+	buckets := this.getBuckets(apiKey, now)
+
+	var retryAfter time.Duration
+	if buckets.requests != nil {
+		buckets.requests.refill(now)
+		retryAfter = max(retryAfter, buckets.requests.wait(1))
+	}
+	inputTokens := float64(estimatedTokens)
+	if buckets.inputTokens != nil {
+		buckets.inputTokens.refill(now)
+		inputTokens = math.Min(inputTokens, buckets.inputTokens.capacity)
+		retryAfter = max(retryAfter, buckets.inputTokens.wait(inputTokens))
+	}
+	if buckets.outputTokens != nil {
+		buckets.outputTokens.refill(now)
+		retryAfter = max(retryAfter, buckets.outputTokens.wait(1))
+	}
+
+	result := &RateLimitResult{Allowed: retryAfter == 0, RetryAfter: retryAfter}
+	var reservation *RateLimitReservation
+	if result.Allowed {
+		if buckets.requests != nil {
+			buckets.requests.tokens--
+		}
+		if buckets.inputTokens != nil {
+			buckets.inputTokens.tokens -= inputTokens
+		}
+		reservation = &RateLimitReservation{apiKeyId: apiKey.ID, estimatedTokens: int(inputTokens)}
+	}
+
+	result.Requests = newRateLimitStatus(buckets.requests, now)
+	result.InputTokens = newRateLimitStatus(buckets.inputTokens, now)
+	result.OutputTokens = newRateLimitStatus(buckets.outputTokens, now)
+	return result, reservation
+}
+
+// Reconcile 按实际用量校正预扣的输入 token 并扣除输出 token，每个预扣只校正一次
+func (this *RateLimiter) Reconcile(reservation *RateLimitReservation, inputTokens, outputTokens int) {
+	if reservation == nil {
+		return
+	}
+	reservation.once.Do(func() {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+
+		buckets, ok := this.buckets[reservation.apiKeyId]
+		if !ok {
+			return
+		}
+		now := this.now()
+		if buckets.inputTokens != nil {
+			buckets.inputTokens.refill(now)
+			buckets.inputTokens.tokens += float64(reservation.estimatedTokens - inputTokens)
+			buckets.inputTokens.tokens = math.Min(buckets.inputTokens.tokens, buckets.inputTokens.capacity)
+		}
+		if buckets.outputTokens != nil {
+			buckets.outputTokens.refill(now)
+			buckets.outputTokens.tokens -= float64(outputTokens)
+		}
+	})
+}
+
+type rateLimitContextKey struct{}
+
+func withRateLimitReservation(ctx context.Context, reservation *RateLimitReservation) context.Context {
+	return context.WithValue(ctx, rateLimitContextKey{}, reservation)
+}
+
+func getRateLimitReservation(ctx context.Context) *RateLimitReservation {
+	reservation, _ := ctx.Value(rateLimitContextKey{}).(*RateLimitReservation)
+	return reservation
+}
+
+// SetHeaders 输出 Anthropic 风格的 anthropic-ratelimit-* 响应头，被拒绝时输出 retry-after
+func (this *RateLimitResult) SetHeaders(header http.Header) {
+	setStatus := func(name string, status *RateLimitStatus) {
+		if status == nil {
+			return
+		}
+		header.Set(fmt.Sprintf("anthropic-ratelimit-%s-limit", name), strconv.Itoa(status.Limit))
+		header.Set(fmt.Sprintf("anthropic-ratelimit-%s-remaining", name), strconv.Itoa(status.Remaining))
+		header.Set(fmt.Sprintf("anthropic-ratelimit-%s-reset", name), status.Reset.UTC().Format(time.RFC3339))
+	}
+	setStatus("requests", this.Requests)
+	setStatus("input-tokens", this.InputTokens)
+	setStatus("output-tokens", this.OutputTokens)
+
+	if !this.Allowed {
+		header.Set("retry-after", strconv.Itoa(int(math.Ceil(this.RetryAfter.Seconds()))))
+	}
+}
+
+// EstimateRequestTokens 在请求前估算输入 token 数量，用于速率限制的预扣
+func EstimateRequestTokens(path string, body []byte) int {
+	switch {
+	case strings.HasSuffix(path, "/messages"):
+		var req ClaudeMessageCompletionRequest
+		if err := json.Unmarshal(body, &req); err == nil {
+			return EstimateMessageTokens(&req)
+		}
+	case strings.HasSuffix(path, "/chat/completions"):
+		var openAIReq OpenAIChatCompletionRequest
+		if err := json.Unmarshal(body, &openAIReq); err == nil {
+			if req, err := openAIReq.ToClaudeRequest(); err == nil {
+				return EstimateMessageTokens(req)
+			}
+		}
+	case strings.HasSuffix(path, "/messages/count_tokens"), strings.HasPrefix(path, "/v1/models"):
+		// 不消耗模型 token
+		return 0
+	}
+	return EstimateTokens(string(body))
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"bedrock-claude-proxy/models"
+)
+
+func newTestRateLimiter(now *time.Time) *RateLimiter {
+	limiter := NewRateLimiter()
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestRateLimiter_RPM(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newTestRateLimiter(&now)
+	apiKey := &models.APIKey{RPM: 2}
+	apiKey.ID = 1
+
+	for i := 0; i < 2; i++ {
+		if result, _ := limiter.Acquire(apiKey, 0); !result.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	result, reservation := limiter.Acquire(apiKey, 0)
+	if result.Allowed || reservation != nil {
+		t.Fatal("third request should be rejected")
+	}
+	if result.RetryAfter != 30*time.Second {
+		t.Fatalf("unexpected retry after: %s", result.RetryAfter)
+	}
+
+	// 30 秒后补充 1 个请求
+	now = now.Add(30 * time.Second)
+	if result, _ := limiter.Acquire(apiKey, 0); !result.Allowed {
+		t.Fatal("request should be allowed after refill")
+	}
+	t.Log("PASS")
+}
+
+func TestRateLimiter_Reconcile(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newTestRateLimiter(&now)
+	apiKey := &models.APIKey{InputTPM: 1000, OutputTPM: 100}
+	apiKey.ID = 1
+
+	result, reservation := limiter.Acquire(apiKey, 600)
+	if !result.Allowed || result.InputTokens.Remaining != 400 {
+		t.Fatalf("unexpected result: %+v", result.InputTokens)
+	}
+
+	// 实际输入 200，退回 400；输出 150 超出限额形成欠额
+	limiter.Reconcile(reservation, 200, 150)
+	limiter.Reconcile(reservation, 0, 0)
+
+	result, _ = limiter.Acquire(apiKey, 100)
+	if result.Allowed {
+		t.Fatal("request should be rejected while output tokens are in debt")
+	}
+	if result.InputTokens.Remaining != 800 || result.OutputTokens.Remaining != 0 {
+		t.Fatalf("unexpected remaining: input %d output %d", result.InputTokens.Remaining, result.OutputTokens.Remaining)
+	}
+
+	header := http.Header{}
+	result.SetHeaders(header)
+	if header.Get("retry-after") != "31" || header.Get("anthropic-ratelimit-input-tokens-limit") != "1000" {
+		t.Fatalf("unexpected headers: %v", header)
+	}
+	if len(header.Get("anthropic-ratelimit-requests-limit")) > 0 {
+		t.Fatal("requests headers should not be set without rpm limit")
+	}
+	t.Log("PASS")
+}
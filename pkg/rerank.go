@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	log "bedrock-claude-proxy/log"
+	"bedrock-claude-proxy/models"
+)
+
+// Cohere / Jina 兼容的 Rerank 接口，后端为 Bedrock 上的 Cohere Rerank 模型
+
+const (
+	// 未指定模型时使用的 rerank 模型
+	DefaultRerankModel = "cohere.rerank-v3-5:0"
+	// Cohere Rerank 单次请求最多 1000 个文档
+	rerankMaxDocuments = 1000
+	// 每 100 个文档计为 1 个搜索单元
+	rerankDocumentsPerSearchUnit = 100
+	// Bedrock 上 Cohere Rerank 3.5 的价格为每 1000 个搜索单元 2 美元
+	RerankQuotaPerSearchUnit = models.QuotaPerUSD * 2 / 1000
+)
+
+type RerankRequest struct {
+	Model string `json:"model,omitempty"`
+	Query string `json:"query"`
+	// 字符串或 {"text": "..."} 对象
+	Documents       []json.RawMessage `json:"documents"`
+	TopN            int               `json:"top_n,omitempty"`
+	ReturnDocuments bool              `json:"return_documents,omitempty"`
+	MaxTokensPerDoc int               `json:"max_tokens_per_doc,omitempty"`
+}
+
+type RerankDocument struct {
+	Text string `json:"text"`
+}
+
+type RerankResult struct {
+	Index          int             `json:"index"`
+	RelevanceScore float64         `json:"relevance_score"`
+	Document       *RerankDocument `json:"document,omitempty"`
+}
+
+type RerankBilledUnits struct {
+	SearchUnits int `json:"search_units"`
+}
+
+type RerankMeta struct {
+	BilledUnits *RerankBilledUnits `json:"billed_units"`
+}
+
+type RerankUsage struct {
+	TotalTokens int `json:"total_tokens"`
+}
+
+type RerankResponse struct {
+	Id      string          `json:"id"`
+	Model   string          `json:"model"`
+	Results []*RerankResult `json:"results"`
+	// Cohere 格式的计费信息
+	Meta *RerankMeta `json:"meta"`
+	// Jina 格式的用量信息，token 数量为本地估算
+	Usage *RerankUsage `json:"usage"`
+}
+
+type CohereRerankRequest struct {
+	Query           string   `json:"query"`
+	Documents       []string `json:"documents"`
+	TopN            int      `json:"top_n,omitempty"`
+	MaxTokensPerDoc int      `json:"max_tokens_per_doc,omitempty"`
+	ApiVersion      int      `json:"api_version"`
+}
+
+type CohereRerankResponse struct {
+	Id      string `json:"id"`
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// GetDocuments 解析 documents 字段，支持字符串与 {"text": "..."} 对象
+func (this *RerankRequest) GetDocuments() ([]string, error) {
+	if len(this.Documents) == 0 {
+		return nil, fmt.Errorf("documents must not be empty")
+	}
+	if len(this.Documents) > rerankMaxDocuments {
+		return nil, fmt.Errorf("documents must not exceed %d", rerankMaxDocuments)
+	}
+	documents := make([]string, 0, len(this.Documents))
+	for i, raw := range this.Documents {
+		var text string
+		if err := json.Unmarshal(raw, &text); err == nil {
+			documents = append(documents, text)
+			continue
+		}
+		var document RerankDocument
+		if err := json.Unmarshal(raw, &document); err != nil {
+			return nil, fmt.Errorf("documents[%d] must be a string or an object with text", i)
+		}
+		documents = append(documents, document.Text)
+	}
+	return documents, nil
+}
+
+// RerankSearchUnits 计算搜索单元数量，每 100 个文档计为 1 个搜索单元
+func RerankSearchUnits(documents int) int {
+	return (documents + rerankDocumentsPerSearchUnit - 1) / rerankDocumentsPerSearchUnit
+}
+
+// Rerank 按与 query 的相关性对文档重新排序
+func (this *BedrockClient) Rerank(ctx context.Context, req *RerankRequest) (*RerankResponse, error) {
+	if len(req.Model) == 0 {
+		req.Model = DefaultRerankModel
+	}
+	if len(req.Query) == 0 {
+		return nil, NewInvalidRequestError(fmt.Errorf("query is required"))
+	}
+	documents, err := req.GetDocuments()
+	if err != nil {
+		return nil, NewInvalidRequestError(err)
+	}
+	if req.TopN < 0 {
+		return nil, NewInvalidRequestError(fmt.Errorf("top_n must be positive"))
+	}
+
+	modelId := this.resolveModelId(req.Model)
+	log.Logger.Debugf("Rerank Model ID: %s, documents: %d", modelId, len(documents))
+
+	ctx, cancel := this.withRequestTimeout(ctx)
+	defer cancel()
+
+	var cohereResp CohereRerankResponse
+	err = this.invokeJSONModel(ctx, modelId, &CohereRerankRequest{
+		Query:           req.Query,
+		Documents:       documents,
+		TopN:            req.TopN,
+		MaxTokensPerDoc: req.MaxTokensPerDoc,
+		ApiVersion:      2,
+	}, &cohereResp)
+	if err != nil {
+		log.Logger.Error(err)
+		return nil, err
+	}
+
+	tokens := EstimateTokens(req.Query) * len(documents)
+	for _, document := range documents {
+		tokens += EstimateTokens(document)
+	}
+
+	resp := &RerankResponse{
+		Id:      cohereResp.Id,
+		Model:   req.Model,
+		Results: make([]*RerankResult, 0, len(cohereResp.Results)),
+		Meta:    &RerankMeta{BilledUnits: &RerankBilledUnits{SearchUnits: RerankSearchUnits(len(documents))}},
+		Usage:   &RerankUsage{TotalTokens: tokens},
+	}
+	for _, result := range cohereResp.Results {
+		if result.Index < 0 || result.Index >= len(documents) {
+			continue
+		}
+		item := &RerankResult{Index: result.Index, RelevanceScore: result.RelevanceScore}
+		if req.ReturnDocuments {
+			item.Document = &RerankDocument{Text: documents[result.Index]}
+		}
+		resp.Results = append(resp.Results, item)
+	}
+	return resp, nil
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRerankRequest_GetDocuments(t *testing.T) {
+	var req RerankRequest
+	body := `{"query":"capital of France","documents":["Paris is the capital of France.",{"text":"Berlin is in Germany."}],"top_n":1}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	documents, err := req.GetDocuments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 2 || documents[1] != "Berlin is in Germany." {
+		t.Fatalf("unexpected documents: %v", documents)
+	}
+
+	req.Documents = []json.RawMessage{json.RawMessage(`123`)}
+	if _, err := req.GetDocuments(); err == nil {
+		t.Fatal("expected error for invalid document")
+	}
+	t.Log("PASS")
+}
+
+func TestRerankSearchUnits(t *testing.T) {
+	cases := map[int]int{1: 1, 100: 1, 101: 2, 1000: 10}
+	for documents, units := range cases {
+		if got := RerankSearchUnits(documents); got != units {
+			t.Errorf("%d documents: got %d, want %d", documents, got, units)
+		}
+	}
+	if RerankQuotaPerSearchUnit != 1000 {
+		t.Errorf("unexpected quota per search unit: %d", RerankQuotaPerSearchUnit)
+	}
+	t.Log("PASS")
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"unicode"
+)
+
+// 本地 token 估算使用的常量，取值参考 Anthropic 文档中的说明
+const (
+	// 每条消息的角色、分隔符等固定开销
+	messageOverheadTokens = 4
+	// 启用工具时 Anthropic 注入的工具使用系统提示
+	toolUseSystemPromptTokens = 346
+	// 无法解析尺寸时图片按最大尺寸估算
+	maxImageTokens = 1600
+)
+
+// EstimateTokens 不加载词表快速估算文本的 token 数量，用于速率限制的预扣和中断请求的用量估算。
+// 中日韩字符按每字 1 个 token 计算，其他文字按每 4 个字符 1 个 token 计算。
+// 统计输入 token 请使用 Tokenizer。
+func EstimateTokens(text string) int {
+	var cjk, other int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r),
+			unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+			cjk++
+		default:
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// EstimateImageTokens 按 Anthropic 的公式 (宽 * 高) / 750 估算图片 token 数量
+func EstimateImageTokens(source *ClaudeMessageCompletionRequestContentSource) int {
+	if source == nil || source.Type != "base64" {
+		return maxImageTokens
+	}
+	raw, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return maxImageTokens
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return maxImageTokens
+	}
+	tokens := config.Width * config.Height / 750
+	if tokens > maxImageTokens {
+		tokens = maxImageTokens
+	}
+	return tokens
+}
+
+// EstimateMessageTokens 按 EstimateTokens 快速估算 Messages 请求的输入 token 数量
+func EstimateMessageTokens(req *ClaudeMessageCompletionRequest) int {
+	return countMessageTokens(req, EstimateTokens)
+}
+
+// countMessageTokens 使用 count 统计文本，加上消息开销、图片和工具提示得到 Messages 请求的输入 token 数量
+func countMessageTokens(req *ClaudeMessageCompletionRequest, count func(string) int) int {
+	tokens := 0
+
+	for i := range req.System {
+		tokens += countContentTokens(&req.System[i], count)
+	}
+
+	for _, msg := range req.Messages {
+		if msg == nil {
+			continue
+		}
+		tokens += messageOverheadTokens + count(msg.Text)
+		for i := range msg.Content {
+			tokens += countContentTokens(&msg.Content[i], count)
+		}
+	}
+
+	if len(req.Tools) > 0 {
+		tokens += toolUseSystemPromptTokens
+		if raw, err := json.Marshal(req.Tools); err == nil {
+			tokens += count(string(raw))
+		}
+	}
+
+	return tokens
+}
+
+func countContentTokens(content *ClaudeMessageCompletionRequestContent, count func(string) int) int {
+	switch content.Type {
+	case "image":
+		return EstimateImageTokens(content.Source)
+	case "tool_use":
+		raw, _ := json.Marshal(content.Input)
+		return count(content.Name) + count(string(raw))
+	case "tool_result":
+		return count(string(content.Content))
+	case "thinking":
+		return count(content.Thinking)
+	}
+	return count(content.Text)
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	if tokens := EstimateTokens(""); tokens != 0 {
+		t.Fatalf("empty text: got %d", tokens)
+	}
+	if tokens := EstimateTokens("Hello, world"); tokens != 3 {
+		t.Fatalf("english text: got %d", tokens)
+	}
+	if tokens := EstimateTokens("創作一首7言律詩"); tokens != 8 {
+		t.Fatalf("cjk text: got %d", tokens)
+	}
+	t.Log("PASS")
+}
+
+func TestEstimateMessageTokens(t *testing.T) {
+	req := &ClaudeMessageCompletionRequest{
+		System: []ClaudeMessageCompletionRequestContent{
+			{Type: "text", Text: "You are a helpful assistant."},
+		},
+		Messages: []*ClaudeMessageCompletionRequestMessage{
+			{
+				Role: "user",
+				Content: []ClaudeMessageCompletionRequestContent{
+					{Type: "text", Text: "Hello, world"},
+					{Type: "image", Source: &ClaudeMessageCompletionRequestContentSource{Type: "base64", Data: "invalid"}},
+				},
+			},
+		},
+	}
+
+	expected := EstimateTokens("You are a helpful assistant.") + messageOverheadTokens + 3 + maxImageTokens
+	if tokens := EstimateMessageTokens(req); tokens != expected {
+		t.Fatalf("got %d, want %d", tokens, expected)
+	}
+
+	req.Tools = []*ClaudeMessageCompletionRequestTools{{Name: "get_weather"}}
+	if tokens := EstimateMessageTokens(req); tokens <= expected+toolUseSystemPromptTokens {
+		t.Fatalf("tools not counted: got %d", tokens)
+	}
+	t.Log("PASS")
+}
+
+func TestIsCountTokensUnsupported(t *testing.T) {
+	cases := []struct {
+		err         error
+		unsupported bool
+	}{
+		{&types.ValidationException{Message: aws.String("The provided model doesn't support counting tokens.")}, true},
+		{&types.ValidationException{Message: aws.String("messages: field required")}, false},
+		{&types.AccessDeniedException{Message: aws.String("not authorized to count tokens")}, false},
+		{&types.ThrottlingException{Message: aws.String("Too many requests")}, false},
+		{&types.ResourceNotFoundException{Message: aws.String("model not found")}, false},
+	}
+	for _, c := range cases {
+		if got := isCountTokensUnsupported(c.err); got != c.unsupported {
+			t.Fatalf("%v: got %v, want %v", c.err, got, c.unsupported)
+		}
+	}
+	t.Log("PASS")
+}
+
+// newTestTokenizer 使用单字节加少量合并规则的小词表，避免测试依赖下载 cl100k_base
+func newTestTokenizer() *Tokenizer {
+	tokenizer := NewTokenizer("")
+	tokenizer.loadRanks = func() (map[string]int, error) {
+		ranks := make(map[string]int, 260)
+		for i := 0; i < 256; i++ {
+			ranks[string([]byte{byte(i)})] = i
+		}
+		ranks["he"] = 256
+		ranks["ll"] = 257
+		ranks["hell"] = 258
+		ranks["hello"] = 259
+		return ranks, nil
+	}
+	return tokenizer
+}
+
+func TestTokenizer_CountTokens(t *testing.T) {
+	tokenizer := newTestTokenizer()
+
+	tests := map[string]int{
+		"":            0,
+		"hello":       1,
+		"hello world": 7, // "hello" 合并为 1 个 token，" world" 没有合并规则按字节计为 6 个
+	}
+	for text, expected := range tests {
+		tokens, err := tokenizer.CountTokens(text)
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		if tokens != expected {
+			t.Fatalf("%q: got %d, want %d", text, tokens, expected)
+		}
+	}
+
+	req := &ClaudeMessageCompletionRequest{
+		Messages: []*ClaudeMessageCompletionRequestMessage{
+			{Role: "user", Content: []ClaudeMessageCompletionRequestContent{{Type: "text", Text: "hello"}}},
+		},
+	}
+	tokens, err := tokenizer.CountMessageTokens(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != messageOverheadTokens+1 {
+		t.Fatalf("got %d, want %d", tokens, messageOverheadTokens+1)
+	}
+	t.Log("PASS")
+}
+
+func TestTokenizer_LoadFailure(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	loads := 0
+	tokenizer := newTestTokenizer()
+	loadRanks := tokenizer.loadRanks
+	tokenizer.now = func() time.Time { return now }
+	tokenizer.loadRanks = func() (map[string]int, error) {
+		loads++
+		if loads == 1 {
+			return nil, errors.New("network unreachable")
+		}
+		return loadRanks()
+	}
+
+	if _, err := tokenizer.CountTokens("hello"); err == nil {
+		t.Fatal("expected error when ranks cannot be loaded")
+	}
+	// 重试间隔内不重复加载词表
+	if _, err := tokenizer.CountTokens("hello"); err == nil || loads != 1 {
+		t.Fatalf("expected cached failure, err=%v loads=%d", err, loads)
+	}
+
+	now = now.Add(tokenizerRetryInterval)
+	if tokens, err := tokenizer.CountTokens("hello"); err != nil || tokens != 1 {
+		t.Fatalf("expected reload after retry interval, tokens=%d err=%v", tokens, err)
+	}
+	t.Log("PASS")
+}
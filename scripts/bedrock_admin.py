@@ -4,6 +4,7 @@ Bedrock Claude 代理管理工具
 用于管理API密钥和查看使用情况
 """
 
+import os
 import sys
 import json
 import functools
@@ -32,10 +33,19 @@ def login(username, password):
 
     try:
         response = requests.post(url, headers=headers, json=data)
+        # 开启 TOTP 的账户需要输入验证码
+        if response.status_code == 401 and "TOTP code required" in response.text:
+            data["totp_code"] = click.prompt("TOTP验证码")
+            response = requests.post(url, headers=headers, json=data)
+        if response.status_code == 429:
+            click.echo(f"登录失败次数过多，请在 {response.headers.get('Retry-After', '')} 秒后重试", err=True)
+            return False
         response.raise_for_status()
 
         token_data = response.json()
         config.token = token_data.get("token")
+        if token_data.get("must_change_password"):
+            click.echo("当前密码为初始密码，请先使用 change_password 命令修改密码", err=True)
         return True
     except Exception as e:
         click.echo(f"登录失败: {e}", err=True)
@@ -48,8 +58,8 @@ def check_auth(func):
     @functools.wraps(func)
     def wrapper(*args, **kwargs):
         if not config.token:
-            username = "proxy"
-            password = "hello@autel.com"
+            username = os.environ.get("BEDROCK_ADMIN_USERNAME") or click.prompt("管理员用户名")
+            password = os.environ.get("BEDROCK_ADMIN_PASSWORD") or click.prompt("管理员密码", hide_input=True)
 
             if not login(username, password):
                 sys.exit(1)
@@ -75,7 +85,8 @@ def cli():
 @cli.command()
 @check_auth
 @click.option('--name', '-n', required=True, help='API密钥名称')
-def create_apikey(name):
+@click.option('--expires-at', help='过期时间，RFC3339 格式，如 2025-12-31T00:00:00Z，不指定表示永不过期')
+def create_apikey(name, expires_at):
     """创建API密钥"""
     url = f"{config.url}/admin/apikey/create"
     headers = {
@@ -83,6 +94,8 @@ def create_apikey(name):
         "Content-Type": "application/json"
     }
     data = {"name": name}
+    if expires_at:
+        data["expires_at"] = expires_at
 
     try:
         response = requests.post(url, headers=headers, json=data)
@@ -92,6 +105,8 @@ def create_apikey(name):
         click.echo(f"API密钥创建成功!")
         click.echo(f"名称: {apikey.get('name')}")
         click.echo(f"密钥: {apikey.get('value')}")
+        if apikey.get("expires_at"):
+            click.echo(f"过期时间: {apikey.get('expires_at')}")
 
         # 显示警告信息
         click.echo("\n" + "-" * 60)
@@ -101,6 +116,40 @@ def create_apikey(name):
         click.echo(f"创建API密钥失败: {e}", err=True)
 
 
+@cli.command()
+@check_auth
+@click.option('--name', '-n', required=True, help='API密钥名称')
+@click.option('--grace-period', default='24h', help='旧密钥继续有效的时间，如 30m、24h')
+@click.option('--expires-at', help='新密钥的过期时间，RFC3339 格式，不指定表示永不过期')
+def rotate_apikey(name, grace_period, expires_at):
+    """轮换API密钥，旧密钥在宽限期内仍然有效"""
+    url = f"{config.url}/admin/apikey/rotate"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+    data = {"name": name, "grace_period": grace_period}
+    if expires_at:
+        data["expires_at"] = expires_at
+
+    try:
+        response = requests.post(url, headers=headers, json=data)
+        response.raise_for_status()
+
+        apikey = response.json()
+        click.echo(f"API密钥轮换成功!")
+        click.echo(f"名称: {apikey.get('name')}")
+        click.echo(f"新密钥: {apikey.get('value')}")
+        click.echo(f"旧密钥 {apikey.get('previous_prefix')}... 有效期至: {apikey.get('previous_expires_at')}")
+
+        # 显示警告信息
+        click.echo("\n" + "-" * 60)
+        click.echo("请保存此密钥，它不会再显示!")
+        click.echo("-" * 60)
+    except Exception as e:
+        click.echo(f"轮换API密钥失败: {e}", err=True)
+
+
 @cli.command()
 @check_auth
 @click.option('--id', '-i', required=True, help='API密钥ID')
@@ -180,8 +229,9 @@ def list_apikey():
             table_data.append([
                 apikey_id,
                 apikey.get("name", ""),
-                apikey.get("value", ""),
+                apikey.get("prefix", "") + "...",
                 created_at,
+                (apikey.get("expires_at") or "永不过期").replace("T", " ").replace("Z", ""),
                 stats["total_records"],
                 stats["total_tokens"],
                 stats["total_quota"],
@@ -189,7 +239,7 @@ def list_apikey():
             ])
 
         # 使用tabulate打印表格
-        headers = ["ID", "名称", "密钥", "创建时间", "请求次数", "总Token数", "消费总额度", "消费美元"]
+        headers = ["ID", "名称", "密钥前缀", "创建时间", "过期时间", "请求次数", "总Token数", "消费总额度", "消费美元"]
         click.echo(tabulate(table_data, headers=headers, tablefmt="grid"))
     except Exception as e:
         click.echo(f"获取API密钥列表失败: {e}", err=True)
@@ -275,6 +325,102 @@ def list_usage(page, page_size, apikey, model, start, end, format, output):
         click.echo(f"获取使用记录失败: {e}", err=True)
 
 
+@cli.command()
+@check_auth
+@click.option('--group-by', '-g', 'group_by', multiple=True, type=click.Choice(['apikey', 'model']), help='分组维度，可以指定多次')
+@click.option('--period', '-p', type=click.Choice(['hour', 'day', 'month']), help='按时间段分组')
+@click.option('--apikey', '-k', help='按API密钥名称过滤')
+@click.option('--model', '-m', help='按模型名称过滤')
+@click.option('--start', help='开始日期 (YYYY-MM-DD)')
+@click.option('--end', help='结束日期 (YYYY-MM-DD)')
+@click.option('--format', '-f', type=click.Choice(['table', 'json']), default='table', help='输出格式')
+def usage_summary(group_by, period, apikey, model, start, end, format):
+    """按API密钥、模型和时间段汇总使用情况"""
+    url = f"{config.url}/admin/usage/summary"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    # 构建查询参数
+    params = {"group_by": ",".join(group_by)}
+    for key, value in [("period", period), ("apikey_name", apikey), ("model_name", model), ("start_time", start), ("end_time", end)]:
+        if value:
+            params[key] = value
+
+    try:
+        response = requests.get(url, headers=headers, params=params)
+        response.raise_for_status()
+
+        data = response.json()
+        if format == 'json':
+            click.echo(json.dumps(data, ensure_ascii=False, indent=2))
+            return
+
+        # 只显示参与分组的列
+        columns = []
+        if 'apikey' in group_by:
+            columns.append(("apikey_name", "密钥名称"))
+        if 'model' in group_by:
+            columns.append(("model_name", "模型"))
+        if period:
+            columns.append(("period", "时间段"))
+
+        table_data = []
+        for item in data.get("items", []) + [dict(data.get("total", {}), **{key: "合计" for key, _ in columns[:1]})]:
+            table_data.append([item.get(key, "") for key, _ in columns] + [
+                item.get("requests", 0),
+                item.get("input_tokens", 0),
+                item.get("output_tokens", 0),
+                item.get("quota", 0),
+                round(item.get("cost_usd", 0), 4),
+            ])
+
+        headers = [title for _, title in columns] + ["请求次数", "输入令牌", "输出令牌", "消费额度", "消费美元"]
+        click.echo(tabulate(table_data, headers=headers, tablefmt="grid"))
+    except Exception as e:
+        click.echo(f"获取使用统计失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--output', '-o', required=True, help='输出文件路径')
+@click.option('--format', '-f', type=click.Choice(['csv', 'jsonl']), default='csv', help='导出格式')
+@click.option('--group-by', '-g', 'group_by', multiple=True, type=click.Choice(['apikey', 'model']), help='按维度汇总，可以指定多次，不指定则导出明细')
+@click.option('--period', '-p', type=click.Choice(['hour', 'day', 'month']), help='按时间段汇总')
+@click.option('--apikey', '-k', help='按API密钥名称过滤')
+@click.option('--model', '-m', help='按模型名称过滤')
+@click.option('--start', help='开始日期 (YYYY-MM-DD)')
+@click.option('--end', help='结束日期 (YYYY-MM-DD)')
+@click.option('--cost', is_flag=True, help='增加美元费用列')
+def export_usage(output, format, group_by, period, apikey, model, start, end, cost):
+    """导出使用记录为CSV或JSONL文件"""
+    url = f"{config.url}/admin/usage/export"
+    headers = {
+        "Authorization": f"Bearer {config.token}"
+    }
+
+    # 构建查询参数
+    params = {"format": format, "group_by": ",".join(group_by)}
+    for key, value in [("period", period), ("apikey_name", apikey), ("model_name", model), ("start_time", start), ("end_time", end)]:
+        if value:
+            params[key] = value
+    if cost:
+        params["cost"] = "true"
+
+    try:
+        # 流式写入文件，不在内存中保留全部记录
+        with requests.get(url, headers=headers, params=params, stream=True) as response:
+            response.raise_for_status()
+            with open(output, 'wb') as f:
+                for chunk in response.iter_content(chunk_size=64 * 1024):
+                    f.write(chunk)
+
+        click.echo(f"使用记录已导出到: {output}")
+    except Exception as e:
+        click.echo(f"导出使用记录失败: {e}", err=True)
+
+
 @cli.command()
 @check_auth
 @click.option('--name', '-n', required=True, help='API密钥名称')
@@ -301,6 +447,16 @@ def get_apikey_quota(name):
         click.echo(f"总Token数量: {data.get('total_tokens', 0)}")
         click.echo(f"总配额消耗: {data.get('total_quota', 0)}")
 
+        budget = data.get('budget')
+        if budget and (budget.get('hard_limit') or budget.get('soft_limit')):
+            click.echo(click.style("预算", fg="blue", bold=True))
+            click.echo(f"周期: {budget.get('period')} (自 {budget.get('period_start')})")
+            click.echo(f"已消耗: {budget.get('spent', 0)} (${budget.get('spent_usd', 0):.4f})")
+            click.echo(f"软性预算: {budget.get('soft_limit', 0)}")
+            click.echo(f"硬性预算: {budget.get('hard_limit', 0)}")
+            if budget.get('remaining') is not None:
+                click.echo(f"剩余额度: {budget.get('remaining')}")
+
     except Exception as e:
         click.echo(f"获取API密钥配额统计失败: {e}", err=True)
 
@@ -345,5 +501,334 @@ def disable_apikey(name):
         click.echo(f"禁用API密钥失败: {e}", err=True)
 
 
+
+@cli.command()
+@check_auth
+@click.option('--name', '-n', required=True, help='API密钥名称')
+@click.option('--rpm', type=int, default=0, help='每分钟请求数，0 表示不限制')
+@click.option('--input-tpm', type=int, default=0, help='每分钟输入Token数，0 表示不限制')
+@click.option('--output-tpm', type=int, default=0, help='每分钟输出Token数，0 表示不限制')
+def ratelimit_apikey(name, rpm, input_tpm, output_tpm):
+    """设置API密钥的速率限制"""
+    url = f"{config.url}/admin/apikey/ratelimit"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.post(url, headers=headers, json={
+            "name": name,
+            "rpm": rpm,
+            "input_tpm": input_tpm,
+            "output_tpm": output_tpm
+        })
+        response.raise_for_status()
+
+        click.echo(f"API密钥速率限制设置成功!")
+    except Exception as e:
+        click.echo(f"设置API密钥速率限制失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--name', '-n', required=True, help='API密钥名称')
+@click.option('--hard-limit', type=int, default=0, help='硬性预算额度，超出后拒绝请求，0 表示不限制')
+@click.option('--soft-limit', type=int, default=0, help='软性预算额度，超出后仅告警，0 表示不限制')
+@click.option('--period', type=click.Choice(['daily', 'monthly', 'lifetime']), default='monthly', help='预算重置周期')
+def budget_apikey(name, hard_limit, soft_limit, period):
+    """设置API密钥的预算"""
+    url = f"{config.url}/admin/apikey/budget"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.post(url, headers=headers, json={
+            "name": name,
+            "hard_limit": hard_limit,
+            "soft_limit": soft_limit,
+            "period": period
+        })
+        response.raise_for_status()
+
+        click.echo(f"API密钥预算设置成功!")
+    except Exception as e:
+        click.echo(f"设置API密钥预算失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--name', '-n', required=True, help='API密钥名称')
+@click.option('--model', '-m', 'models', multiple=True, help='允许使用的模型，支持 * 通配符，可以指定多次，不指定表示不限制')
+@click.option('--scope', '-s', 'scopes', multiple=True,
+              type=click.Choice(['messages', 'complete', 'embeddings', 'rerank', 'admin:read']),
+              help='允许访问的接口，可以指定多次，不指定表示除管理接口外的全部接口')
+def permissions_apikey(name, models, scopes):
+    """设置API密钥允许使用的模型和接口"""
+    url = f"{config.url}/admin/apikey/permissions"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.post(url, headers=headers, json={
+            "name": name,
+            "allowed_models": list(models),
+            "scopes": list(scopes)
+        })
+        response.raise_for_status()
+
+        click.echo(f"API密钥权限设置成功!")
+    except Exception as e:
+        click.echo(f"设置API密钥权限失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--old-password', prompt='当前密码', hide_input=True, help='当前密码')
+@click.option('--new-password', prompt='新密码', hide_input=True, confirmation_prompt=True, help='新密码，至少12个字符')
+def change_password(old_password, new_password):
+    """修改管理员密码，修改后需要重新登录"""
+    url = f"{config.url}/admin/password"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.post(url, headers=headers, json={
+            "old_password": old_password,
+            "new_password": new_password
+        })
+        response.raise_for_status()
+
+        click.echo(f"密码修改成功，请使用新密码重新登录!")
+    except Exception as e:
+        click.echo(f"修改密码失败: {e}", err=True)
+
+
+ADMIN_ROLES = ['owner', 'key-manager', 'billing-viewer']
+
+
+@cli.command()
+@check_auth
+def list_admins():
+    """获取管理员列表"""
+    url = f"{config.url}/admin/users/list"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.get(url, headers=headers)
+        response.raise_for_status()
+
+        users = response.json().get("users", [])
+        table_data = []
+        for user in users:
+            table_data.append([
+                user.get("id", ""),
+                user.get("username", ""),
+                user.get("role", ""),
+                "是" if user.get("must_change_password") else "否",
+                user.get("created_at", "").replace("T", " ").replace("Z", ""),
+            ])
+
+        headers = ["ID", "用户名", "角色", "需要修改密码", "创建时间"]
+        click.echo(tabulate(table_data, headers=headers, tablefmt="grid"))
+    except Exception as e:
+        click.echo(f"获取管理员列表失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--username', '-u', required=True, help='管理员用户名')
+@click.option('--role', '-r', type=click.Choice(ADMIN_ROLES), required=True, help='管理员角色')
+@click.option('--password', prompt='初始密码', hide_input=True, confirmation_prompt=True, help='初始密码，至少12个字符，首次登录后必须修改')
+def create_admin(username, role, password):
+    """创建管理员"""
+    url = f"{config.url}/admin/users/create"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.post(url, headers=headers, json={
+            "username": username,
+            "password": password,
+            "role": role
+        })
+        response.raise_for_status()
+
+        click.echo(f"管理员创建成功!")
+    except Exception as e:
+        click.echo(f"创建管理员失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--username', '-u', required=True, help='管理员用户名')
+@click.option('--role', '-r', type=click.Choice(ADMIN_ROLES), help='新的角色')
+@click.option('--reset-password', is_flag=True, help='重置密码，下次登录后必须修改')
+@click.option('--reset-totp', is_flag=True, help='关闭TOTP，用于丢失验证器的管理员')
+def update_admin(username, role, reset_password, reset_totp):
+    """修改管理员角色或重置密码"""
+    url = f"{config.url}/admin/users/update"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    data = {"username": username}
+    if role:
+        data["role"] = role
+    if reset_password:
+        data["password"] = click.prompt("新密码", hide_input=True, confirmation_prompt=True)
+    if reset_totp:
+        data["reset_totp"] = True
+
+    try:
+        response = requests.post(url, headers=headers, json=data)
+        response.raise_for_status()
+
+        click.echo(f"管理员修改成功!")
+    except Exception as e:
+        click.echo(f"修改管理员失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--username', '-u', required=True, help='管理员用户名')
+@click.confirmation_option(prompt='确定要删除此管理员吗?')
+def delete_admin(username):
+    """删除管理员"""
+    url = f"{config.url}/admin/users/{username}/delete"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.delete(url, headers=headers)
+        response.raise_for_status()
+
+        click.echo(f"管理员删除成功!")
+    except Exception as e:
+        click.echo(f"删除管理员失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--page', '-p', default=1, help='页码')
+@click.option('--page-size', '-s', default=20, help='每页记录数')
+@click.option('--actor', '-u', help='按操作人过滤')
+@click.option('--action', '-a', help='按操作类型过滤，如 apikey.disable')
+@click.option('--target', '-t', help='按操作对象过滤，API密钥名称或管理员用户名')
+@click.option('--start', help='开始日期 (YYYY-MM-DD)')
+@click.option('--end', help='结束日期 (YYYY-MM-DD)')
+@click.option('--format', '-f', type=click.Choice(['table', 'json']), default='table', help='输出格式')
+def list_audit(page, page_size, actor, action, target, start, end, format):
+    """获取审计日志"""
+    url = f"{config.url}/admin/audit/list"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    # 构建查询参数
+    params = {
+        "page": page,
+        "page_size": page_size
+    }
+    for key, value in [("actor", actor), ("action", action), ("target", target), ("start_time", start), ("end_time", end)]:
+        if value:
+            params[key] = value
+
+    try:
+        response = requests.get(url, headers=headers, params=params)
+        response.raise_for_status()
+
+        data = response.json()
+        total = data.get("total", 0)
+        items = data.get("items", [])
+
+        if not items:
+            click.echo("没有找到审计日志")
+            return
+
+        if format == 'json':
+            click.echo(json.dumps(data, ensure_ascii=False, indent=2))
+            return
+
+        table_data = []
+        for item in items:
+            table_data.append([
+                item.get("id", ""),
+                item.get("created_at", "").replace("T", " ").split(".")[0],
+                item.get("actor", ""),
+                item.get("action", ""),
+                item.get("target", ""),
+                item.get("source_ip", ""),
+            ])
+
+        headers = ["ID", "时间", "操作人", "操作", "对象", "来源IP"]
+        click.echo(f"总记录数: {total} (第{page}页，每页{page_size}条)")
+        click.echo(tabulate(table_data, headers=headers, tablefmt="grid"))
+    except Exception as e:
+        click.echo(f"获取审计日志失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+def enroll_totp():
+    """为当前管理员开启TOTP两步验证"""
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.post(f"{config.url}/admin/totp/enroll", headers=headers)
+        response.raise_for_status()
+
+        data = response.json()
+        click.echo(f"请将以下密钥添加到身份验证器应用: {data.get('secret')}")
+        click.echo(f"或使用此地址生成二维码: {data.get('otpauth_url')}")
+
+        code = click.prompt("请输入身份验证器应用显示的验证码")
+        response = requests.post(f"{config.url}/admin/totp/confirm", headers=headers, json={"code": code})
+        response.raise_for_status()
+
+        click.echo(f"TOTP开启成功，之后登录需要输入验证码!")
+    except Exception as e:
+        click.echo(f"开启TOTP失败: {e}", err=True)
+
+
+@cli.command()
+@check_auth
+@click.option('--password', prompt='当前密码', hide_input=True, help='当前密码')
+@click.option('--code', prompt='TOTP验证码', help='身份验证器应用显示的验证码')
+def disable_totp(password, code):
+    """关闭当前管理员的TOTP两步验证"""
+    url = f"{config.url}/admin/totp/disable"
+    headers = {
+        "Authorization": f"Bearer {config.token}",
+        "Content-Type": "application/json"
+    }
+
+    try:
+        response = requests.post(url, headers=headers, json={"password": password, "code": code})
+        response.raise_for_status()
+
+        click.echo(f"TOTP已关闭!")
+    except Exception as e:
+        click.echo(f"关闭TOTP失败: {e}", err=True)
+
+
 if __name__ == "__main__":
     cli()